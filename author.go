@@ -0,0 +1,606 @@
+package dreamcast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/iso9660"
+	"github.com/bodgit/dreamcast/sector"
+)
+
+const (
+	// minTrackLength is the shortest track permitted, four seconds
+	minTrackLength = 300
+	// lowDensityStart is the LBA of the first track
+	lowDensityStart = 0
+	// highDensityEnd is the LBA following the last sector of the high
+	// density area. lastSector is the same point addressed as in the
+	// IP.BIN TOC, which is 150 sectors ahead of the LBA
+	highDensityEnd = lastSector - pauseData
+)
+
+var (
+	errNoIPBin          = errors.New("no IP.BIN")
+	errNoData           = errors.New("no data")
+	errImageTooLarge    = errors.New("image too large")
+	errTooManyTracks    = errors.New("too many tracks")
+	errLowDensityTooBig = errors.New("low density area too large")
+)
+
+// Builder masters a GD-ROM image from a tree of files and an IP.BIN. The
+// low density area holds a data track and an audio track, the high density
+// area holds the IP.BIN and ISO 9660 filesystem starting at
+// gdi.TrackThreeStart, optionally followed by audio tracks and a final data
+// track. The files are placed at the end of the last data track, finishing
+// at the last sector of the disc, as that is the outer edge where reading
+// is fastest.
+type Builder struct {
+	// IPBin is the initial program written to the start of the third
+	// track, the TOC is replaced to match the image
+	IPBin *IPBin
+	// Data contains the files of the high density area
+	Data fs.FS
+	// Order lists files within Data that should be placed last, nearest
+	// the outer edge, in the order given. Any other files are placed
+	// before them in lexical order
+	Order []string
+	// LowDensity optionally contains the files of the first track, which
+	// is usually a warning for anyone trying to read it in a CD-ROM drive
+	LowDensity fs.FS
+	// Audio contains the files named by Warning and AudioTracks
+	Audio fs.FS
+	// Warning optionally names the raw CDDA file within Audio used for
+	// the second track, the spoken warning played by a CD player. It is
+	// silent otherwise
+	Warning string
+	// AudioTracks lists the raw CDDA files within Audio, 16-bit stereo
+	// samples at 44.1 kHz, that become audio tracks between the third
+	// track and the final data track
+	AudioTracks []string
+	// VolumeID is the volume identifier of both filesystems
+	VolumeID string
+	// ModTime is the creation time of both filesystems
+	ModTime time.Time
+}
+
+// placement is a file with the LBA of its first sector
+type placement struct {
+	fsys   fs.FS
+	name   string
+	size   int64
+	extent int
+}
+
+func blocks(size int64) int {
+	return int((size + iso9660.BlockSize - 1) / iso9660.BlockSize)
+}
+
+// addFiles adds every file and directory in fsys to the volume, returning
+// the files in lexical order
+func addFiles(v *iso9660.Volume, fsys fs.FS) ([]placement, error) {
+	var files []placement
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return v.AddDir(name, fi.ModTime())
+		}
+
+		if err := v.AddFile(name, fi.Size(), fi.ModTime()); err != nil {
+			return err
+		}
+		files = append(files, placement{fsys: fsys, name: name, size: fi.Size()})
+
+		return nil
+	})
+
+	return files, err
+}
+
+// order moves the listed files to the end, in the order given
+func order(files []placement, names []string) ([]placement, error) {
+	index := make(map[string]int, len(files))
+	for i, f := range files {
+		index[f.name] = i
+	}
+
+	last := make([]placement, 0, len(names))
+	for _, name := range names {
+		i, ok := index[path.Clean(name)]
+		if !ok {
+			return nil, &fs.PathError{Op: "order", Path: name, Err: fs.ErrNotExist}
+		}
+		last = append(last, files[i])
+		delete(index, files[i].name)
+	}
+
+	first := make([]placement, 0, len(files))
+	for _, f := range files {
+		if _, ok := index[f.name]; ok {
+			first = append(first, f)
+		}
+	}
+
+	return append(first, last...), nil
+}
+
+// place gives each file an extent, packing them one after the other from
+// the LBA start
+func place(v *iso9660.Volume, files []placement, start int) error {
+	for i := range files {
+		files[i].extent = start
+		if err := v.SetExtent(files[i].name, int64(start)); err != nil {
+			return err
+		}
+		start += blocks(files[i].size)
+	}
+	return nil
+}
+
+// dataTrack is the content of a data track, head is written from the
+// first sector followed by the files and then empty sectors
+type dataTrack struct {
+	head  []byte
+	files []placement
+}
+
+// writeUserData writes the contents of r as Mode 1 sectors starting from
+// the LBA, padding the last sector with zeroes. It returns the number of
+// sectors written
+func writeUserData(w io.Writer, lba int, r io.Reader) (int, error) {
+	b := make([]byte, sector.DataSize)
+	for n := 0; ; n++ {
+		switch _, err := io.ReadFull(r, b); err {
+		case io.EOF:
+			return n, nil
+		case io.ErrUnexpectedEOF, nil:
+		default:
+			return n, err
+		}
+
+		s, err := sector.New(sector.Mode1, lba+n, b)
+		if err != nil {
+			return n, err
+		}
+
+		if _, err := w.Write(s); err != nil {
+			return n, err
+		}
+
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+func (t dataTrack) write(w io.Writer, start, length int) error {
+	n, err := writeUserData(w, start, bytes.NewReader(t.head))
+	if err != nil {
+		return err
+	}
+	lba := start + n
+
+	for _, f := range t.files {
+		if err := writeSectors(w, sector.Mode1, lba, f.extent-lba); err != nil {
+			return err
+		}
+		lba = f.extent
+
+		file, err := f.fsys.Open(f.name)
+		if err != nil {
+			return err
+		}
+
+		n, err := writeUserData(w, lba, io.LimitReader(file, f.size))
+		file.Close()
+		if err != nil {
+			return err
+		}
+		lba += n
+	}
+
+	return writeSectors(w, sector.Mode1, lba, start+length-lba)
+}
+
+// audioTrack is an audio track read from a raw CDDA file
+type audioTrack struct {
+	fsys fs.FS
+	name string
+}
+
+func (t audioTrack) write(w io.Writer, length int) error {
+	if t.fsys == nil {
+		return writeSectors(w, sector.ModeUnknown, 0, length)
+	}
+
+	file, err := t.fsys.Open(t.name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(w, file)
+	if err != nil {
+		return err
+	}
+
+	// Pad the last sector with silence, followed by any more sectors
+	// needed to reach the length of the track
+	if _, err := w.Write(make([]byte, (gdi.SectorSize-n%gdi.SectorSize)%gdi.SectorSize)); err != nil {
+		return err
+	}
+
+	return writeSectors(w, sector.ModeUnknown, 0, length-int((n+gdi.SectorSize-1)/gdi.SectorSize))
+}
+
+// audioLength returns the length in sectors of the audio track read from
+// the named raw CDDA file
+func audioLength(fsys fs.FS, name string) (int, error) {
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+
+	length := int((fi.Size() + gdi.SectorSize - 1) / gdi.SectorSize)
+	if length < minTrackLength {
+		length = minTrackLength
+	}
+
+	return length, nil
+}
+
+// builtTrack is a track along with its content and length in sectors
+type builtTrack struct {
+	gdi.Track
+	length int
+	data   *dataTrack
+	audio  *audioTrack
+}
+
+// lowDensity lays out the first two tracks
+func (b Builder) lowDensity() ([]builtTrack, error) {
+	v := iso9660.NewVolume(lowDensityStart)
+	v.ID = b.VolumeID
+	v.ModTime = b.ModTime
+
+	var files []placement
+	if b.LowDensity != nil {
+		var err error
+		if files, err = addFiles(v, b.LowDensity); err != nil {
+			return nil, err
+		}
+	}
+
+	end := lowDensityStart + int(v.Blocks())
+	if err := place(v, files, end); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		end += blocks(f.size)
+	}
+
+	length := end - lowDensityStart
+	if length < minTrackLength {
+		length = minTrackLength
+	}
+
+	warning, warningLength := &audioTrack{}, minTrackLength
+	if b.Warning != "" {
+		var err error
+		if warningLength, err = audioLength(b.Audio, b.Warning); err != nil {
+			return nil, err
+		}
+		warning = &audioTrack{fsys: b.Audio, name: b.Warning}
+	}
+
+	// Both tracks have to fit before the high density area, allowing for
+	// the gap between them
+	if lowDensityStart+length+pauseData+warningLength > gdi.TrackThreeStart {
+		return nil, errLowDensityTooBig
+	}
+
+	head, err := v.MarshalBinary(int64(length))
+	if err != nil {
+		return nil, err
+	}
+
+	return []builtTrack{
+		{
+			Track: gdi.Track{
+				Number: 1,
+				Start:  lowDensityStart,
+				Type:   gdi.TypeData,
+			},
+			length: length,
+			data:   &dataTrack{head: head, files: files},
+		},
+		{
+			Track: gdi.Track{
+				Number: 2,
+				Start:  lowDensityStart + length + pauseData,
+				Type:   gdi.TypeAudio,
+			},
+			length: warningLength,
+			audio:  warning,
+		},
+	}, nil
+}
+
+// highDensity lays out the third track onwards
+func (b Builder) highDensity() ([]builtTrack, error) {
+	v := iso9660.NewVolume(gdi.TrackThreeStart)
+	v.ID = b.VolumeID
+	v.ModTime = b.ModTime
+
+	files, err := addFiles(v, b.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if files, err = order(files, b.Order); err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for _, f := range files {
+		size += blocks(f.size)
+	}
+
+	tracks := []builtTrack{
+		{
+			Track: gdi.Track{
+				Number: 3,
+				Start:  gdi.TrackThreeStart,
+				Type:   gdi.TypeData,
+			},
+			length: highDensityEnd - gdi.TrackThreeStart,
+			data:   &dataTrack{},
+		},
+	}
+	last, start := &tracks[0], gdi.TrackThreeStart+int(v.Blocks())
+
+	if len(b.AudioTracks) > 0 {
+		tracks[0].length = int(v.Blocks())
+		if tracks[0].length < minTrackLength {
+			tracks[0].length = minTrackLength
+		}
+
+		end := gdi.TrackThreeStart + tracks[0].length
+		for _, name := range b.AudioTracks {
+			length, err := audioLength(b.Audio, name)
+			if err != nil {
+				return nil, err
+			}
+
+			tracks = append(tracks, builtTrack{
+				Track: gdi.Track{
+					Number: len(tracks) + 3,
+					Start:  end + pauseData,
+					Type:   gdi.TypeAudio,
+				},
+				length: length,
+				audio:  &audioTrack{fsys: b.Audio, name: name},
+			})
+			end += pauseData + length
+		}
+
+		// The final data track is preceded by a pregap as well as the
+		// usual pause
+		start = end + preGap + pauseData
+		tracks = append(tracks, builtTrack{
+			Track: gdi.Track{
+				Number: len(tracks) + 3,
+				Start:  start,
+				Type:   gdi.TypeData,
+			},
+			length: highDensityEnd - start,
+			data:   &dataTrack{},
+		})
+		last = &tracks[len(tracks)-1]
+	}
+
+	if len(tracks) > tocEntries {
+		return nil, errTooManyTracks
+	}
+
+	if highDensityEnd-size < start {
+		return nil, errImageTooLarge
+	}
+
+	if err := place(v, files, highDensityEnd-size); err != nil {
+		return nil, err
+	}
+	last.data.files = files
+
+	// IP.BIN occupies the system area of the volume
+	ip := *b.IPBin
+	ip.TOC = nil
+	for i, t := range tracks {
+		kind := typeAudio
+		if t.IsDataTrack() {
+			kind = typeData
+		}
+
+		length := highDensityEnd - t.Start
+		if i+1 < len(tracks) {
+			length = tracks[i+1].Start - t.Start - pauseData
+		}
+
+		ip.TOC = append(ip.TOC, Track{
+			Start:  t.Start,
+			Length: length,
+			Type:   kind,
+		})
+	}
+
+	ipBin, err := ip.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := v.MarshalBinary(highDensityEnd)
+	if err != nil {
+		return nil, err
+	}
+	copy(head, ipBin)
+	tracks[0].data.head = head
+
+	return tracks, nil
+}
+
+// Write writes the image using the passed Writer. The tracks are written in
+// either the TOSEC or Redump layout as per the WriterConfig, and named with
+// the TrackRename function, or as a GDemu device expects if that is unset.
+// Either or both of a GDI file and cue sheet are written
+func (b Builder) Write(writer Writer) error {
+	if b.IPBin == nil {
+		return errNoIPBin
+	}
+
+	if b.Data == nil {
+		return errNoData
+	}
+
+	tracks, err := b.lowDensity()
+	if err != nil {
+		return err
+	}
+
+	high, err := b.highDensity()
+	if err != nil {
+		return err
+	}
+	tracks = append(tracks, high...)
+
+	gdiFile, lengths, pauses := layout(tracks, writer.Config())
+
+	for i, track := range tracks {
+		if err := b.writeTrack(writer, track, gdiFile.Tracks[i], hasPregap(tracks, i)); err != nil {
+			return fmt.Errorf("track %d: %w", track.Number, err)
+		}
+	}
+
+	if writer.Config().GDIFile != "" {
+		if err := writeGDIFile(writer, gdiFile); err != nil {
+			return err
+		}
+	}
+
+	// Every track is in the single session of a GD-ROM
+	if writer.Config().CueFile != "" {
+		if err := writeCueFile(writer, gdiFile, make([]trackFile, len(tracks)), lengths, pauses); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasPregap returns true if the track is the audio track followed by the
+// final data track, the pregap of which is at the end of the audio track
+// in the TOSEC layout
+func hasPregap(tracks []builtTrack, i int) bool {
+	return i+1 > 2 && i+1 < len(tracks) && tracks[i].IsAudioTrack() && tracks[i+1].IsDataTrack()
+}
+
+// layout returns the GDI file describing the tracks in either layout as per
+// the WriterConfig, along with the number of sectors written to each file
+// and how many of those precede the start of each track
+func layout(tracks []builtTrack, config WriterConfig) (*gdi.File, []int, []int) {
+	gdiFile := &gdi.File{
+		Count:  len(tracks),
+		Tracks: make([]gdi.Track, len(tracks)),
+	}
+	lengths := make([]int, len(tracks))
+	pauses := make([]int, len(tracks))
+
+	rename := config.TrackRename
+	if rename == nil {
+		rename = GDemuTrackName
+	}
+
+	for i, track := range tracks {
+		track.SectorSize = gdi.SectorSize
+		if config.Redump {
+			switch {
+			case i > 2 && track.IsDataTrack():
+				// The final data track after any audio tracks
+				track.Start -= preGap + pauseData
+			case track.IsAudioTrack():
+				track.Start -= pauseData
+			}
+		}
+
+		track.Name = rename(track.Track)
+		gdiFile.Tracks[i] = track.Track
+
+		pauses[i] = tracks[i].Start - track.Start
+		lengths[i] = pauses[i] + track.length
+		if hasPregap(tracks, i) && !config.Redump {
+			lengths[i] += preGap
+		}
+	}
+
+	return gdiFile, lengths, pauses
+}
+
+// writeTrack writes the track to its file in either layout. In the Redump
+// layout the pause, and any pregap, is at the start of the track whereas in
+// the TOSEC layout the pause is omitted and the pregap is at the end of the
+// previous track
+func (b Builder) writeTrack(writer Writer, track builtTrack, dst gdi.Track, pregap bool) error {
+	w, err := createTrackFile(writer, dst)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTrackSectors(w, writer.Config(), track, dst, pregap); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// writeTrackSectors writes the sectors of the track to w, along with any
+// pause and pregap needed by the layout
+func writeTrackSectors(w io.Writer, config WriterConfig, track builtTrack, dst gdi.Track, pregap bool) error {
+	if config.Redump && track.Start != dst.Start {
+		mode := sector.ModeUnknown
+		if track.IsDataTrack() {
+			mode = sector.Mode1
+		}
+		if err := writeSectors(w, mode, dst.Start, track.Start-dst.Start); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if track.data != nil {
+		err = track.data.write(w, track.Start, track.length)
+	} else {
+		err = track.audio.write(w, track.length)
+	}
+	if err != nil {
+		return err
+	}
+
+	if pregap && !config.Redump {
+		return writeSectors(w, sector.Mode1, track.Start+track.length, preGap)
+	}
+
+	return nil
+}
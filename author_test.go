@@ -0,0 +1,230 @@
+package dreamcast
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/stretchr/testify/assert"
+)
+
+// memWriter is both a Writer and a Reader that keeps any GDI file or cue
+// sheet in memory and only records the size of each track
+type memWriter struct {
+	config WriterConfig
+	files  map[string][]byte
+	sizes  map[string]uint64
+}
+
+func newMemWriter(config WriterConfig) *memWriter {
+	return &memWriter{
+		config: config,
+		files:  make(map[string][]byte),
+		sizes:  make(map[string]uint64),
+	}
+}
+
+type memFile struct {
+	bytes.Buffer
+	name string
+	w    *memWriter
+}
+
+func (f *memFile) Close() error {
+	f.w.files[f.name] = f.Bytes()
+	f.w.sizes[f.name] = uint64(f.Len())
+	return nil
+}
+
+func (w *memWriter) Close() error         { return nil }
+func (w *memWriter) Config() WriterConfig { return w.config }
+func (w *memWriter) Tx() uint64           { return 0 }
+func (w *memWriter) Rx() uint64           { return 0 }
+func (w *memWriter) FindGDIFile() (io.ReadCloser, string, error) {
+	return w.open(w.config.GDIFile)
+}
+func (w *memWriter) FindCueFile() (io.ReadCloser, string, error) {
+	return w.open(w.config.CueFile)
+}
+
+func (w *memWriter) CreateFile(name string) (io.WriteCloser, error) {
+	return &memFile{name: name, w: w}, nil
+}
+
+func (w *memWriter) open(name string) (io.ReadCloser, string, error) {
+	b, ok := w.files[name]
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), name, nil
+}
+
+func (w *memWriter) OpenFile(name string) (io.ReadCloser, error) {
+	rc, _, err := w.open(name)
+	return rc, err
+}
+
+func (w *memWriter) FileSize(name string) (uint64, error) {
+	size, ok := w.sizes[name]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return size, nil
+}
+
+func TestOrder(t *testing.T) {
+	files := []placement{{name: "1ST_READ.BIN"}, {name: "A/B.DAT"}, {name: "C.DAT"}}
+
+	ordered, err := order(files, []string{"C.DAT", "1ST_READ.BIN"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []placement{{name: "A/B.DAT"}, {name: "C.DAT"}, {name: "1ST_READ.BIN"}}, ordered)
+
+	_, err = order(files, []string{"MISSING"})
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestBuilderLayout(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	b := Builder{
+		IPBin: ip,
+		Data: fstest.MapFS{
+			"1ST_READ.BIN": {Data: make([]byte, 5000)},
+			"DIR/DATA.BIN": {Data: make([]byte, 10)},
+		},
+		Order:       []string{"1ST_READ.BIN"},
+		Audio:       fstest.MapFS{"track04.raw": {Data: make([]byte, 400*gdi.SectorSize+1)}},
+		AudioTracks: []string{"track04.raw"},
+	}
+
+	low, err := b.lowDensity()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 0, low[0].Start)
+	assert.Equal(t, minTrackLength, low[0].length)
+	assert.Equal(t, 450, low[1].Start)
+
+	high, err := b.highDensity()
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.Len(t, high, 3) {
+		return
+	}
+	assert.Equal(t, gdi.TrackThreeStart, high[0].Start)
+	assert.Equal(t, 45450, high[1].Start)
+	assert.Equal(t, 401, high[1].length)
+	assert.Equal(t, 46076, high[2].Start)
+	assert.Equal(t, highDensityEnd, high[2].Start+high[2].length)
+
+	// The ordered file is placed last, finishing at the last sector
+	files := high[2].data.files
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "DIR/DATA.BIN", files[0].name)
+		assert.Equal(t, highDensityEnd-4, files[0].extent)
+		assert.Equal(t, "1ST_READ.BIN", files[1].name)
+		assert.Equal(t, highDensityEnd-3, files[1].extent)
+	}
+
+	c := new(IPBin)
+	assert.Nil(t, c.UnmarshalBinary(high[0].data.head[:ipBinLength]))
+	assert.Equal(t, []Track{
+		{Start: 45000, Length: 300, Type: typeData},
+		{Start: 45450, Length: 476, Type: typeAudio},
+		{Start: 46076, Length: highDensityEnd - 46076, Type: typeData},
+	}, c.TOC)
+
+	b.AudioTracks = nil
+	high, err = b.highDensity()
+	if assert.Nil(t, err) && assert.Len(t, high, 1) {
+		assert.Equal(t, highDensityEnd-gdi.TrackThreeStart, high[0].length)
+	}
+
+	b.Data = fstest.MapFS{"ONE.BIN": {Data: make([]byte, 1)}}
+	b.Order = nil
+	high, err = b.highDensity()
+	assert.Nil(t, err)
+	assert.Equal(t, highDensityEnd-1, high[0].data.files[0].extent)
+}
+
+func TestBuilderCueFile(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	b := Builder{
+		IPBin:       ip,
+		Data:        fstest.MapFS{"1ST_READ.BIN": {Data: make([]byte, 5000)}},
+		Audio:       fstest.MapFS{"track04.raw": {Data: make([]byte, 400*gdi.SectorSize)}},
+		AudioTracks: []string{"track04.raw"},
+	}
+
+	low, err := b.lowDensity()
+	if !assert.Nil(t, err) {
+		return
+	}
+	high, err := b.highDensity()
+	if !assert.Nil(t, err) {
+		return
+	}
+	tracks := append(low, high...)
+
+	// The tracks read back from the cue sheet match the GDI file in
+	// either layout
+	for _, redump := range []bool{false, true} {
+		w := newMemWriter(WriterConfig{CueFile: "disc.cue", Redump: redump})
+
+		gdiFile, lengths, pauses := layout(tracks, w.Config())
+		if !assert.Nil(t, writeCueFile(w, gdiFile, make([]trackFile, len(tracks)), lengths, pauses)) {
+			return
+		}
+		for i, track := range gdiFile.Tracks {
+			w.sizes[track.Name] = uint64(lengths[i]) * gdi.SectorSize
+		}
+
+		g := &Game{reader: w, gdiFile: new(gdi.File)}
+		if assert.Nil(t, g.newFromCueFile()) {
+			assert.Equal(t, gdiFile.Tracks, g.gdiFile.Tracks)
+		}
+	}
+}
+
+func TestBuilderTooManyTracks(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	b := Builder{
+		IPBin: ip,
+		Data:  fstest.MapFS{"1ST_READ.BIN": {Data: make([]byte, 1)}},
+		Audio: fstest.MapFS{"track.raw": {Data: make([]byte, gdi.SectorSize)}},
+	}
+
+	// The TOC has an entry for every track from the third onwards
+	for i := 0; i < tocEntries-2; i++ {
+		b.AudioTracks = append(b.AudioTracks, "track.raw")
+	}
+	high, err := b.highDensity()
+	if assert.Nil(t, err) {
+		assert.Len(t, high, tocEntries)
+		assert.Equal(t, tocEntries+2, high[len(high)-1].Number)
+	}
+
+	b.AudioTracks = append(b.AudioTracks, "track.raw")
+	_, err = b.highDensity()
+	assert.Equal(t, errTooManyTracks, err)
+}
@@ -0,0 +1,47 @@
+package cdi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+func ExampleWriter() {
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+
+	// The first session holds a single audio track
+	audio, err := w.CreateTrack(Track{Mode: ModeAudio, SectorSize: 2352, Pregap: 150, Start: 0})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := audio.Write(make([]byte, (150+302)*2352)); err != nil {
+		panic(err)
+	}
+
+	// The second session holds the data track
+	if err := w.NewSession(); err != nil {
+		panic(err)
+	}
+	data, err := w.CreateTrack(Track{Mode: Mode2, SectorSize: 2336, Pregap: 150, Start: 11702})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := data.Write(make([]byte, (150+1000)*2336)); err != nil {
+		panic(err)
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	image, err := NewImage(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, session := range image.Sessions {
+		fmt.Println(session.Tracks[0].Start, session.Tracks[0].Length)
+	}
+	// Output: 0 302
+	// 11702 1000
+}
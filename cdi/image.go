@@ -0,0 +1,297 @@
+/*
+Package cdi implements reading and writing of DiscJuggler CDI images. The
+session and track descriptors stored at the end of the image are parsed to
+locate the data of each track.
+*/
+package cdi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// Extension is the conventional file extension used
+	Extension = ".cdi"
+)
+
+// Version represents the version of the descriptor format
+type Version uint32
+
+const (
+	// Version2 is used by DiscJuggler 2.x images
+	Version2 Version = 0x80000004
+	// Version3 is used by DiscJuggler 3.0 images
+	Version3 Version = 0x80000005
+	// Version35 is used by DiscJuggler 3.5 and later images
+	Version35 Version = 0x80000006
+)
+
+// Mode represents the mode of a track
+type Mode int
+
+const (
+	// ModeAudio is used for audio tracks
+	ModeAudio Mode = iota
+	// Mode1 is used for Mode 1 data tracks
+	Mode1
+	// Mode2 is used for Mode 2 data tracks
+	Mode2
+)
+
+var sectorSizes = map[uint32]int{
+	0: 2048,
+	1: 2336,
+	2: 2352,
+	4: 2448,
+}
+
+var trackStartMark = []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff}
+
+var (
+	errInvalidVersion    = errors.New("invalid version")
+	errInvalidOffset     = errors.New("invalid descriptor offset")
+	errInvalidTrack      = errors.New("invalid track descriptor")
+	errInvalidMode       = errors.New("invalid track mode")
+	errInvalidSectorSize = errors.New("invalid sector size")
+	errInvalidLength     = errors.New("invalid track length")
+)
+
+// Image represents a CDI image
+type Image struct {
+	// Version is the version of the descriptor format
+	Version Version
+	// Sessions contains each session
+	Sessions []Session
+
+	r io.ReaderAt
+}
+
+// Session represents a single session within a CDI image
+type Session struct {
+	// Tracks contains each track
+	Tracks []Track
+}
+
+// Track represents a single track within a CDI image
+type Track struct {
+	// Mode is the mode of the track, audio or data
+	Mode Mode
+	// SectorSize is the size of each sector stored in the image
+	SectorSize int
+	// Pregap is the number of pregap sectors stored before the track
+	Pregap int
+	// Length is the length of the track in sectors, excluding the pregap
+	Length int
+	// Start is the logical block address of the track, excluding the
+	// pregap
+	Start int
+	// Offset is the offset in bytes of the start of the pregap within
+	// the image
+	Offset int64
+}
+
+// IsAudioTrack returns true if the track is audio
+func (t Track) IsAudioTrack() bool {
+	return t.Mode == ModeAudio
+}
+
+// IsDataTrack returns true if the track is data
+func (t Track) IsDataTrack() bool {
+	return t.Mode == Mode1 || t.Mode == Mode2
+}
+
+// Tracks returns every track across all sessions
+func (i Image) Tracks() []Track {
+	var tracks []Track
+	for _, session := range i.Sessions {
+		tracks = append(tracks, session.Tracks...)
+	}
+	return tracks
+}
+
+// TrackReader returns an io.SectionReader covering the sectors of the
+// track, excluding the pregap
+func (i Image) TrackReader(t Track) *io.SectionReader {
+	return io.NewSectionReader(i.r, t.Offset+int64(t.Pregap*t.SectorSize), int64(t.Length*t.SectorSize))
+}
+
+type descriptorReader struct {
+	r   *io.SectionReader
+	err error
+}
+
+func (d *descriptorReader) skip(n int64) {
+	if d.err == nil {
+		_, d.err = d.r.Seek(n, io.SeekCurrent)
+	}
+}
+
+func (d *descriptorReader) read(v interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.r, binary.LittleEndian, v)
+	}
+}
+
+func (d *descriptorReader) readTrack(version Version) (Track, uint32) {
+	var value uint32
+
+	// Images written by DiscJuggler 3.00.780 onwards have extra data
+	d.read(&value)
+	if value != 0 {
+		d.skip(8)
+	}
+
+	mark := make([]byte, 2*len(trackStartMark))
+	d.read(mark)
+	if d.err == nil && (!bytes.Equal(mark[:len(trackStartMark)], trackStartMark) || !bytes.Equal(mark[len(trackStartMark):], trackStartMark)) {
+		d.err = errInvalidTrack
+	}
+
+	d.skip(4)
+	var filenameLength uint8
+	d.read(&filenameLength)
+	d.skip(int64(filenameLength) + 11 + 4 + 4)
+
+	// DiscJuggler 4 has extra data
+	d.read(&value)
+	if value == 0x80000000 {
+		d.skip(8)
+	}
+	d.skip(2)
+
+	var pregap, length, mode, start, total, sectorSize uint32
+	d.read(&pregap)
+	d.read(&length)
+	d.skip(6)
+	d.read(&mode)
+	d.skip(12)
+	d.read(&start)
+	d.read(&total)
+	d.skip(16)
+	d.read(&sectorSize)
+	d.skip(29)
+
+	if version != Version2 {
+		d.skip(5)
+		d.read(&value)
+		if value == 0xffffffff {
+			d.skip(78)
+		}
+	}
+
+	if d.err != nil {
+		return Track{}, 0
+	}
+
+	track := Track{
+		Mode:   Mode(mode),
+		Pregap: int(pregap),
+		Length: int(length),
+		Start:  int(start),
+	}
+
+	switch track.Mode {
+	case ModeAudio, Mode1, Mode2:
+	default:
+		d.err = errInvalidMode
+		return Track{}, 0
+	}
+
+	var ok bool
+	if track.SectorSize, ok = sectorSizes[sectorSize]; !ok {
+		d.err = errInvalidSectorSize
+		return Track{}, 0
+	}
+
+	if pregap+length > total {
+		d.err = errInvalidLength
+		return Track{}, 0
+	}
+
+	return track, total
+}
+
+// NewImage returns an Image read from the passed io.ReaderAt of the given
+// size by parsing the descriptors found at the end of the image
+func NewImage(r io.ReaderAt, size int64) (*Image, error) {
+	if size < 8 {
+		return nil, errInvalidOffset
+	}
+
+	trailer := make([]byte, 8)
+	if _, err := r.ReadAt(trailer, size-8); err != nil {
+		return nil, err
+	}
+
+	image := &Image{
+		Version: Version(binary.LittleEndian.Uint32(trailer)),
+		r:       r,
+	}
+
+	offset := int64(binary.LittleEndian.Uint32(trailer[4:]))
+	switch image.Version {
+	case Version35:
+		// The offset is relative to the end of the image
+		offset = size - offset
+	case Version2, Version3:
+	default:
+		return nil, errInvalidVersion
+	}
+
+	if offset < 0 || offset >= size-8 {
+		return nil, errInvalidOffset
+	}
+
+	d := &descriptorReader{
+		r: io.NewSectionReader(r, offset, size-8-offset),
+	}
+
+	var sessions uint16
+	d.read(&sessions)
+
+	position := int64(0)
+	for i := 0; i < int(sessions) && d.err == nil; i++ {
+		var tracks uint16
+		d.read(&tracks)
+
+		session := Session{}
+		for j := 0; j < int(tracks) && d.err == nil; j++ {
+			track, total := d.readTrack(image.Version)
+			if d.err != nil {
+				break
+			}
+
+			track.Offset = position
+			position += int64(total) * int64(track.SectorSize)
+			if position > offset {
+				d.err = errInvalidLength
+				break
+			}
+
+			session.Tracks = append(session.Tracks, track)
+		}
+
+		// Skip the rest of the session descriptor
+		d.skip(12)
+		if image.Version != Version2 {
+			d.skip(1)
+		}
+
+		// An open session has no tracks
+		if len(session.Tracks) > 0 {
+			image.Sessions = append(image.Sessions, session)
+		}
+	}
+
+	if d.err != nil {
+		if d.err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, d.err
+	}
+
+	return image, nil
+}
@@ -0,0 +1,225 @@
+package cdi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTrack struct {
+	mode       uint32
+	sectorSize uint32
+	pregap     uint32
+	length     uint32
+	start      uint32
+}
+
+func testImage(version Version, sessions [][]testTrack) []byte {
+	data, desc := new(bytes.Buffer), new(bytes.Buffer)
+	le := func(v interface{}) {
+		_ = binary.Write(desc, binary.LittleEndian, v)
+	}
+
+	le(uint16(len(sessions)))
+	for _, session := range sessions {
+		le(uint16(len(session)))
+		for i, t := range session {
+			data.Write(bytes.Repeat([]byte{byte(i + 1)}, int((t.pregap+t.length)*uint32([]int{2048, 2336, 2352, 0, 2448}[t.sectorSize]))))
+
+			le(uint32(0))
+			desc.Write(trackStartMark)
+			desc.Write(trackStartMark)
+			desc.Write(make([]byte, 4))
+			le(uint8(len("image.cdi")))
+			desc.WriteString("image.cdi")
+			desc.Write(make([]byte, 11+4+4))
+			le(uint32(0))
+			desc.Write(make([]byte, 2))
+			le(t.pregap)
+			le(t.length)
+			desc.Write(make([]byte, 6))
+			le(t.mode)
+			desc.Write(make([]byte, 12))
+			le(t.start)
+			le(t.pregap + t.length)
+			desc.Write(make([]byte, 16))
+			le(t.sectorSize)
+			desc.Write(make([]byte, 29))
+			if version != Version2 {
+				desc.Write(make([]byte, 5))
+				le(uint32(0))
+			}
+		}
+		desc.Write(make([]byte, 12))
+		if version != Version2 {
+			desc.Write(make([]byte, 1))
+		}
+	}
+
+	offset := uint32(data.Len())
+	if version == Version35 {
+		offset = uint32(desc.Len() + 8)
+	}
+
+	le(uint32(version))
+	le(offset)
+
+	return append(data.Bytes(), desc.Bytes()...)
+}
+
+var selfboot = [][]testTrack{
+	{
+		{0, 2, 150, 302, 0},
+	},
+	{
+		{2, 1, 150, 1000, 11702},
+	},
+}
+
+func TestNewImage(t *testing.T) {
+	for _, version := range []Version{Version2, Version3, Version35} {
+		b := testImage(version, selfboot)
+
+		image, err := NewImage(bytes.NewReader(b), int64(len(b)))
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		assert.Equal(t, version, image.Version)
+		assert.Equal(t, 2, len(image.Sessions))
+		assert.Equal(t, []Track{
+			{
+				Mode:       ModeAudio,
+				SectorSize: 2352,
+				Pregap:     150,
+				Length:     302,
+				Start:      0,
+				Offset:     0,
+			},
+			{
+				Mode:       Mode2,
+				SectorSize: 2336,
+				Pregap:     150,
+				Length:     1000,
+				Start:      11702,
+				Offset:     452 * 2352,
+			},
+		}, image.Tracks())
+
+		tracks := image.Tracks()
+		assert.Equal(t, true, tracks[0].IsAudioTrack())
+		assert.Equal(t, true, tracks[1].IsDataTrack())
+
+		data, err := ioutil.ReadAll(image.TrackReader(tracks[1]))
+		assert.Nil(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{1}, 1000*2336), data)
+	}
+}
+
+func TestNewImageErrors(t *testing.T) {
+	tables := []struct {
+		got []byte
+		err error
+	}{
+		{
+			[]byte{0x00},
+			errInvalidOffset,
+		},
+		{
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			errInvalidVersion,
+		},
+		{
+			testImage(Version3, [][]testTrack{{{3, 2, 0, 1, 0}}}),
+			errInvalidMode,
+		},
+		{
+			testImage(Version3, [][]testTrack{{{1, 3, 0, 1, 0}}}),
+			errInvalidSectorSize,
+		},
+	}
+
+	// Truncate the descriptors
+	b := testImage(Version35, selfboot)
+	b = append(b[:len(b)-50], b[len(b)-8:]...)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], binary.LittleEndian.Uint32(b[len(b)-4:])-42)
+	tables = append(tables, struct {
+		got []byte
+		err error
+	}{b, io.ErrUnexpectedEOF})
+
+	for _, table := range tables {
+		_, err := NewImage(bytes.NewReader(table.got), int64(len(table.got)))
+		assert.True(t, errors.Is(err, table.err), err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+
+	tw, err := w.CreateTrack(Track{Mode: ModeAudio, SectorSize: 2352, Pregap: 150, Start: 0})
+	assert.Nil(t, err)
+	_, err = tw.Write(bytes.Repeat([]byte{1}, 452*2352))
+	assert.Nil(t, err)
+
+	assert.Nil(t, w.NewSession())
+
+	tw, err = w.CreateTrack(Track{Mode: Mode2, SectorSize: 2336, Pregap: 150, Start: 11702})
+	assert.Nil(t, err)
+	_, err = tw.Write(bytes.Repeat([]byte{2}, 1150*2336))
+	assert.Nil(t, err)
+
+	assert.Nil(t, w.Close())
+
+	image, err := NewImage(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, Version35, image.Version)
+	assert.Equal(t, []Track{
+		{
+			Mode:       ModeAudio,
+			SectorSize: 2352,
+			Pregap:     150,
+			Length:     302,
+			Start:      0,
+			Offset:     0,
+		},
+		{
+			Mode:       Mode2,
+			SectorSize: 2336,
+			Pregap:     150,
+			Length:     1000,
+			Start:      11702,
+			Offset:     452 * 2352,
+		},
+	}, image.Tracks())
+
+	data, err := ioutil.ReadAll(image.TrackReader(image.Sessions[1].Tracks[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{2}, 1000*2336), data)
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(ioutil.Discard)
+	assert.Equal(t, errInvalidSession, w.Close())
+
+	_, err := w.CreateTrack(Track{Mode: Mode(3), SectorSize: 2352})
+	assert.Equal(t, errInvalidMode, err)
+
+	_, err = w.CreateTrack(Track{Mode: Mode1, SectorSize: 2000})
+	assert.Equal(t, errInvalidSectorSize, err)
+
+	tw, err := w.CreateTrack(Track{Mode: Mode1, SectorSize: 2048})
+	assert.Nil(t, err)
+	_, err = tw.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, errInvalidLength, w.NewSession())
+}
@@ -0,0 +1,177 @@
+package cdi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errInvalidSession = errors.New("invalid session")
+
+var sectorSizeValues = map[int]uint32{
+	2048: 0,
+	2336: 1,
+	2352: 2,
+	2448: 4,
+}
+
+// Writer writes a CDI image. The sectors of each track, including any
+// pregap, are written in turn followed by the descriptors when the Writer
+// is closed
+type Writer struct {
+	w      io.Writer
+	image  Image
+	track  *trackWriter
+	offset int64
+}
+
+type trackWriter struct {
+	w     io.Writer
+	track Track
+	n     int64
+}
+
+func (t *trackWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.n += int64(n)
+	return n, err
+}
+
+// NewWriter returns a Writer that writes a CDI image to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+		image: Image{
+			Version: Version35,
+		},
+	}
+}
+
+func (w *Writer) finishTrack() error {
+	if w.track == nil {
+		return nil
+	}
+
+	t := w.track
+	w.track = nil
+
+	if t.n%int64(t.track.SectorSize) != 0 {
+		return errInvalidLength
+	}
+
+	t.track.Length = int(t.n/int64(t.track.SectorSize)) - t.track.Pregap
+	if t.track.Length <= 0 {
+		return errInvalidLength
+	}
+
+	session := &w.image.Sessions[len(w.image.Sessions)-1]
+	session.Tracks = append(session.Tracks, t.track)
+	w.offset += t.n
+
+	return nil
+}
+
+// NewSession starts a new session, any subsequent tracks are added to it
+func (w *Writer) NewSession() error {
+	if err := w.finishTrack(); err != nil {
+		return err
+	}
+
+	if len(w.image.Sessions) > 0 && len(w.image.Sessions[len(w.image.Sessions)-1].Tracks) == 0 {
+		return errInvalidSession
+	}
+
+	w.image.Sessions = append(w.image.Sessions, Session{})
+
+	return nil
+}
+
+// CreateTrack adds a track to the current session and returns an io.Writer
+// for the pregap and track sectors. The Length and Offset of the passed
+// track are ignored and instead calculated from the bytes written.
+func (w *Writer) CreateTrack(track Track) (io.Writer, error) {
+	if err := w.finishTrack(); err != nil {
+		return nil, err
+	}
+
+	switch track.Mode {
+	case ModeAudio, Mode1, Mode2:
+	default:
+		return nil, errInvalidMode
+	}
+
+	if _, ok := sectorSizeValues[track.SectorSize]; !ok {
+		return nil, errInvalidSectorSize
+	}
+
+	if len(w.image.Sessions) == 0 {
+		w.image.Sessions = append(w.image.Sessions, Session{})
+	}
+
+	track.Length, track.Offset = 0, w.offset
+	w.track = &trackWriter{
+		w:     w.w,
+		track: track,
+	}
+
+	return w.track, nil
+}
+
+// Close finishes the last track and writes the descriptors. It does not
+// close the underlying io.Writer
+func (w *Writer) Close() error {
+	if err := w.finishTrack(); err != nil {
+		return err
+	}
+
+	if len(w.image.Sessions) == 0 || len(w.image.Sessions[len(w.image.Sessions)-1].Tracks) == 0 {
+		return errInvalidSession
+	}
+
+	_, err := w.w.Write(w.image.marshalDescriptors())
+	return err
+}
+
+// marshalDescriptors encodes the descriptors in the DiscJuggler 3.5 format
+func (i Image) marshalDescriptors() []byte {
+	b := new(bytes.Buffer)
+	le := func(v interface{}) {
+		_ = binary.Write(b, binary.LittleEndian, v)
+	}
+
+	le(uint16(len(i.Sessions)))
+	for _, session := range i.Sessions {
+		le(uint16(len(session.Tracks)))
+		for _, track := range session.Tracks {
+			le(uint32(0))
+			b.Write(trackStartMark)
+			b.Write(trackStartMark)
+			b.Write(make([]byte, 4))
+			le(uint8(0)) // No filename
+			b.Write(make([]byte, 11+4+4))
+			le(uint32(0))
+			b.Write(make([]byte, 2))
+			le(uint32(track.Pregap))
+			le(uint32(track.Length))
+			b.Write(make([]byte, 6))
+			le(uint32(track.Mode))
+			b.Write(make([]byte, 12))
+			le(uint32(track.Start))
+			le(uint32(track.Pregap + track.Length))
+			b.Write(make([]byte, 16))
+			le(sectorSizeValues[track.SectorSize])
+			b.Write(make([]byte, 29+5))
+			le(uint32(0))
+		}
+
+		b.Write(make([]byte, 12+1))
+	}
+
+	// The trailer holds the version and the offset of the descriptors
+	// relative to the end of the image
+	le(uint32(Version35))
+	le(uint32(b.Len() + 4))
+
+	return b.Bytes()
+}
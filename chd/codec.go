@@ -0,0 +1,350 @@
+package chd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Codec represents a compression codec identified by its four character tag
+type Codec uint32
+
+// The codecs that may be used to compress hunks
+const (
+	CodecNone Codec = 0
+	CodecZlib Codec = 'z'<<24 | 'l'<<16 | 'i'<<8 | 'b'
+	CodecLZMA Codec = 'l'<<24 | 'z'<<16 | 'm'<<8 | 'a'
+	CodecHuff Codec = 'h'<<24 | 'u'<<16 | 'f'<<8 | 'f'
+	CodecFLAC Codec = 'f'<<24 | 'l'<<16 | 'a'<<8 | 'c'
+	CodecCDZL Codec = 'c'<<24 | 'd'<<16 | 'z'<<8 | 'l'
+	CodecCDLZ Codec = 'c'<<24 | 'd'<<16 | 'l'<<8 | 'z'
+	CodecCDFL Codec = 'c'<<24 | 'd'<<16 | 'f'<<8 | 'l'
+)
+
+func (c Codec) String() string {
+	if c == CodecNone {
+		return "none"
+	}
+	return string([]byte{byte(c >> 24), byte(c >> 16), byte(c >> 8), byte(c)})
+}
+
+var (
+	errUnsupportedCodec = errors.New("unsupported codec")
+	errCompression      = errors.New("compression failed")
+	errDecompression    = errors.New("decompression failed")
+)
+
+type decompressor func(src, dst []byte) error
+
+type compressor func(src []byte) ([]byte, error)
+
+func newDecompressor(c Codec, hunkBytes uint32) (decompressor, error) {
+	switch c {
+	case CodecZlib:
+		return inflate, nil
+	case CodecLZMA:
+		return newLZMADecompressor(hunkBytes), nil
+	case CodecHuff:
+		return huffman, nil
+	case CodecFLAC:
+		return decodeFLAC, nil
+	case CodecCDZL:
+		return cdDecompressor(inflate, inflate), nil
+	case CodecCDLZ:
+		return cdDecompressor(newLZMADecompressor(uint32(hunkBytes/frameSize)*sector.Size), inflate), nil
+	case CodecCDFL:
+		return decodeCDFLAC, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errUnsupportedCodec, c)
+}
+
+// newCompressor returns a compressor for the codec, or nil if there is no
+// support for compressing with it
+func newCompressor(c Codec, hunkBytes uint32) compressor {
+	switch c {
+	case CodecZlib:
+		return deflate
+	case CodecLZMA:
+		return newLZMACompressor(hunkBytes)
+	case CodecCDZL:
+		return cdCompressor(deflate, deflate)
+	case CodecCDLZ:
+		return cdCompressor(newLZMACompressor(uint32(hunkBytes/frameSize)*sector.Size), deflate)
+	}
+	return nil
+}
+
+func readFull(r io.Reader, dst []byte) error {
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return fmt.Errorf("%w: %v", errDecompression, err)
+	}
+	return nil
+}
+
+// inflate decompresses raw deflate data
+func inflate(src, dst []byte) error {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readFull(r, dst)
+}
+
+// deflate compresses to raw deflate data
+func deflate(src []byte) ([]byte, error) {
+	b := new(bytes.Buffer)
+	w, err := flate.NewWriter(b, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// lzmaDictionarySize mirrors how the LZMA SDK normalizes the dictionary
+// size for compression level 8 given the size of the data
+func lzmaDictionarySize(size uint32) uint32 {
+	for i := uint(11); i <= 30; i++ {
+		if size <= 2<<i {
+			return 2 << i
+		}
+		if size <= 3<<i {
+			return 3 << i
+		}
+	}
+	return 1 << 26
+}
+
+// newLZMADecompressor returns a decompressor for the raw LZMA streams used
+// by CHD which lack the usual header, the properties are implied instead
+func newLZMADecompressor(hunkBytes uint32) decompressor {
+	header := make([]byte, 13)
+	header[0] = (2*5+0)*9 + 3 // pb = 2, lp = 0, lc = 3
+	binary.LittleEndian.PutUint32(header[1:], lzmaDictionarySize(hunkBytes))
+
+	return func(src, dst []byte) error {
+		h := make([]byte, len(header))
+		copy(h, header)
+		binary.LittleEndian.PutUint64(h[5:], uint64(len(dst)))
+
+		r, err := lzma.NewReader(io.MultiReader(bytes.NewReader(h), bytes.NewReader(src)))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errDecompression, err)
+		}
+		return readFull(r, dst)
+	}
+}
+
+// newLZMACompressor returns a compressor that creates raw LZMA streams
+// without the usual header or an end marker
+func newLZMACompressor(hunkBytes uint32) compressor {
+	return func(src []byte) ([]byte, error) {
+		b := new(bytes.Buffer)
+		w, err := lzma.WriterConfig{
+			Properties: &lzma.Properties{LC: 3, LP: 0, PB: 2},
+			DictCap:    int(lzmaDictionarySize(hunkBytes)),
+			Size:       int64(len(src)),
+		}.NewWriter(b)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(src); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes()[13:], nil
+	}
+}
+
+// huffman decompresses data encoded with an 8-bit huffman tree
+func huffman(src, dst []byte) error {
+	r := newBitReader(src)
+	h := newHuffmanDecoder(256, 16)
+	if err := h.importTreeHuffman(r); err != nil {
+		return err
+	}
+	for i := range dst {
+		dst[i] = byte(h.decode(r))
+	}
+	if r.overflow() {
+		return errOverflow
+	}
+	return nil
+}
+
+// decodeFLAC decompresses FLAC frames, the first byte indicates the byte
+// order of the samples
+func decodeFLAC(src, dst []byte) error {
+	if len(src) == 0 {
+		return errDecompression
+	}
+
+	var order binary.ByteOrder
+	switch src[0] {
+	case 'L':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return errDecompression
+	}
+
+	return newFLACDecoder(src[1:]).decode(dst, order)
+}
+
+// The CD codecs split each hunk into frames of sector data followed by the
+// subcode data
+const (
+	subcodeSize = 96
+	frameSize   = sector.Size + subcodeSize
+)
+
+// eccOffset is the offset of the ECC in a Mode 1 sector
+const eccOffset = 0x81c
+
+var syncPattern = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+func interleave(dst, buffer []byte, frames int) {
+	for i := 0; i < frames; i++ {
+		copy(dst[i*frameSize:], buffer[i*sector.Size:(i+1)*sector.Size])
+		copy(dst[i*frameSize+sector.Size:], buffer[frames*sector.Size+i*subcodeSize:frames*sector.Size+(i+1)*subcodeSize])
+	}
+}
+
+// cdDecompressor returns a decompressor for the CD codecs that compress the
+// sector data and subcode data separately. A bitmap records the sectors
+// that had their sync pattern and ECC removed before compression
+func cdDecompressor(data, subcode decompressor) decompressor {
+	return func(src, dst []byte) error {
+		frames := len(dst) / frameSize
+		lengthBytes := 2
+		if len(dst) >= 65536 {
+			lengthBytes = 3
+		}
+		eccBytes := (frames + 7) / 8
+		headerBytes := eccBytes + lengthBytes
+		if len(src) < headerBytes {
+			return errDecompression
+		}
+
+		length := 0
+		for _, b := range src[eccBytes:headerBytes] {
+			length = length<<8 | int(b)
+		}
+		if headerBytes+length > len(src) {
+			return errDecompression
+		}
+
+		buffer := make([]byte, frames*frameSize)
+		if err := data(src[headerBytes:headerBytes+length], buffer[:frames*sector.Size]); err != nil {
+			return err
+		}
+		if err := subcode(src[headerBytes+length:], buffer[frames*sector.Size:]); err != nil {
+			return err
+		}
+
+		interleave(dst, buffer, frames)
+
+		for i := 0; i < frames; i++ {
+			if src[i/8]&(1<<uint(i%8)) != 0 {
+				s := dst[i*frameSize : i*frameSize+sector.Size]
+				copy(s, syncPattern)
+				if err := sector.ECC(s); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// cdCompressor returns a compressor for the CD codecs. The sync pattern
+// and ECC are removed from any Mode 1 sector where they can be regenerated
+func cdCompressor(data, subcode compressor) compressor {
+	return func(src []byte) ([]byte, error) {
+		frames := len(src) / frameSize
+		lengthBytes := 2
+		if len(src) >= 65536 {
+			lengthBytes = 3
+		}
+		eccBytes := (frames + 7) / 8
+
+		buffer := make([]byte, frames*frameSize)
+		header := make([]byte, eccBytes+lengthBytes)
+		for i := 0; i < frames; i++ {
+			s := buffer[i*sector.Size : (i+1)*sector.Size]
+			copy(s, src[i*frameSize:])
+			copy(buffer[frames*sector.Size+i*subcodeSize:], src[i*frameSize+sector.Size:(i+1)*frameSize])
+
+			if !bytes.Equal(s[:len(syncPattern)], syncPattern) {
+				continue
+			}
+
+			ecc := make([]byte, sector.Size)
+			copy(ecc, s)
+			if err := sector.ECC(ecc); err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(ecc[eccOffset:], s[eccOffset:]) {
+				continue
+			}
+
+			header[i/8] |= 1 << uint(i%8)
+			copy(s, make([]byte, len(syncPattern)))
+			copy(s[eccOffset:], make([]byte, sector.Size-eccOffset))
+		}
+
+		b, err := data(buffer[:frames*sector.Size])
+		if err != nil {
+			return nil, err
+		}
+		if len(b) >= 1<<(8*uint(lengthBytes)) {
+			return nil, errCompression
+		}
+		for i := 0; i < lengthBytes; i++ {
+			header[eccBytes+i] = byte(len(b) >> (8 * uint(lengthBytes-i-1)))
+		}
+
+		c, err := subcode(buffer[frames*sector.Size:])
+		if err != nil {
+			return nil, err
+		}
+
+		return append(append(header, b...), c...), nil
+	}
+}
+
+// decodeCDFLAC decompresses the sector data as big-endian FLAC samples
+// followed by the subcode data compressed with deflate
+func decodeCDFLAC(src, dst []byte) error {
+	frames := len(dst) / frameSize
+	buffer := make([]byte, frames*frameSize)
+
+	d := newFLACDecoder(src)
+	if err := d.decode(buffer[:frames*sector.Size], binary.BigEndian); err != nil {
+		return err
+	}
+	if d.consumed() > len(src) {
+		return errOverflow
+	}
+
+	if err := inflate(src[d.consumed():], buffer[frames*sector.Size:]); err != nil {
+		return err
+	}
+
+	interleave(dst, buffer, frames)
+
+	return nil
+}
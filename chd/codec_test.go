@@ -0,0 +1,177 @@
+package chd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestCodecString(t *testing.T) {
+	assert.Equal(t, "none", CodecNone.String())
+	assert.Equal(t, "cdzl", CodecCDZL.String())
+	assert.Equal(t, "lzma", CodecLZMA.String())
+}
+
+func TestNewDecompressor(t *testing.T) {
+	_, err := newDecompressor(Codec('a'<<24|'v'<<16|'h'<<8|'u'), 4096)
+	assert.NotNil(t, err)
+}
+
+func TestHuffman(t *testing.T) {
+	// Every byte is coded with eight bits so the data follows the tree
+	w := new(bitWriter)
+	w.write(1, 3)   // Code length of zero, an escape, is one
+	w.write(7, 3)   // Start at eight
+	w.write(0, 3)   // Code length of eight is zero
+	w.write(1, 3)   // Code length of nine, a length of eight, is one
+	w.write(7, 3)   // No more code lengths
+	w.write(1, 1)   // A length of eight
+	w.write(0, 1)   // Repeated
+	w.write(7, 3)   // ...a large number of times
+	w.write(246, 8) // ...for the remaining 255 codes
+
+	data := []byte("SEGA SEGAKATANA ")
+	for _, b := range data {
+		w.write(uint32(b), 8)
+	}
+
+	dst := make([]byte, len(data))
+	assert.Nil(t, huffman(w.b, dst))
+	assert.Equal(t, data, dst)
+
+	assert.Equal(t, errOverflow, huffman(w.b, make([]byte, len(data)+8)))
+}
+
+func testRice(w *bitWriter, residual []int32, k uint) {
+	w.write(0, 2) // 4-bit parameters
+	w.write(0, 4) // Single partition
+	w.write(uint32(k), 4)
+	for _, r := range residual {
+		v := uint32(r<<1) ^ uint32(r>>31)
+		w.write(0, uint(v>>k))
+		w.write(1, 1)
+		w.write(v&(1<<k-1), k)
+	}
+}
+
+// testFLAC encodes the samples as a single frame using left/side stereo,
+// the left channel is verbatim and the side channel is second-order fixed
+func testFLAC(left, right []int32) []byte {
+	w := new(bitWriter)
+	w.write(0x3ffe, 14)
+	w.write(0, 2)
+	w.write(7, 4) // 16-bit block size follows
+	w.write(9, 4) // 44.1kHz
+	w.write(channelLeftSide, 4)
+	w.write(4, 3) // 16 bits per sample
+	w.write(0, 1)
+	w.write(0, 8) // Frame number
+	w.write(uint32(len(left)-1), 16)
+	w.write(0, 8) // CRC-8
+
+	w.write(0, 1)
+	w.write(1, 6) // Verbatim
+	w.write(0, 1)
+	for _, s := range left {
+		w.write(uint32(s)&0xffff, 16)
+	}
+
+	side := make([]int32, len(left))
+	for i := range side {
+		side[i] = left[i] - right[i]
+	}
+
+	w.write(0, 1)
+	w.write(8+2, 6) // Fixed, second-order
+	w.write(0, 1)
+	w.write(uint32(side[0])&0x1ffff, 17)
+	w.write(uint32(side[1])&0x1ffff, 17)
+	residual := make([]int32, 0, len(side)-2)
+	for i := 2; i < len(side); i++ {
+		residual = append(residual, side[i]-(2*side[i-1]-side[i-2]))
+	}
+	testRice(w, residual, 2)
+
+	w.align()
+	w.write(0, 16) // CRC-16
+
+	return w.b
+}
+
+func testSamples(n int) ([]int32, []int32, []byte) {
+	left, right := make([]int32, n), make([]int32, n)
+	b := make([]byte, n*4)
+	for i := 0; i < n; i++ {
+		left[i], right[i] = int32(i*100-3000), int32(-i*37+5)
+		binary.BigEndian.PutUint16(b[i*4:], uint16(left[i]))
+		binary.BigEndian.PutUint16(b[i*4+2:], uint16(right[i]))
+	}
+	return left, right, b
+}
+
+func TestFLAC(t *testing.T) {
+	left, right, expected := testSamples(64)
+	src := append([]byte{'B'}, testFLAC(left, right)...)
+
+	dst := make([]byte, len(expected))
+	assert.Nil(t, decodeFLAC(src, dst))
+	assert.Equal(t, expected, dst)
+
+	src[0] = 'L'
+	assert.Nil(t, decodeFLAC(src, dst))
+	for i := 0; i < len(dst); i += 2 {
+		dst[i], dst[i+1] = dst[i+1], dst[i]
+	}
+	assert.Equal(t, expected, dst)
+
+	src[0] = 'X'
+	assert.Equal(t, errDecompression, decodeFLAC(src, dst))
+
+	src[0], src[1] = 'B', 0
+	assert.Equal(t, errInvalidFrame, decodeFLAC(src, dst))
+}
+
+func TestCDFLAC(t *testing.T) {
+	left, right, expected := testSamples(2 * sector.Size / 4)
+	subcode := bytes.Repeat([]byte{0xaa}, 2*subcodeSize)
+	src := append(testFLAC(left, right), testDeflate(subcode)...)
+
+	dst := make([]byte, 2*frameSize)
+	assert.Nil(t, decodeCDFLAC(src, dst))
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, expected[i*sector.Size:(i+1)*sector.Size], dst[i*frameSize:i*frameSize+sector.Size])
+		assert.Equal(t, subcode[i*subcodeSize:(i+1)*subcodeSize], dst[i*frameSize+sector.Size:(i+1)*frameSize])
+	}
+}
+
+func TestLZMA(t *testing.T) {
+	data := bytes.Repeat([]byte("SEGA SEGAKATANA "), 1024)
+
+	b := new(bytes.Buffer)
+	w, err := lzma.WriterConfig{
+		Properties: &lzma.Properties{LC: 3, LP: 0, PB: 2},
+		DictCap:    int(lzmaDictionarySize(uint32(len(data)))),
+		Size:       int64(len(data)),
+	}.NewWriter(b)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	// Strip the header
+	dst := make([]byte, len(data))
+	assert.Nil(t, newLZMADecompressor(uint32(len(data)))(b.Bytes()[13:], dst))
+	assert.Equal(t, data, dst)
+}
+
+func TestLZMADictionarySize(t *testing.T) {
+	assert.Equal(t, uint32(4096), lzmaDictionarySize(100))
+	assert.Equal(t, uint32(24576), lzmaDictionarySize(19584))
+	assert.Equal(t, uint32(1<<20), lzmaDictionarySize(1<<20))
+}
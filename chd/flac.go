@@ -0,0 +1,351 @@
+package chd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	errInvalidFrame     = errors.New("invalid flac frame")
+	errInvalidSubframe  = errors.New("invalid flac subframe")
+	errInvalidResidual  = errors.New("invalid flac residual")
+	errUnsupportedFrame = errors.New("unsupported flac frame")
+)
+
+const (
+	flacSync = 0x3ffe
+
+	channelLeftSide  = 8
+	channelSideRight = 9
+	channelMidSide   = 10
+)
+
+// flacDecoder decodes the raw FLAC frames stored by CHD, there is no stream
+// header so the frames are assumed to be 16-bit stereo unless the frame
+// header says otherwise
+type flacDecoder struct {
+	r       *bitReader
+	samples [2][]int32
+}
+
+func newFLACDecoder(b []byte) *flacDecoder {
+	return &flacDecoder{
+		r: newBitReader(b),
+	}
+}
+
+// decode decodes frames until the buffer is filled with interleaved
+// 16-bit stereo samples in the requested byte order
+func (d *flacDecoder) decode(b []byte, order binary.ByteOrder) error {
+	for offset := 0; offset < len(b); {
+		n, err := d.frame()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < n && offset < len(b); i++ {
+			if offset+4 > len(b) {
+				return errInvalidFrame
+			}
+			order.PutUint16(b[offset:], uint16(d.samples[0][i]))
+			order.PutUint16(b[offset+2:], uint16(d.samples[1][i]))
+			offset += 4
+		}
+	}
+
+	return nil
+}
+
+// consumed returns the number of bytes of frames decoded
+func (d *flacDecoder) consumed() int {
+	return d.r.consumed()
+}
+
+func (d *flacDecoder) utf8() error {
+	x := d.r.read(8)
+	n := 0
+	for mask := uint32(0x80); x&mask != 0; mask >>= 1 {
+		n++
+	}
+	switch {
+	case n == 1 || n > 7:
+		return errInvalidFrame
+	case n > 1:
+		n--
+	}
+	for ; n > 0; n-- {
+		if d.r.read(2) != 2 {
+			return errInvalidFrame
+		}
+		d.r.remove(6)
+	}
+	return nil
+}
+
+func (d *flacDecoder) frame() (int, error) {
+	if d.r.read(14) != flacSync {
+		return 0, errInvalidFrame
+	}
+	d.r.remove(2)
+
+	blockSizeCode := d.r.read(4)
+	sampleRateCode := d.r.read(4)
+	channels := d.r.read(4)
+	sampleSizeCode := d.r.read(3)
+	d.r.remove(1)
+
+	if err := d.utf8(); err != nil {
+		return 0, err
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 0:
+		return 0, errInvalidFrame
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		blockSize = int(d.r.read(8)) + 1
+	case blockSizeCode == 7:
+		blockSize = int(d.r.read(16)) + 1
+	default:
+		blockSize = 256 << (blockSizeCode - 8)
+	}
+
+	switch sampleRateCode {
+	case 12:
+		d.r.remove(8)
+	case 13, 14:
+		d.r.remove(16)
+	case 15:
+		return 0, errInvalidFrame
+	}
+
+	// CRC-8 of the header
+	d.r.remove(8)
+
+	switch sampleSizeCode {
+	case 0, 4:
+	default:
+		return 0, errUnsupportedFrame
+	}
+
+	if channels != 1 && channels < channelLeftSide || channels > channelMidSide {
+		return 0, errUnsupportedFrame
+	}
+
+	for i := range d.samples {
+		if cap(d.samples[i]) < blockSize {
+			d.samples[i] = make([]int32, blockSize)
+		}
+		d.samples[i] = d.samples[i][:blockSize]
+
+		bps := uint(16)
+		if (i == 1 && (channels == channelLeftSide || channels == channelMidSide)) || (i == 0 && channels == channelSideRight) {
+			bps++
+		}
+		if err := d.subframe(d.samples[i], bps); err != nil {
+			return 0, err
+		}
+	}
+
+	left, right := d.samples[0], d.samples[1]
+	switch channels {
+	case channelLeftSide:
+		for i := range left {
+			right[i] = left[i] - right[i]
+		}
+	case channelSideRight:
+		for i := range left {
+			left[i] += right[i]
+		}
+	case channelMidSide:
+		for i := range left {
+			mid := left[i]<<1 | right[i]&1
+			left[i], right[i] = (mid+right[i])>>1, (mid-right[i])>>1
+		}
+	}
+
+	// Footer CRC-16
+	d.r.align()
+	d.r.remove(16)
+
+	if d.r.overflow() {
+		return 0, errOverflow
+	}
+
+	return blockSize, nil
+}
+
+func (d *flacDecoder) signed(bits uint) int32 {
+	if bits == 0 {
+		return 0
+	}
+	return int32(d.r.read(bits)<<(32-bits)) >> (32 - bits)
+}
+
+func (d *flacDecoder) subframe(samples []int32, bps uint) error {
+	if d.r.read(1) != 0 {
+		return errInvalidSubframe
+	}
+	kind := d.r.read(6)
+
+	wasted := uint(0)
+	if d.r.read(1) == 1 {
+		wasted = 1
+		for d.r.read(1) == 0 {
+			if d.r.overflow() || wasted >= bps {
+				return errInvalidSubframe
+			}
+			wasted++
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case kind == 0:
+		x := d.signed(bps)
+		for i := range samples {
+			samples[i] = x
+		}
+	case kind == 1:
+		for i := range samples {
+			samples[i] = d.signed(bps)
+		}
+	case kind >= 8 && kind <= 12:
+		if err := d.fixed(samples, int(kind-8), bps); err != nil {
+			return err
+		}
+	case kind >= 32:
+		if err := d.lpc(samples, int(kind-31), bps); err != nil {
+			return err
+		}
+	default:
+		return errInvalidSubframe
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+
+	return nil
+}
+
+func (d *flacDecoder) fixed(samples []int32, order int, bps uint) error {
+	if order > len(samples) {
+		return errInvalidSubframe
+	}
+	for i := 0; i < order; i++ {
+		samples[i] = d.signed(bps)
+	}
+
+	if err := d.residual(samples, order); err != nil {
+		return err
+	}
+
+	for i := order; i < len(samples); i++ {
+		switch order {
+		case 1:
+			samples[i] += samples[i-1]
+		case 2:
+			samples[i] += 2*samples[i-1] - samples[i-2]
+		case 3:
+			samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+	}
+
+	return nil
+}
+
+func (d *flacDecoder) lpc(samples []int32, order int, bps uint) error {
+	if order > len(samples) {
+		return errInvalidSubframe
+	}
+	for i := 0; i < order; i++ {
+		samples[i] = d.signed(bps)
+	}
+
+	precision := uint(d.r.read(4)) + 1
+	if precision == 16 {
+		return errInvalidSubframe
+	}
+	shift := d.signed(5)
+	if shift < 0 {
+		return errInvalidSubframe
+	}
+
+	coefficients := make([]int32, order)
+	for i := range coefficients {
+		coefficients[i] = d.signed(precision)
+	}
+
+	if err := d.residual(samples, order); err != nil {
+		return err
+	}
+
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefficients {
+			sum += int64(c) * int64(samples[i-j-1])
+		}
+		samples[i] += int32(sum >> uint(shift))
+	}
+
+	return nil
+}
+
+func (d *flacDecoder) residual(samples []int32, order int) error {
+	paramBits, escape := uint(4), uint32(15)
+	switch d.r.read(2) {
+	case 0:
+	case 1:
+		paramBits, escape = 5, 31
+	default:
+		return errInvalidResidual
+	}
+
+	partitionOrder := d.r.read(4)
+	partitions := 1 << partitionOrder
+	if len(samples)%partitions != 0 || len(samples)>>partitionOrder < order {
+		return errInvalidResidual
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		n := len(samples) >> partitionOrder
+		if p == 0 {
+			n -= order
+		}
+
+		param := d.r.read(paramBits)
+		if param == escape {
+			bits := uint(d.r.read(5))
+			for ; n > 0; n-- {
+				samples[i] = d.signed(bits)
+				i++
+			}
+			continue
+		}
+
+		for ; n > 0; n-- {
+			q := uint32(0)
+			for d.r.read(1) == 0 {
+				if d.r.overflow() {
+					return errOverflow
+				}
+				q++
+			}
+			v := q<<param | d.r.read(uint(param))
+			samples[i] = int32(v>>1) ^ -int32(v&1)
+			i++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package chd
+
+import (
+	"errors"
+)
+
+var (
+	errInvalidHuffmanTree = errors.New("invalid huffman tree")
+	errOverflow           = errors.New("bitstream overflow")
+)
+
+// bitReader reads a big-endian bitstream, returning zeroes once the end of
+// the data is reached and recording the overflow
+type bitReader struct {
+	b      []byte
+	offset int
+	buffer uint64
+	bits   uint
+	over   bool
+}
+
+func newBitReader(b []byte) *bitReader {
+	return &bitReader{b: b}
+}
+
+func (r *bitReader) fill(n uint) {
+	for r.bits < n {
+		x := byte(0)
+		if r.offset < len(r.b) {
+			x = r.b[r.offset]
+		}
+		r.offset++
+		r.buffer |= uint64(x) << (56 - r.bits)
+		r.bits += 8
+	}
+}
+
+func (r *bitReader) peek(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	r.fill(n)
+	return uint32(r.buffer >> (64 - n))
+}
+
+func (r *bitReader) remove(n uint) {
+	r.fill(n)
+	r.buffer <<= n
+	r.bits -= n
+	if r.offset-int(r.bits/8) > len(r.b) {
+		r.over = true
+	}
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	x := r.peek(n)
+	r.remove(n)
+	return x
+}
+
+// align discards any remaining bits in the current byte
+func (r *bitReader) align() {
+	r.remove(r.bits % 8)
+}
+
+// consumed returns the number of whole bytes read
+func (r *bitReader) consumed() int {
+	return r.offset - int(r.bits/8)
+}
+
+func (r *bitReader) overflow() bool {
+	return r.over
+}
+
+// bitWriter writes a big-endian bitstream
+type bitWriter struct {
+	b    []byte
+	bits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		if v&(1<<uint(i)) != 0 {
+			w.b[len(w.b)-1] |= 0x80 >> (w.bits % 8)
+		}
+		w.bits++
+	}
+}
+
+// align pads the bitstream with zeroes to the next byte
+func (w *bitWriter) align() {
+	w.bits = (w.bits + 7) &^ 7
+}
+
+// bytes returns the bitstream
+func (w *bitWriter) bytes() []byte {
+	return w.b
+}
+
+// bitsFor returns the number of bits required to store v
+func bitsFor(v uint64) uint {
+	n := uint(0)
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// huffmanDecoder decodes canonical huffman codes in the same manner as
+// MAME's huffman_decoder
+type huffmanDecoder struct {
+	numCodes int
+	maxBits  uint
+	numBits  []uint8
+	lookup   []uint32
+}
+
+func newHuffmanDecoder(numCodes int, maxBits uint) *huffmanDecoder {
+	return &huffmanDecoder{
+		numCodes: numCodes,
+		maxBits:  maxBits,
+		numBits:  make([]uint8, numCodes),
+		lookup:   make([]uint32, 1<<maxBits),
+	}
+}
+
+func (h *huffmanDecoder) assignCanonicalCodes() ([]uint32, error) {
+	var histogram [33]uint32
+	for _, n := range h.numBits {
+		if uint(n) > h.maxBits {
+			return nil, errInvalidHuffmanTree
+		}
+		histogram[n]++
+	}
+
+	// Longer codes are assigned first, starting from zero
+	start := uint32(0)
+	for length := 32; length > 0; length-- {
+		next := (start + histogram[length]) >> 1
+		if length != 1 && next*2 != start+histogram[length] {
+			return nil, errInvalidHuffmanTree
+		}
+		histogram[length] = start
+		start = next
+	}
+
+	codes := make([]uint32, h.numCodes)
+	for i, n := range h.numBits {
+		if n > 0 {
+			codes[i] = histogram[n]
+			histogram[n]++
+		}
+	}
+
+	return codes, nil
+}
+
+func (h *huffmanDecoder) buildLookupTable() error {
+	codes, err := h.assignCanonicalCodes()
+	if err != nil {
+		return err
+	}
+
+	for i, n := range h.numBits {
+		if n == 0 {
+			continue
+		}
+
+		value := uint32(i)<<5 | uint32(n)
+		shift := h.maxBits - uint(n)
+		first := codes[i] << shift
+		last := first | (1<<shift - 1)
+		if int(last) >= len(h.lookup) {
+			return errInvalidHuffmanTree
+		}
+		for j := first; j <= last; j++ {
+			h.lookup[j] = value
+		}
+	}
+
+	return nil
+}
+
+func (h *huffmanDecoder) decode(r *bitReader) int {
+	x := h.lookup[r.peek(h.maxBits)]
+	r.remove(uint(x & 0x1f))
+	return int(x >> 5)
+}
+
+// importTreeRLE reads a tree where each code length is stored directly,
+// with an escape for runs of the same length
+func (h *huffmanDecoder) importTreeRLE(r *bitReader) error {
+	var bits uint
+	switch {
+	case h.maxBits >= 16:
+		bits = 5
+	case h.maxBits >= 8:
+		bits = 4
+	default:
+		bits = 3
+	}
+
+	for code := 0; code < h.numCodes; {
+		n := uint8(r.read(bits))
+		if n != 1 {
+			h.numBits[code] = n
+			code++
+			continue
+		}
+
+		// A one is an escape code, a double one is just a one
+		n = uint8(r.read(bits))
+		if n == 1 {
+			h.numBits[code] = n
+			code++
+			continue
+		}
+
+		for count := r.read(bits) + 3; count > 0; count-- {
+			if code >= h.numCodes {
+				return errInvalidHuffmanTree
+			}
+			h.numBits[code] = n
+			code++
+		}
+	}
+
+	if r.overflow() {
+		return errOverflow
+	}
+
+	return h.buildLookupTable()
+}
+
+// importTreeHuffman reads a tree where the code lengths are themselves
+// huffman encoded using a small tree
+func (h *huffmanDecoder) importTreeHuffman(r *bitReader) error {
+	small := newHuffmanDecoder(24, 6)
+	small.numBits[0] = uint8(r.read(3))
+	start := int(r.read(3)) + 1
+	count := uint32(0)
+	for i := 1; i < 24; i++ {
+		if i < start || count == 7 {
+			small.numBits[i] = 0
+			continue
+		}
+		count = r.read(3)
+		if count != 7 {
+			small.numBits[i] = uint8(count)
+		}
+	}
+
+	if err := small.buildLookupTable(); err != nil {
+		return err
+	}
+
+	// Determine the maximum length of a run
+	rleBits := uint(0)
+	for x := h.numCodes - 9; x != 0; x >>= 1 {
+		rleBits++
+	}
+
+	last, code := uint8(0), 0
+	for code < h.numCodes {
+		value := small.decode(r)
+		if value != 0 {
+			last = uint8(value - 1)
+			h.numBits[code] = last
+			code++
+			continue
+		}
+
+		count := int(r.read(3)) + 2
+		if count == 7+2 {
+			count += int(r.read(rleBits))
+		}
+		for ; count > 0 && code < h.numCodes; count-- {
+			h.numBits[code] = last
+			code++
+		}
+	}
+
+	if r.overflow() {
+		return errOverflow
+	}
+
+	return h.buildLookupTable()
+}
@@ -0,0 +1,691 @@
+/*
+Package chd implements reading and writing of MAME Compressed Hunks of Data
+(CHD) images. Only version 5 images are supported which is what current
+versions of chdman create. The hunk map and CD or GD-ROM track metadata are
+parsed and each hunk is decompressed as it is read.
+
+Images are written using the same track layout, metadata and hunk size as
+chdman so the SHA-1 hashes of the data and metadata match those of an image
+created by chdman from the same tracks. The images themselves are not
+byte-compatible with chdman; the hunks are compressed differently, FLAC
+is not used for audio so isn't listed as a codec, and repeated hunks are
+only ever mapped as a reference to the first copy.
+*/
+package chd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Extension is the conventional file extension used
+	Extension = ".chd"
+)
+
+const (
+	headerSize = 124
+	version    = 5
+
+	metadataHeaderSize = 16
+)
+
+var magic = []byte("MComprHD")
+
+// Metadata tags
+const (
+	tagCDTrack  uint32 = 'C'<<24 | 'H'<<16 | 'T'<<8 | '2'
+	tagGDTrack  uint32 = 'C'<<24 | 'H'<<16 | 'G'<<8 | 'D'
+	tagCDTrack1 uint32 = 'C'<<24 | 'H'<<16 | 'T'<<8 | 'R'
+)
+
+// Compression types found in the hunk map
+const (
+	compressionType0 = iota
+	compressionType1
+	compressionType2
+	compressionType3
+	compressionNone
+	compressionSelf
+	compressionParent
+	compressionRLESmall
+	compressionRLELarge
+	compressionSelf0
+	compressionSelf1
+	compressionParentSelf
+	compressionParent0
+	compressionParent1
+)
+
+// padding is the multiple of frames each track is padded to
+const padding = 4
+
+var sectorSizes = map[string]int{
+	"MODE1":          2048,
+	"MODE1/2048":     2048,
+	"MODE1_RAW":      2352,
+	"MODE1/2352":     2352,
+	"MODE2":          2336,
+	"MODE2/2336":     2336,
+	"MODE2_FORM1":    2048,
+	"MODE2/2048":     2048,
+	"MODE2_FORM2":    2324,
+	"MODE2/2324":     2324,
+	"MODE2_FORM_MIX": 2336,
+	"MODE2_RAW":      2352,
+	"MODE2/2352":     2352,
+	"AUDIO":          2352,
+}
+
+var (
+	errInvalidHeader    = errors.New("invalid header")
+	errInvalidVersion   = errors.New("invalid version")
+	errInvalidMap       = errors.New("invalid hunk map")
+	errInvalidHunk      = errors.New("invalid hunk")
+	errInvalidCRC       = errors.New("invalid crc")
+	errInvalidMetadata  = errors.New("invalid metadata")
+	errInvalidTrack     = errors.New("invalid track")
+	errUnsupportedTrack = errors.New("unsupported track type")
+	errParent           = errors.New("parent images are not supported")
+)
+
+// Header represents the version 5 header
+type Header struct {
+	// Compressors lists the codecs that may be used for each hunk
+	Compressors [4]Codec
+	// LogicalBytes is the uncompressed size of the data
+	LogicalBytes uint64
+	// MapOffset is the offset of the hunk map
+	MapOffset uint64
+	// MetaOffset is the offset of the first metadata entry
+	MetaOffset uint64
+	// HunkBytes is the size of each hunk
+	HunkBytes uint32
+	// UnitBytes is the size of each unit within a hunk, for CD images
+	// this is the size of each frame
+	UnitBytes uint32
+	// RawSHA1 is the SHA-1 of the uncompressed data
+	RawSHA1 [20]byte
+	// SHA1 is the SHA-1 of the uncompressed data and metadata
+	SHA1 [20]byte
+	// ParentSHA1 is the SHA-1 of the parent image, if any
+	ParentSHA1 [20]byte
+}
+
+// Track represents a single track described by the metadata
+type Track struct {
+	// Number is the track number
+	Number int
+	// Type is the type of the track such as MODE1_RAW or AUDIO
+	Type string
+	// SubType is the type of any subcode data, such as RW or NONE
+	SubType string
+	// Frames is the number of frames stored for the track, including any
+	// pregap but not the padding
+	Frames int
+	// Pad is the number of padding frames stored after the track to make
+	// it a multiple of four frames
+	Pad int
+	// Pregap is the number of pregap frames
+	Pregap int
+	// PregapType is the type of the pregap, it is prefixed with V if
+	// the pregap frames are stored
+	PregapType string
+	// PregapSubType is the type of any subcode data in the pregap
+	PregapSubType string
+	// Postgap is the number of postgap frames
+	Postgap int
+	// Offset is the frame offset of the track within the image
+	Offset int
+}
+
+// IsAudioTrack returns true if the track is audio
+func (t Track) IsAudioTrack() bool {
+	return t.Type == "AUDIO"
+}
+
+// IsDataTrack returns true if the track is data
+func (t Track) IsDataTrack() bool {
+	return !t.IsAudioTrack()
+}
+
+// SectorSize returns the size of each sector stored for the track
+func (t Track) SectorSize() int {
+	return sectorSizes[t.Type]
+}
+
+// Length returns the size in bytes of the track as returned by
+// TrackReader
+func (t Track) Length() int64 {
+	return int64(t.Frames) * int64(t.SectorSize())
+}
+
+// HasPregap returns true if the pregap frames are stored with the track
+func (t Track) HasPregap() bool {
+	return strings.HasPrefix(t.PregapType, "V")
+}
+
+// Image represents a CHD image
+type Image struct {
+	Header
+	// GDROM is true if the image is of a GD-ROM rather than a CD
+	GDROM bool
+	// Tracks contains each track
+	Tracks []Track
+
+	r             io.ReaderAt
+	hunks         []hunk
+	decompressors [4]decompressor
+	cache         []byte
+	cached        int
+}
+
+type hunk struct {
+	compression int
+	length      uint32
+	offset      uint64
+	crc         uint16
+}
+
+var crc16Table = func() (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+func crc16(b []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, x := range b {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^x]
+	}
+	return crc
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func uint48(b []byte) uint64 {
+	return uint64(b[0])<<40 | uint64(b[1])<<32 | uint64(b[2])<<24 | uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5])
+}
+
+func (i *Image) readHeader() error {
+	b := make([]byte, headerSize)
+	if _, err := i.r.ReadAt(b, 0); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if !bytes.Equal(b[:len(magic)], magic) {
+		return errInvalidHeader
+	}
+	if binary.BigEndian.Uint32(b[12:]) != version {
+		return errInvalidVersion
+	}
+	if binary.BigEndian.Uint32(b[8:]) != headerSize {
+		return errInvalidHeader
+	}
+
+	for j := range i.Compressors {
+		i.Compressors[j] = Codec(binary.BigEndian.Uint32(b[16+j*4:]))
+	}
+	i.LogicalBytes = binary.BigEndian.Uint64(b[32:])
+	i.MapOffset = binary.BigEndian.Uint64(b[40:])
+	i.MetaOffset = binary.BigEndian.Uint64(b[48:])
+	i.HunkBytes = binary.BigEndian.Uint32(b[56:])
+	i.UnitBytes = binary.BigEndian.Uint32(b[60:])
+	copy(i.RawSHA1[:], b[64:])
+	copy(i.SHA1[:], b[84:])
+	copy(i.ParentSHA1[:], b[104:])
+
+	if i.HunkBytes == 0 || i.UnitBytes == 0 || i.HunkBytes%i.UnitBytes != 0 {
+		return errInvalidHeader
+	}
+
+	return nil
+}
+
+func (i *Image) readRawMap(count int) error {
+	b := make([]byte, count*4)
+	if _, err := i.r.ReadAt(b, int64(i.MapOffset)); err != nil {
+		return err
+	}
+
+	for j := range i.hunks {
+		offset := uint64(binary.BigEndian.Uint32(b[j*4:])) * uint64(i.HunkBytes)
+		i.hunks[j] = hunk{
+			compression: compressionNone,
+			length:      i.HunkBytes,
+			offset:      offset,
+		}
+	}
+
+	return nil
+}
+
+func (i *Image) readCompressedMap(count int) error {
+	header := make([]byte, 16)
+	if _, err := i.r.ReadAt(header, int64(i.MapOffset)); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:])
+	offset := uint48(header[4:])
+	mapCRC := binary.BigEndian.Uint16(header[10:])
+	lengthBits, selfBits, parentBits := uint(header[12]), uint(header[13]), uint(header[14])
+
+	b := make([]byte, length)
+	if _, err := i.r.ReadAt(b, int64(i.MapOffset)+int64(len(header))); err != nil {
+		return err
+	}
+
+	r := newBitReader(b)
+	h := newHuffmanDecoder(16, 8)
+	if err := h.importTreeRLE(r); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidMap, err)
+	}
+
+	// The compression types are stored first, with runs encoded
+	last, repeat := 0, 0
+	for j := range i.hunks {
+		if repeat > 0 {
+			i.hunks[j].compression = last
+			repeat--
+			continue
+		}
+
+		switch c := h.decode(r); c {
+		case compressionRLESmall:
+			i.hunks[j].compression = last
+			repeat = 2 + h.decode(r)
+		case compressionRLELarge:
+			i.hunks[j].compression = last
+			repeat = 2 + 16 + h.decode(r)<<4
+			repeat += h.decode(r)
+		default:
+			i.hunks[j].compression = c
+			last = c
+		}
+	}
+
+	// Followed by the lengths, offsets and CRCs
+	raw := make([]byte, count*12)
+	lastSelf, lastParent := uint64(0), uint64(0)
+	for j := range i.hunks {
+		h := &i.hunks[j]
+		h.offset = offset
+
+		switch h.compression {
+		case compressionType0, compressionType1, compressionType2, compressionType3:
+			h.length = r.read(lengthBits)
+			offset += uint64(h.length)
+			h.crc = uint16(r.read(16))
+		case compressionNone:
+			h.length = i.HunkBytes
+			offset += uint64(h.length)
+			h.crc = uint16(r.read(16))
+		case compressionSelf:
+			h.offset = uint64(r.read(selfBits))
+			lastSelf = h.offset
+		case compressionParent:
+			h.offset = uint64(r.read(parentBits))
+			lastParent = h.offset
+		case compressionSelf1:
+			lastSelf++
+			fallthrough
+		case compressionSelf0:
+			h.compression = compressionSelf
+			h.offset = lastSelf
+		case compressionParentSelf:
+			h.compression = compressionParent
+			h.offset = uint64(j) * uint64(i.HunkBytes) / uint64(i.UnitBytes)
+			lastParent = h.offset
+		case compressionParent1:
+			lastParent += uint64(i.HunkBytes / i.UnitBytes)
+			fallthrough
+		case compressionParent0:
+			h.compression = compressionParent
+			h.offset = lastParent
+		default:
+			return errInvalidMap
+		}
+
+		e := raw[j*12:]
+		e[0] = byte(h.compression)
+		e[1], e[2], e[3] = byte(h.length>>16), byte(h.length>>8), byte(h.length)
+		for k := 0; k < 6; k++ {
+			e[4+k] = byte(h.offset >> (40 - 8*uint(k)))
+		}
+		binary.BigEndian.PutUint16(e[10:], h.crc)
+	}
+
+	if r.overflow() {
+		return errInvalidMap
+	}
+
+	if crc16(raw) != mapCRC {
+		return fmt.Errorf("%w: %v", errInvalidMap, errInvalidCRC)
+	}
+
+	return nil
+}
+
+func parseTrack(s string) (Track, error) {
+	track := Track{}
+	for _, field := range strings.Fields(strings.TrimRight(s, "\x00")) {
+		kv := strings.SplitN(field, ":", 2)
+		if len(kv) != 2 {
+			return Track{}, errInvalidMetadata
+		}
+
+		var err error
+		switch kv[0] {
+		case "TRACK":
+			track.Number, err = strconv.Atoi(kv[1])
+		case "TYPE":
+			track.Type = kv[1]
+		case "SUBTYPE":
+			track.SubType = kv[1]
+		case "FRAMES":
+			track.Frames, err = strconv.Atoi(kv[1])
+		case "PAD":
+			track.Pad, err = strconv.Atoi(kv[1])
+		case "PREGAP":
+			track.Pregap, err = strconv.Atoi(kv[1])
+		case "PGTYPE":
+			track.PregapType = kv[1]
+		case "PGSUB":
+			track.PregapSubType = kv[1]
+		case "POSTGAP":
+			track.Postgap, err = strconv.Atoi(kv[1])
+		}
+		if err != nil {
+			return Track{}, errInvalidMetadata
+		}
+	}
+
+	if _, ok := sectorSizes[track.Type]; !ok {
+		return Track{}, fmt.Errorf("%w: %s", errUnsupportedTrack, track.Type)
+	}
+
+	if track.Number < 1 || track.Frames < 1 || track.Pad < 0 || track.Pregap < 0 {
+		return Track{}, errInvalidTrack
+	}
+
+	return track, nil
+}
+
+func (i *Image) readMetadata() error {
+	offset := i.MetaOffset
+	seen := map[uint64]struct{}{}
+	for offset != 0 {
+		if _, ok := seen[offset]; ok {
+			return errInvalidMetadata
+		}
+		seen[offset] = struct{}{}
+
+		header := make([]byte, metadataHeaderSize)
+		if _, err := i.r.ReadAt(header, int64(offset)); err != nil {
+			return err
+		}
+
+		tag := binary.BigEndian.Uint32(header)
+		length := uint24(header[5:])
+		next := binary.BigEndian.Uint64(header[8:])
+
+		switch tag {
+		case tagCDTrack, tagGDTrack:
+			if i.UnitBytes < frameSize {
+				return errInvalidHeader
+			}
+
+			b := make([]byte, length)
+			if _, err := i.r.ReadAt(b, int64(offset)+metadataHeaderSize); err != nil {
+				return err
+			}
+
+			track, err := parseTrack(string(b))
+			if err != nil {
+				return err
+			}
+
+			if track.Number != len(i.Tracks)+1 {
+				return errInvalidTrack
+			}
+
+			i.GDROM = tag == tagGDTrack
+			i.Tracks = append(i.Tracks, track)
+		case tagCDTrack1:
+			return fmt.Errorf("%w: %s", errInvalidMetadata, "obsolete track metadata")
+		}
+
+		offset = next
+	}
+
+	// Each track is padded to a multiple of frames
+	frames := 0
+	for j := range i.Tracks {
+		i.Tracks[j].Offset = frames
+		frames += (i.Tracks[j].Frames + padding - 1) / padding * padding
+	}
+
+	if uint64(frames)*uint64(i.UnitBytes) > i.LogicalBytes {
+		return errInvalidTrack
+	}
+
+	return nil
+}
+
+// NewImage returns an Image read from the passed io.ReaderAt
+func NewImage(r io.ReaderAt) (*Image, error) {
+	i := &Image{
+		r:      r,
+		cached: -1,
+	}
+
+	if err := i.readHeader(); err != nil {
+		return nil, err
+	}
+
+	for j, c := range i.Compressors {
+		if c == CodecNone {
+			continue
+		}
+		d, err := newDecompressor(c, i.HunkBytes)
+		if err != nil {
+			// Only fail if the codec is actually used by a hunk
+			d = func(_, _ []byte) error {
+				return err
+			}
+		}
+		i.decompressors[j] = d
+	}
+
+	count := (i.LogicalBytes + uint64(i.HunkBytes) - 1) / uint64(i.HunkBytes)
+	if count > 1<<24 {
+		return nil, errInvalidHeader
+	}
+	i.hunks = make([]hunk, count)
+
+	var err error
+	if i.Compressors[0] == CodecNone {
+		err = i.readRawMap(int(count))
+	} else {
+		err = i.readCompressedMap(int(count))
+	}
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if err := i.readMetadata(); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func (i *Image) readHunk(n int, b []byte, depth int) error {
+	if n < 0 || n >= len(i.hunks) || depth > len(i.hunks) {
+		return errInvalidHunk
+	}
+
+	h := i.hunks[n]
+	switch h.compression {
+	case compressionType0, compressionType1, compressionType2, compressionType3:
+		d := i.decompressors[h.compression]
+		if d == nil {
+			return errInvalidHunk
+		}
+		src := make([]byte, h.length)
+		if _, err := i.r.ReadAt(src, int64(h.offset)); err != nil {
+			return err
+		}
+		if err := d(src, b); err != nil {
+			return fmt.Errorf("hunk %d: %w", n, err)
+		}
+	case compressionNone:
+		if h.offset == 0 {
+			// An uncompressed image has no data for this hunk
+			for j := range b {
+				b[j] = 0
+			}
+			return nil
+		}
+		if _, err := i.r.ReadAt(b, int64(h.offset)); err != nil {
+			return err
+		}
+	case compressionSelf:
+		return i.readHunk(int(h.offset), b, depth+1)
+	case compressionParent:
+		return errParent
+	default:
+		return errInvalidHunk
+	}
+
+	if i.Compressors[0] != CodecNone && crc16(b) != h.crc {
+		return fmt.Errorf("hunk %d: %w", n, errInvalidCRC)
+	}
+
+	return nil
+}
+
+// ReadHunk reads the uncompressed hunk n into b which must be HunkBytes in
+// length
+func (i *Image) ReadHunk(n int, b []byte) error {
+	if len(b) != int(i.HunkBytes) {
+		return errInvalidHunk
+	}
+	return i.readHunk(n, b, 0)
+}
+
+// ReadAt implements io.ReaderAt over the uncompressed data
+func (i *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errInvalidHunk
+	}
+
+	if i.cache == nil {
+		i.cache = make([]byte, i.HunkBytes)
+	}
+
+	n := 0
+	for n < len(p) {
+		if uint64(off) >= i.LogicalBytes {
+			return n, io.EOF
+		}
+
+		hunk := int(uint64(off) / uint64(i.HunkBytes))
+		if hunk != i.cached {
+			i.cached = -1
+			if err := i.ReadHunk(hunk, i.cache); err != nil {
+				return n, err
+			}
+			i.cached = hunk
+		}
+
+		start := uint64(off) % uint64(i.HunkBytes)
+		end := uint64(i.HunkBytes)
+		if remaining := i.LogicalBytes - uint64(off) + start; remaining < end {
+			end = remaining
+		}
+
+		c := copy(p[n:], i.cache[start:end])
+		n += c
+		off += int64(c)
+	}
+
+	return n, nil
+}
+
+type trackReader struct {
+	i      *Image
+	track  Track
+	frame  int
+	frames int
+	buffer []byte
+	offset int
+}
+
+func (r *trackReader) Read(p []byte) (int, error) {
+	if r.offset == len(r.buffer) {
+		if r.frame == r.frames {
+			return 0, io.EOF
+		}
+
+		b := make([]byte, r.i.UnitBytes)
+		if _, err := r.i.ReadAt(b, int64(r.track.Offset+r.frame)*int64(r.i.UnitBytes)); err != nil {
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.frame++
+
+		r.buffer, r.offset = b[:r.track.SectorSize()], 0
+
+		// Audio is stored big-endian
+		if r.track.IsAudioTrack() {
+			for j := 0; j < len(r.buffer); j += 2 {
+				r.buffer[j], r.buffer[j+1] = r.buffer[j+1], r.buffer[j]
+			}
+		}
+	}
+
+	n := copy(p, r.buffer[r.offset:])
+	r.offset += n
+
+	return n, nil
+}
+
+// TrackReader returns an io.Reader of the sectors of the track, including
+// any stored pregap but excluding the padding. Any subcode data is removed
+// and audio is converted to little-endian samples
+func (i *Image) TrackReader(t Track) io.Reader {
+	return &trackReader{
+		i:      i,
+		track:  t,
+		frames: t.Frames,
+	}
+}
@@ -0,0 +1,273 @@
+package chd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDeflate(b []byte) []byte {
+	c, _ := deflate(b)
+	return c
+}
+
+type testHunk struct {
+	compression int
+	raw         []byte
+	data        []byte
+	self        uint32
+}
+
+// testImage builds a CHD image using a compressed map where every
+// compression type has a four bit code
+func testImage(hunkBytes uint32, compressors [4]Codec, hunks []testHunk, metadata []string) []byte {
+	b := make([]byte, headerSize)
+	copy(b, magic)
+	binary.BigEndian.PutUint32(b[8:], headerSize)
+	binary.BigEndian.PutUint32(b[12:], version)
+	for i, c := range compressors {
+		binary.BigEndian.PutUint32(b[16+i*4:], uint32(c))
+	}
+	binary.BigEndian.PutUint64(b[32:], uint64(len(hunks))*uint64(hunkBytes))
+	binary.BigEndian.PutUint32(b[56:], hunkBytes)
+	binary.BigEndian.PutUint32(b[60:], frameSize)
+
+	first := uint64(len(b))
+	w := new(bitWriter)
+	for i := 0; i < 16; i++ {
+		w.write(4, 4)
+	}
+	for _, h := range hunks {
+		w.write(uint32(h.compression), 4)
+	}
+	raw := new(bytes.Buffer)
+	offset := first
+	for _, h := range hunks {
+		e := make([]byte, 12)
+		e[0] = byte(h.compression)
+		switch h.compression {
+		case compressionSelf:
+			w.write(h.self, 8)
+			e[9] = byte(h.self)
+		default:
+			crc := crc16(h.raw)
+			if h.compression == compressionNone {
+				h.data = h.raw
+			} else {
+				w.write(uint32(len(h.data)), 24)
+			}
+			w.write(uint32(crc), 16)
+			e[1], e[2], e[3] = byte(len(h.data)>>16), byte(len(h.data)>>8), byte(len(h.data))
+			for k := 0; k < 6; k++ {
+				e[4+k] = byte(offset >> (40 - 8*uint(k)))
+			}
+			binary.BigEndian.PutUint16(e[10:], crc)
+			b = append(b, h.data...)
+			offset += uint64(len(h.data))
+		}
+		raw.Write(e)
+	}
+
+	binary.BigEndian.PutUint64(b[40:], uint64(len(b)))
+	m := make([]byte, 16)
+	binary.BigEndian.PutUint32(m, uint32(len(w.b)))
+	for k := 0; k < 6; k++ {
+		m[4+k] = byte(first >> (40 - 8*uint(k)))
+	}
+	binary.BigEndian.PutUint16(m[10:], crc16(raw.Bytes()))
+	m[12], m[13], m[14] = 24, 8, 8
+	b = append(b, m...)
+	b = append(b, w.b...)
+
+	if len(metadata) > 0 {
+		binary.BigEndian.PutUint64(b[48:], uint64(len(b)))
+	}
+	for i, s := range metadata {
+		m := make([]byte, metadataHeaderSize)
+		binary.BigEndian.PutUint32(m, tagGDTrack)
+		s += "\x00"
+		m[5], m[6], m[7] = byte(len(s)>>16), byte(len(s)>>8), byte(len(s))
+		if i < len(metadata)-1 {
+			binary.BigEndian.PutUint64(m[8:], uint64(len(b)+len(m)+len(s)))
+		}
+		b = append(b, m...)
+		b = append(b, s...)
+	}
+
+	return b
+}
+
+// cdzl compresses the frames with deflate, optionally removing the sync
+// pattern and ECC from each sector
+func cdzl(b []byte, ecc bool) []byte {
+	frames := len(b) / frameSize
+	data, subcode := new(bytes.Buffer), new(bytes.Buffer)
+	bitmap := make([]byte, (frames+7)/8)
+	for i := 0; i < frames; i++ {
+		s := make([]byte, sector.Size)
+		copy(s, b[i*frameSize:])
+		if ecc {
+			bitmap[i/8] |= 1 << uint(i%8)
+			copy(s, make([]byte, 12))
+			copy(s[0x81c:], make([]byte, sector.Size-0x81c))
+		}
+		data.Write(s)
+		subcode.Write(b[i*frameSize+sector.Size : (i+1)*frameSize])
+	}
+
+	compressed := testDeflate(data.Bytes())
+	out := bitmap
+	if len(b) >= 65536 {
+		out = append(out, byte(len(compressed)>>16))
+	}
+	out = append(out, byte(len(compressed)>>8), byte(len(compressed)))
+	out = append(out, compressed...)
+	return append(out, testDeflate(subcode.Bytes())...)
+}
+
+func testFrames(frames int, f func(int) []byte) []byte {
+	b := make([]byte, frames*frameSize)
+	for i := 0; i < frames; i++ {
+		copy(b[i*frameSize:], f(i))
+	}
+	return b
+}
+
+func TestNewImage(t *testing.T) {
+	const hunkBytes = 8 * frameSize
+
+	data := testFrames(8, func(i int) []byte {
+		s, _ := sector.New(sector.Mode1, i, []byte(fmt.Sprintf("SECTOR %d", i)))
+		return s
+	})
+	audio := testFrames(8, func(i int) []byte {
+		if i >= 6 {
+			return nil
+		}
+		return bytes.Repeat([]byte{0x12, 0x34}, sector.Size/2)
+	})
+
+	b := testImage(hunkBytes, [4]Codec{CodecCDZL}, []testHunk{
+		{compression: compressionType0, raw: data, data: cdzl(data, true)},
+		{compression: compressionNone, raw: audio},
+		{compression: compressionSelf, self: 0},
+	}, []string{
+		"TRACK:1 TYPE:MODE1_RAW SUBTYPE:NONE FRAMES:8 PAD:0 PREGAP:0 PGTYPE:MODE1 PGSUB:RW POSTGAP:0",
+		"TRACK:2 TYPE:AUDIO SUBTYPE:NONE FRAMES:6 PAD:2 PREGAP:0 PGTYPE:MODE1 PGSUB:RW POSTGAP:0",
+		"TRACK:3 TYPE:MODE1_RAW SUBTYPE:NONE FRAMES:8 PAD:0 PREGAP:0 PGTYPE:MODE1 PGSUB:RW POSTGAP:0",
+	})
+
+	image, err := NewImage(bytes.NewReader(b))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, true, image.GDROM)
+	assert.Equal(t, uint32(hunkBytes), image.HunkBytes)
+	assert.Equal(t, []Track{
+		{1, "MODE1_RAW", "NONE", 8, 0, 0, "MODE1", "RW", 0, 0},
+		{2, "AUDIO", "NONE", 6, 2, 0, "MODE1", "RW", 0, 8},
+		{3, "MODE1_RAW", "NONE", 8, 0, 0, "MODE1", "RW", 0, 16},
+	}, image.Tracks)
+
+	hunk := make([]byte, hunkBytes)
+	assert.Nil(t, image.ReadHunk(0, hunk))
+	assert.Equal(t, data, hunk)
+
+	for i, track := range image.Tracks {
+		assert.Equal(t, i == 1, track.IsAudioTrack())
+		assert.Equal(t, i != 1, track.IsDataTrack())
+		assert.Equal(t, 2352, track.SectorSize())
+		assert.Equal(t, false, track.HasPregap())
+
+		b, err := ioutil.ReadAll(image.TrackReader(track))
+		assert.Nil(t, err)
+		assert.Equal(t, track.Length(), int64(len(b)))
+
+		switch {
+		case track.IsAudioTrack():
+			// Audio is swapped to little-endian
+			assert.Equal(t, bytes.Repeat([]byte{0x34, 0x12}, 6*sector.Size/2), b)
+		default:
+			for j := 0; j < 8; j++ {
+				assert.Equal(t, data[j*frameSize:j*frameSize+sector.Size], b[j*sector.Size:(j+1)*sector.Size])
+			}
+		}
+	}
+}
+
+func TestNewImageErrors(t *testing.T) {
+	valid := testImage(frameSize, [4]Codec{CodecZlib}, []testHunk{{compression: compressionNone, raw: make([]byte, frameSize)}}, nil)
+
+	badMagic := append([]byte{}, valid...)
+	badMagic[0] = 'X'
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[15] = 4
+
+	badCRC := append([]byte{}, valid...)
+	badCRC[len(badCRC)-1] ^= 0xff
+
+	tables := []struct {
+		got []byte
+		err error
+	}{
+		{valid[:10], io.ErrUnexpectedEOF},
+		{badMagic, errInvalidHeader},
+		{badVersion, errInvalidVersion},
+		{badCRC, errInvalidMap},
+		{
+			testImage(frameSize, [4]Codec{CodecZlib}, []testHunk{{compression: compressionNone, raw: make([]byte, frameSize)}}, []string{
+				"TRACK:1 TYPE:MODE3 SUBTYPE:NONE FRAMES:1 PAD:0 PREGAP:0 PGTYPE:MODE1 PGSUB:RW POSTGAP:0",
+			}),
+			errUnsupportedTrack,
+		},
+		{
+			testImage(frameSize, [4]Codec{CodecZlib}, []testHunk{{compression: compressionNone, raw: make([]byte, frameSize)}}, []string{
+				"TRACK:2 TYPE:AUDIO SUBTYPE:NONE FRAMES:1 PAD:0 PREGAP:0 PGTYPE:MODE1 PGSUB:RW POSTGAP:0",
+			}),
+			errInvalidTrack,
+		},
+	}
+
+	for _, table := range tables {
+		_, err := NewImage(bytes.NewReader(table.got))
+		assert.True(t, errors.Is(err, table.err), err)
+	}
+
+	// A corrupt hunk is detected when read
+	b := testImage(frameSize, [4]Codec{CodecZlib}, []testHunk{{compression: compressionType0, raw: make([]byte, frameSize), data: testDeflate(make([]byte, frameSize))}}, nil)
+	b[headerSize] ^= 0xff
+	image, err := NewImage(bytes.NewReader(b))
+	if assert.Nil(t, err) {
+		assert.NotNil(t, image.ReadHunk(0, make([]byte, frameSize)))
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	hunks := []testHunk{
+		{compression: compressionNone, raw: bytes.Repeat([]byte{1}, frameSize)},
+		{compression: compressionType0, raw: bytes.Repeat([]byte{2}, frameSize), data: testDeflate(bytes.Repeat([]byte{2}, frameSize))},
+	}
+	image, err := NewImage(bytes.NewReader(testImage(frameSize, [4]Codec{CodecZlib}, hunks, nil)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	b := make([]byte, 4)
+	n, err := image.ReadAt(b, frameSize-2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []byte{1, 1, 2, 2}, b)
+
+	n, err = image.ReadAt(b, 2*frameSize-2)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+}
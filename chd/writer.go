@@ -0,0 +1,442 @@
+package chd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+)
+
+const (
+	// FramesPerHunk is the number of frames in each hunk written
+	FramesPerHunk = 8
+
+	metadataChecksum = 0x01
+)
+
+// The codecs listed in the header of a written image. chdman also lists
+// CDFL but as there's no FLAC compressor only codecs that can be used to
+// compress hunks are listed
+var writerCodecs = [4]Codec{CodecCDLZ, CodecCDZL, CodecNone, CodecNone}
+
+var errInvalidFrames = errors.New("invalid number of frames")
+
+// Writer writes a CHD image of a CD or GD-ROM. The sectors of each track
+// are written in turn, the hunk map and track metadata are written when the
+// Writer is closed
+type Writer struct {
+	w           io.WriteSeeker
+	gdrom       bool
+	header      Header
+	compressors [4]compressor
+	tracks      []Track
+	track       *trackWriter
+	hunk        []byte
+	n           int
+	hunks       []hunk
+	seen        map[[sha1.Size]byte]int
+	offset      uint64
+	frames      int
+	sha1        hash.Hash
+}
+
+type trackWriter struct {
+	w      *Writer
+	track  Track
+	buffer []byte
+	frames int
+}
+
+func (t *trackWriter) Write(p []byte) (int, error) {
+	size := t.track.SectorSize()
+	n := 0
+	for len(p) > 0 {
+		c := copy(t.buffer[len(t.buffer):size], p)
+		t.buffer = t.buffer[:len(t.buffer)+c]
+		p = p[c:]
+		n += c
+
+		if len(t.buffer) < size {
+			break
+		}
+
+		// Audio is stored big-endian
+		if t.track.IsAudioTrack() {
+			for i := 0; i < len(t.buffer); i += 2 {
+				t.buffer[i], t.buffer[i+1] = t.buffer[i+1], t.buffer[i]
+			}
+		}
+
+		if err := t.w.writeFrame(t.buffer); err != nil {
+			return n, err
+		}
+		t.buffer = t.buffer[:0]
+		t.frames++
+	}
+
+	return n, nil
+}
+
+// NewWriter returns a Writer that writes a CHD image to w. If gdrom is true
+// the GD-ROM track metadata is used
+func NewWriter(w io.WriteSeeker, gdrom bool) (*Writer, error) {
+	hunkBytes := uint32(FramesPerHunk * frameSize)
+
+	writer := &Writer{
+		w:     w,
+		gdrom: gdrom,
+		header: Header{
+			Compressors: writerCodecs,
+			HunkBytes:   hunkBytes,
+			UnitBytes:   frameSize,
+		},
+		hunk:   make([]byte, hunkBytes),
+		seen:   make(map[[sha1.Size]byte]int),
+		offset: headerSize,
+		sha1:   sha1.New(),
+	}
+
+	for i, c := range writer.header.Compressors {
+		writer.compressors[i] = newCompressor(c, hunkBytes)
+	}
+
+	// The header is rewritten once everything else is known
+	if _, err := w.Write(make([]byte, headerSize)); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *Writer) writeFrame(b []byte) error {
+	copy(w.hunk[w.n:w.n+frameSize], b)
+	for i := w.n + len(b); i < w.n+frameSize; i++ {
+		w.hunk[i] = 0
+	}
+	w.n += frameSize
+	w.frames++
+
+	if w.n < len(w.hunk) {
+		return nil
+	}
+
+	return w.flushHunk()
+}
+
+func (w *Writer) flushHunk() error {
+	if w.n == 0 {
+		return nil
+	}
+
+	// The logical data excludes any padding of the final hunk
+	w.sha1.Write(w.hunk[:w.n])
+	for i := w.n; i < len(w.hunk); i++ {
+		w.hunk[i] = 0
+	}
+	w.n = 0
+
+	h := hunk{
+		crc: crc16(w.hunk),
+	}
+
+	sum := sha1.Sum(w.hunk)
+	if n, ok := w.seen[sum]; ok {
+		h.compression, h.offset, h.crc = compressionSelf, uint64(n), 0
+		w.hunks = append(w.hunks, h)
+		return nil
+	}
+	w.seen[sum] = len(w.hunks)
+
+	// Use whichever codec gives the smallest result
+	data := w.hunk
+	h.compression, h.length = compressionNone, w.header.HunkBytes
+	for i, c := range w.compressors {
+		if c == nil {
+			continue
+		}
+		b, err := c(w.hunk)
+		if err != nil {
+			if errors.Is(err, errCompression) {
+				continue
+			}
+			return err
+		}
+		if len(b) < len(data) {
+			data, h.compression, h.length = b, i, uint32(len(b))
+		}
+	}
+
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	h.offset = w.offset
+	w.offset += uint64(len(data))
+	w.hunks = append(w.hunks, h)
+
+	return nil
+}
+
+func (w *Writer) finishTrack() error {
+	if w.track == nil {
+		return nil
+	}
+
+	t := w.track
+	w.track = nil
+
+	if len(t.buffer) != 0 || t.frames == 0 {
+		return errInvalidFrames
+	}
+
+	t.track.Frames = t.frames
+
+	// Each track is padded to a multiple of frames
+	for w.frames%padding != 0 {
+		if err := w.writeFrame(nil); err != nil {
+			return err
+		}
+		t.track.Pad++
+	}
+
+	w.tracks = append(w.tracks, t.track)
+
+	return nil
+}
+
+// CreateTrack finishes any previous track and returns an io.Writer for the
+// sectors of a new track. The Number, Frames, Pad and Offset of the passed
+// track are ignored and instead calculated from the sectors written.
+// Audio is expected to be little-endian samples as found in a GDI file
+func (w *Writer) CreateTrack(track Track) (io.Writer, error) {
+	if err := w.finishTrack(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := sectorSizes[track.Type]; !ok {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedTrack, track.Type)
+	}
+
+	track.Number, track.Frames, track.Pad, track.Offset = len(w.tracks)+1, 0, 0, w.frames
+	w.track = &trackWriter{
+		w:      w,
+		track:  track,
+		buffer: make([]byte, 0, track.SectorSize()),
+	}
+
+	return w.track, nil
+}
+
+func (t Track) marshalText(gdrom bool) []byte {
+	if gdrom {
+		return []byte(fmt.Sprintf("TRACK:%d TYPE:%s SUBTYPE:%s FRAMES:%d PAD:%d PREGAP:%d PGTYPE:%s PGSUB:%s POSTGAP:%d\x00", t.Number, t.Type, t.SubType, t.Frames, t.Pad, t.Pregap, t.PregapType, t.PregapSubType, t.Postgap))
+	}
+	return []byte(fmt.Sprintf("TRACK:%d TYPE:%s SUBTYPE:%s FRAMES:%d PREGAP:%d PGTYPE:%s PGSUB:%s POSTGAP:%d\x00", t.Number, t.Type, t.SubType, t.Frames, t.Pregap, t.PregapType, t.PregapSubType, t.Postgap))
+}
+
+// writeMetadata writes the track metadata and returns the SHA-1 of the
+// data and metadata combined
+func (w *Writer) writeMetadata() ([]byte, error) {
+	tag := tagCDTrack
+	if w.gdrom {
+		tag = tagGDTrack
+	}
+
+	w.header.MetaOffset = w.offset
+
+	var hashes [][]byte
+	for i, track := range w.tracks {
+		data := track.marshalText(w.gdrom)
+
+		b := make([]byte, metadataHeaderSize, metadataHeaderSize+len(data))
+		binary.BigEndian.PutUint32(b, tag)
+		b[4] = metadataChecksum
+		b[5], b[6], b[7] = byte(len(data)>>16), byte(len(data)>>8), byte(len(data))
+		if i+1 < len(w.tracks) {
+			binary.BigEndian.PutUint64(b[8:], w.offset+uint64(len(b)+len(data)))
+		}
+		b = append(b, data...)
+
+		if _, err := w.w.Write(b); err != nil {
+			return nil, err
+		}
+		w.offset += uint64(len(b))
+
+		sum := sha1.Sum(data)
+		hashes = append(hashes, append(b[:4:4], sum[:]...))
+	}
+
+	// The overall hash covers the data hash and the sorted hashes of
+	// each metadata entry
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i], hashes[j]) < 0
+	})
+
+	h := sha1.New()
+	h.Write(w.header.RawSHA1[:])
+	for _, b := range hashes {
+		h.Write(b)
+	}
+
+	return h.Sum(nil), nil
+}
+
+func (w *Writer) writeMap() error {
+	r := new(bitWriter)
+
+	// Every compression type is given a four bit code
+	for i := 0; i < 16; i++ {
+		r.write(4, 4)
+	}
+
+	// Runs of the same compression type are encoded
+	last := compressionType0
+	for i := 0; i < len(w.hunks); {
+		run := 0
+		for i+run < len(w.hunks) && w.hunks[i+run].compression == last && run < 19+255 {
+			run++
+		}
+
+		switch {
+		case run >= 19:
+			r.write(compressionRLELarge, 4)
+			r.write(uint32(run-19)>>4, 4)
+			r.write(uint32(run-19)&0xf, 4)
+			i += run
+		case run >= 3:
+			r.write(compressionRLESmall, 4)
+			r.write(uint32(run-3), 4)
+			i += run
+		default:
+			last = w.hunks[i].compression
+			r.write(uint32(last), 4)
+			i++
+		}
+	}
+
+	var maxLength, maxSelf uint64
+	for _, h := range w.hunks {
+		switch h.compression {
+		case compressionSelf:
+			if h.offset > maxSelf {
+				maxSelf = h.offset
+			}
+		case compressionNone:
+		default:
+			if uint64(h.length) > maxLength {
+				maxLength = uint64(h.length)
+			}
+		}
+	}
+	lengthBits, selfBits := bitsFor(maxLength), bitsFor(maxSelf)
+
+	raw := make([]byte, len(w.hunks)*12)
+	for i, h := range w.hunks {
+		switch h.compression {
+		case compressionSelf:
+			r.write(uint32(h.offset), selfBits)
+		case compressionNone:
+			r.write(uint32(h.crc), 16)
+		default:
+			r.write(h.length, lengthBits)
+			r.write(uint32(h.crc), 16)
+		}
+
+		e := raw[i*12:]
+		e[0] = byte(h.compression)
+		e[1], e[2], e[3] = byte(h.length>>16), byte(h.length>>8), byte(h.length)
+		for k := 0; k < 6; k++ {
+			e[4+k] = byte(h.offset >> (40 - 8*uint(k)))
+		}
+		binary.BigEndian.PutUint16(e[10:], h.crc)
+	}
+	r.align()
+
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header, uint32(len(r.bytes())))
+	for k := 0; k < 6; k++ {
+		header[4+k] = byte(uint64(headerSize) >> (40 - 8*uint(k)))
+	}
+	binary.BigEndian.PutUint16(header[10:], crc16(raw))
+	header[12], header[13], header[14] = byte(lengthBits), byte(selfBits), 0
+
+	w.header.MapOffset = w.offset
+	if _, err := w.w.Write(append(header, r.bytes()...)); err != nil {
+		return err
+	}
+	w.offset += uint64(len(header) + len(r.bytes()))
+
+	return nil
+}
+
+func (h Header) marshalBinary() []byte {
+	b := make([]byte, headerSize)
+	copy(b, magic)
+	binary.BigEndian.PutUint32(b[8:], headerSize)
+	binary.BigEndian.PutUint32(b[12:], version)
+	for i, c := range h.Compressors {
+		binary.BigEndian.PutUint32(b[16+i*4:], uint32(c))
+	}
+	binary.BigEndian.PutUint64(b[32:], h.LogicalBytes)
+	binary.BigEndian.PutUint64(b[40:], h.MapOffset)
+	binary.BigEndian.PutUint64(b[48:], h.MetaOffset)
+	binary.BigEndian.PutUint32(b[56:], h.HunkBytes)
+	binary.BigEndian.PutUint32(b[60:], h.UnitBytes)
+	copy(b[64:], h.RawSHA1[:])
+	copy(b[84:], h.SHA1[:])
+	copy(b[104:], h.ParentSHA1[:])
+	return b
+}
+
+// Header returns the header of the image, it is only complete once the
+// Writer is closed
+func (w *Writer) Header() Header {
+	return w.header
+}
+
+// Tracks returns the tracks written so far
+func (w *Writer) Tracks() []Track {
+	return w.tracks
+}
+
+// Close finishes the last track and writes the hunk map, metadata and
+// header. It does not close the underlying io.WriteSeeker
+func (w *Writer) Close() error {
+	if err := w.finishTrack(); err != nil {
+		return err
+	}
+
+	if len(w.tracks) == 0 {
+		return errInvalidTrack
+	}
+
+	w.header.LogicalBytes = uint64(w.frames) * frameSize
+	if err := w.flushHunk(); err != nil {
+		return err
+	}
+	copy(w.header.RawSHA1[:], w.sha1.Sum(nil))
+
+	sum, err := w.writeMetadata()
+	if err != nil {
+		return err
+	}
+	copy(w.header.SHA1[:], sum)
+
+	if err := w.writeMap(); err != nil {
+		return err
+	}
+
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := w.w.Write(w.header.marshalBinary()); err != nil {
+		return err
+	}
+
+	_, err = w.w.Seek(0, io.SeekEnd)
+	return err
+}
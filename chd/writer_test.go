@@ -0,0 +1,251 @@
+package chd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSeeker is an in-memory io.WriteSeeker
+type writeSeeker struct {
+	b      []byte
+	offset int
+}
+
+func (w *writeSeeker) Write(p []byte) (int, error) {
+	if n := w.offset + len(p); n > len(w.b) {
+		w.b = append(w.b, make([]byte, n-len(w.b))...)
+	}
+	copy(w.b[w.offset:], p)
+	w.offset += len(p)
+	return len(p), nil
+}
+
+func (w *writeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		w.offset = int(offset)
+	case io.SeekCurrent:
+		w.offset += int(offset)
+	case io.SeekEnd:
+		w.offset = len(w.b) + int(offset)
+	}
+	return int64(w.offset), nil
+}
+
+func testSectors(n int, f func(int) []byte) []byte {
+	b := new(bytes.Buffer)
+	for i := 0; i < n; i++ {
+		b.Write(f(i))
+	}
+	return b.Bytes()
+}
+
+// testTracks returns a data track and an audio track, neither of which is
+// a multiple of four frames
+func testTracks() [][]byte {
+	data := testSectors(30, func(i int) []byte {
+		s, _ := sector.New(sector.Mode1, i, []byte(fmt.Sprintf("SECTOR %d", i)))
+		return s
+	})
+	audio := testSectors(21, func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i), 0x80}, sector.Size/2)
+	})
+	return [][]byte{data, audio}
+}
+
+// writeTestImage writes the test tracks to a GD-ROM image
+func writeTestImage(t *testing.T) []byte {
+	ws := new(writeSeeker)
+	w, err := NewWriter(ws, true)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for i, b := range testTracks() {
+		track := Track{Type: "MODE1_RAW", SubType: "NONE", PregapType: "MODE1", PregapSubType: "RW"}
+		if i == 1 {
+			track.Type = "AUDIO"
+		}
+
+		tw, err := w.CreateTrack(track)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		// Write in odd sized chunks
+		for r := bytes.NewReader(b); r.Len() > 0; {
+			if _, err := io.CopyN(tw, r, 1000); err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	assert.Nil(t, w.Close())
+
+	image, err := NewImage(bytes.NewReader(ws.b))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, w.Tracks(), image.Tracks)
+	assert.Equal(t, w.Header(), image.Header)
+
+	return ws.b
+}
+
+// checkSHA1 checks the hashes in the header of the image against those
+// computed as chdman does from the data and metadata
+func checkSHA1(t *testing.T, image *Image) {
+	// The hash of the data
+	logical := make([]byte, image.LogicalBytes)
+	_, err := image.ReadAt(logical, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, sha1.Sum(logical), image.RawSHA1)
+
+	// The hash of the data and the sorted hashes of the metadata
+	var hashes [][]byte
+	for _, track := range image.Tracks {
+		sum := sha1.Sum(track.marshalText(image.GDROM))
+		hashes = append(hashes, append([]byte("CHGD"), sum[:]...))
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i], hashes[j]) < 0
+	})
+
+	h := sha1.New()
+	h.Write(image.RawSHA1[:])
+	for _, b := range hashes {
+		h.Write(b)
+	}
+	assert.Equal(t, h.Sum(nil), image.SHA1[:])
+}
+
+func TestWriter(t *testing.T) {
+	image, err := NewImage(bytes.NewReader(writeTestImage(t)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, true, image.GDROM)
+	assert.Equal(t, [4]Codec{CodecCDLZ, CodecCDZL, CodecNone, CodecNone}, image.Compressors)
+	// Tracks are stored back to back, each padded to a multiple of four
+	// frames
+	assert.Equal(t, []Track{
+		{1, "MODE1_RAW", "NONE", 30, 2, 0, "MODE1", "RW", 0, 0},
+		{2, "AUDIO", "NONE", 21, 3, 0, "MODE1", "RW", 0, 32},
+	}, image.Tracks)
+	assert.Equal(t, uint64(56*frameSize), image.LogicalBytes)
+
+	for i, expected := range testTracks() {
+		b, err := ioutil.ReadAll(image.TrackReader(image.Tracks[i]))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+	}
+
+	checkSHA1(t, image)
+}
+
+func TestWriterFixture(t *testing.T) {
+	// testdata/gdrom.chd holds the test tracks. The hashes in its header
+	// must still match both those computed from the data and metadata
+	// and those of an image written now
+	f, err := os.Open(filepath.Join("testdata", "gdrom.chd"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+
+	fixture, err := NewImage(f)
+	if !assert.Nil(t, err) {
+		return
+	}
+	checkSHA1(t, fixture)
+
+	for i, expected := range testTracks() {
+		b, err := ioutil.ReadAll(fixture.TrackReader(fixture.Tracks[i]))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+	}
+
+	image, err := NewImage(bytes.NewReader(writeTestImage(t)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, fixture.Tracks, image.Tracks)
+	assert.Equal(t, fixture.LogicalBytes, image.LogicalBytes)
+	assert.Equal(t, fixture.RawSHA1, image.RawSHA1)
+	assert.Equal(t, fixture.SHA1, image.SHA1)
+}
+
+func TestWriterMap(t *testing.T) {
+	ws := new(writeSeeker)
+	w, err := NewWriter(ws, false)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tw, err := w.CreateTrack(Track{Type: "MODE1", SubType: "NONE", PregapType: "MODE1", PregapSubType: "NONE"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Enough identical hunks to need long runs of the same compression
+	// type, with the odd unique hunk
+	for i := 0; i < 300*FramesPerHunk; i++ {
+		b := make([]byte, sector.DataSize)
+		if i%(50*FramesPerHunk) == 0 {
+			copy(b, fmt.Sprintf("UNIQUE %d", i))
+		}
+		_, err := tw.Write(b)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+
+	image, err := NewImage(bytes.NewReader(ws.b))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, false, image.GDROM)
+	assert.Equal(t, 300*FramesPerHunk, image.Tracks[0].Frames)
+
+	b, err := ioutil.ReadAll(image.TrackReader(image.Tracks[0]))
+	assert.Nil(t, err)
+	for i := 0; i < 300*FramesPerHunk; i++ {
+		s := b[i*sector.DataSize : (i+1)*sector.DataSize]
+		if i%(50*FramesPerHunk) == 0 {
+			assert.Equal(t, fmt.Sprintf("UNIQUE %d", i), string(bytes.TrimRight(s, "\x00")))
+		} else {
+			assert.Equal(t, make([]byte, sector.DataSize), s)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w, err := NewWriter(new(writeSeeker), true)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.True(t, errors.Is(w.Close(), errInvalidTrack))
+
+	_, err = w.CreateTrack(Track{Type: "MODE3"})
+	assert.True(t, errors.Is(err, errUnsupportedTrack))
+
+	tw, err := w.CreateTrack(Track{Type: "AUDIO"})
+	assert.Nil(t, err)
+	_, err = tw.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, errInvalidFrames, w.Close())
+}
@@ -0,0 +1,107 @@
+package cue
+
+import (
+	"fmt"
+)
+
+func ExampleSheet_MarshalText() {
+	sheet := Sheet{
+		Files: []File{
+			{
+				Name: "track01.bin",
+				Tracks: []Track{
+					{
+						Number: 1,
+						Type:   TypeMode1,
+						Area:   AreaSingleDensity,
+						Indexes: []Index{
+							{
+								Number: 1,
+								Offset: 0,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name: "track02.raw",
+				Tracks: []Track{
+					{
+						Number: 2,
+						Type:   TypeAudio,
+						Area:   AreaSingleDensity,
+						Pregap: 150,
+						Indexes: []Index{
+							{
+								Number: 1,
+								Offset: 0,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name: "track03.bin",
+				Tracks: []Track{
+					{
+						Number: 3,
+						Type:   TypeMode1,
+						Area:   AreaHighDensity,
+						Indexes: []Index{
+							{
+								Number: 1,
+								Offset: 0,
+							},
+						},
+					},
+				},
+			},
+		},
+		Flags: 0,
+	}
+
+	cue, err := sheet.MarshalText()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(cue))
+	// Output: REM SINGLE-DENSITY AREA
+	// FILE "track01.bin" BINARY
+	//   TRACK 01 MODE1/2352
+	//     INDEX 01 00:00:00
+	// FILE "track02.raw" BINARY
+	//   TRACK 02 AUDIO
+	//     PREGAP 00:02:00
+	//     INDEX 01 00:00:00
+	// REM HIGH-DENSITY AREA
+	// FILE "track03.bin" BINARY
+	//   TRACK 03 MODE1/2352
+	//     INDEX 01 00:00:00
+}
+
+func ExampleMSF() {
+	fmt.Println(MSF(225))
+	// Output: 00:03:00
+}
+
+func ExampleSheet_UnmarshalText() {
+	cue := `FILE "disc.bin" BINARY
+  TRACK 01 MODE1/2352
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:04:00
+    INDEX 01 00:06:00
+`
+
+	sheet := new(Sheet)
+	if err := sheet.UnmarshalText([]byte(cue)); err != nil {
+		panic(err)
+	}
+
+	for _, track := range sheet.Files[0].Tracks {
+		fmt.Println(track.Number, track.Type, track.Indexes)
+	}
+	// Output: 1 MODE1/2352 [{1 0}]
+	// 2 AUDIO [{0 300} {1 450}]
+}
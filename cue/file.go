@@ -0,0 +1,439 @@
+/*
+Package cue implements parsing of cue sheets describing Sega Dreamcast
+game images. Basic checks are performed pre-marshalling or
+post-unmarshalling to ensure it is valid.
+*/
+package cue
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Extension is the conventional file extension used
+	Extension = ".cue"
+	// FramesPerSecond is the number of sectors, or frames, per second
+	// used when formatting MSF timestamps
+	FramesPerSecond = 75
+)
+
+const (
+	minTracks = 1
+	maxTracks = 99
+	maxIndex  = 99
+)
+
+// Type represents the type of track
+type Type int
+
+const (
+	// TypeAudio is used for audio tracks
+	TypeAudio Type = iota
+	// TypeMode1 is used for raw Mode 1 data tracks
+	TypeMode1
+	// TypeMode2 is used for raw Mode 2 data tracks
+	TypeMode2
+)
+
+var typeToString = map[Type]string{
+	TypeAudio: "AUDIO",
+	TypeMode1: "MODE1/2352",
+	TypeMode2: "MODE2/2352",
+}
+
+func (t Type) String() string {
+	return typeToString[t]
+}
+
+// Area represents the density area of a GD-ROM a track belongs to
+type Area int
+
+const (
+	// AreaUnknown is used when no area has been specified
+	AreaUnknown Area = iota
+	// AreaSingleDensity is used for tracks in the low density area
+	AreaSingleDensity
+	// AreaHighDensity is used for tracks in the high density area
+	AreaHighDensity
+)
+
+var stringToType = map[string]Type{
+	"AUDIO":      TypeAudio,
+	"MODE1/2352": TypeMode1,
+	"MODE2/2352": TypeMode2,
+}
+
+var areaToString = map[Area]string{
+	AreaSingleDensity: "SINGLE-DENSITY AREA",
+	AreaHighDensity:   "HIGH-DENSITY AREA",
+}
+
+func (a Area) String() string {
+	return areaToString[a]
+}
+
+// Flag represents additional formatting tweaks
+type Flag int
+
+const (
+	// TrimWhitespace disables indentation with additional spaces
+	TrimWhitespace Flag = 1 << iota
+)
+
+var (
+	errNotEnoughTracks     = errors.New("not enough tracks")
+	errTooManyTracks       = errors.New("too many tracks")
+	errNonContinuousTracks = errors.New("non-continuous tracks")
+	errInvalidType         = errors.New("invalid track type")
+	errInvalidPregap       = errors.New("invalid pregap")
+	errMissingIndex        = errors.New("missing index")
+	errInvalidIndex        = errors.New("invalid index")
+	errInvalidMSF          = errors.New("invalid MSF timestamp")
+	errInvalidSession      = errors.New("invalid session")
+	errInvalidCommand      = errors.New("invalid command")
+	errMissingFile         = errors.New("missing file")
+	errMissingTrack        = errors.New("missing track")
+	errUnsupportedCommand  = errors.New("unsupported command")
+	errUnsupportedFileType = errors.New("unsupported file type")
+	errUnsupportedType     = errors.New("unsupported track type")
+)
+
+// Sheet represents a cue sheet
+type Sheet struct {
+	// Files contains each file
+	Files []File
+	// Flags manages any additional formatting tweaks
+	Flags Flag
+}
+
+// File represents a single file within a cue sheet
+type File struct {
+	// Name is the filename relative to the cue sheet
+	Name string
+	// Tracks contains each track stored within the file
+	Tracks []Track
+}
+
+// Track represents a single track within a cue sheet
+type Track struct {
+	// Number is the track number
+	Number int
+	// Type refers to the type of track, audio or data
+	Type Type
+	// Area refers to the density area of the track, if known
+	Area Area
+	// Session refers to the session number of the track, if known
+	Session int
+	// Pregap is the number of pregap sectors not stored in the file
+	Pregap int
+	// Indexes contains each index
+	Indexes []Index
+}
+
+// Index represents an index point within a track
+type Index struct {
+	// Number is the index number
+	Number int
+	// Offset is the sector offset of the index relative to the start
+	// of the file
+	Offset int
+}
+
+// IsAudioTrack returns true if the track is audio
+func (t Track) IsAudioTrack() bool {
+	return t.Type == TypeAudio
+}
+
+// IsDataTrack returns true if the track is data
+func (t Track) IsDataTrack() bool {
+	return t.Type == TypeMode1 || t.Type == TypeMode2
+}
+
+// MSF formats a sector count as a minutes:seconds:frames timestamp
+func MSF(sectors int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", sectors/FramesPerSecond/60, sectors/FramesPerSecond%60, sectors%FramesPerSecond)
+}
+
+// ParseMSF parses a minutes:seconds:frames timestamp into a sector count
+func ParseMSF(s string) (int, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return 0, errInvalidMSF
+	}
+
+	msf := make([]int, len(fields))
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return 0, errInvalidMSF
+		}
+		msf[i] = n
+	}
+
+	if msf[1] >= 60 || msf[2] >= FramesPerSecond {
+		return 0, errInvalidMSF
+	}
+
+	return (msf[0]*60+msf[1])*FramesPerSecond + msf[2], nil
+}
+
+func (s Sheet) validate() error {
+	number, session := 0, 0
+	for _, file := range s.Files {
+		offset := -1
+		for _, track := range file.Tracks {
+			number++
+			if track.Number != number {
+				return errNonContinuousTracks
+			}
+
+			if track.Session < 0 || (track.Session != 0 && track.Session < session) {
+				return errInvalidSession
+			}
+			if track.Session != 0 {
+				session = track.Session
+			}
+
+			if _, ok := typeToString[track.Type]; !ok {
+				return errInvalidType
+			}
+
+			if track.Pregap < 0 {
+				return errInvalidPregap
+			}
+
+			if len(track.Indexes) == 0 {
+				return errMissingIndex
+			}
+
+			index := -1
+			for _, i := range track.Indexes {
+				if i.Number <= index || i.Number > maxIndex || i.Offset < offset {
+					return errInvalidIndex
+				}
+				index, offset = i.Number, i.Offset
+			}
+
+			// Must have either INDEX 00 followed by INDEX 01, or
+			// start with INDEX 01
+			switch track.Indexes[0].Number {
+			case 0:
+				if len(track.Indexes) < 2 || track.Indexes[1].Number != 1 {
+					return errMissingIndex
+				}
+			case 1:
+			default:
+				return errMissingIndex
+			}
+		}
+	}
+
+	if number < minTracks {
+		return errNotEnoughTracks
+	}
+
+	if number > maxTracks {
+		return errTooManyTracks
+	}
+
+	return nil
+}
+
+// IsValid checks if the cue sheet is valid or not
+func (s Sheet) IsValid() bool {
+	if err := s.validate(); err != nil {
+		return false
+	}
+	return true
+}
+
+// MarshalText encodes the cue sheet into textual form
+func (s Sheet) MarshalText() ([]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	b := new(bytes.Buffer)
+
+	trackIndent, indexIndent := "  ", "    "
+	if s.Flags&TrimWhitespace != 0 {
+		trackIndent, indexIndent = "", ""
+	}
+
+	area, session := AreaUnknown, 0
+	for _, file := range s.Files {
+		for i, track := range file.Tracks {
+			if track.Session != 0 && track.Session != session {
+				fmt.Fprintf(b, "REM SESSION %02d\n", track.Session)
+			}
+			session = track.Session
+
+			if track.Area != AreaUnknown && track.Area != area {
+				fmt.Fprintf(b, "REM %s\n", track.Area)
+			}
+			area = track.Area
+
+			if i == 0 {
+				fmt.Fprintf(b, "FILE \"%s\" BINARY\n", file.Name)
+			}
+
+			fmt.Fprintf(b, "%sTRACK %02d %s\n", trackIndent, track.Number, track.Type)
+
+			if track.Pregap > 0 {
+				fmt.Fprintf(b, "%sPREGAP %s\n", indexIndent, MSF(track.Pregap))
+			}
+
+			for _, index := range track.Indexes {
+				fmt.Fprintf(b, "%sINDEX %02d %s\n", indexIndent, index.Number, MSF(index.Offset))
+			}
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+func split(s string) []string {
+	var withinQuotes = false
+	return strings.FieldsFunc(s, func(c rune) bool {
+		if c == '"' {
+			withinQuotes = !withinQuotes
+		}
+		return unicode.IsSpace(c) && !withinQuotes
+	})
+}
+
+func (s *Sheet) parseLine(fields []string, area *Area, session *int) error {
+	var file *File
+	if len(s.Files) > 0 {
+		file = &s.Files[len(s.Files)-1]
+	}
+
+	var track *Track
+	if file != nil && len(file.Tracks) > 0 {
+		track = &file.Tracks[len(file.Tracks)-1]
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "REM":
+		// Only the session and density area markers are meaningful,
+		// any other comment is ignored
+		if len(fields) == 3 && strings.EqualFold(fields[1], "SESSION") {
+			n, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return err
+			}
+			*session = n
+			break
+		}
+		for a, str := range areaToString {
+			if strings.EqualFold(strings.Join(fields[1:], " "), str) {
+				*area = a
+			}
+		}
+	case "CATALOG", "CDTEXTFILE", "FLAGS", "ISRC", "PERFORMER", "SONGWRITER", "TITLE":
+		// Metadata that doesn't affect the layout of the tracks
+	case "FILE":
+		if len(fields) != 3 {
+			return errInvalidCommand
+		}
+		if !strings.EqualFold(fields[2], "BINARY") {
+			return fmt.Errorf("%w %q", errUnsupportedFileType, fields[2])
+		}
+		s.Files = append(s.Files, File{
+			Name: strings.Trim(fields[1], `"`),
+		})
+	case "TRACK":
+		if len(fields) != 3 {
+			return errInvalidCommand
+		}
+		if file == nil {
+			return errMissingFile
+		}
+		number, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		t, ok := stringToType[strings.ToUpper(fields[2])]
+		if !ok {
+			return fmt.Errorf("%w %q", errUnsupportedType, fields[2])
+		}
+		file.Tracks = append(file.Tracks, Track{
+			Number:  number,
+			Type:    t,
+			Area:    *area,
+			Session: *session,
+		})
+	case "PREGAP":
+		if len(fields) != 2 {
+			return errInvalidCommand
+		}
+		if track == nil {
+			return errMissingTrack
+		}
+		if len(track.Indexes) > 0 {
+			return errInvalidPregap
+		}
+		pregap, err := ParseMSF(fields[1])
+		if err != nil {
+			return err
+		}
+		track.Pregap = pregap
+	case "INDEX":
+		if len(fields) != 3 {
+			return errInvalidCommand
+		}
+		if track == nil {
+			return errMissingTrack
+		}
+		number, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		offset, err := ParseMSF(fields[2])
+		if err != nil {
+			return err
+		}
+		track.Indexes = append(track.Indexes, Index{
+			Number: number,
+			Offset: offset,
+		})
+	default:
+		return fmt.Errorf("%w %q", errUnsupportedCommand, fields[0])
+	}
+
+	return nil
+}
+
+// UnmarshalText decodes the cue sheet from textual form. Only BINARY files
+// containing raw 2352 byte sectors are supported
+func (s *Sheet) UnmarshalText(text []byte) error {
+	// Clear out any existing state
+	s.Files, s.Flags = []File{}, 0
+
+	area, session := AreaUnknown, 0
+	scanner, line := bufio.NewScanner(bytes.NewReader(text)), 0
+	for scanner.Scan() {
+		line++
+
+		// Skip any UTF-8 byte order mark and blank lines
+		fields := split(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := s.parseLine(fields, &area, &session); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return s.validate()
+}
@@ -0,0 +1,471 @@
+package cue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestType(t *testing.T) {
+	assert.Equal(t, "AUDIO", TypeAudio.String())
+	assert.Equal(t, "MODE1/2352", TypeMode1.String())
+	assert.Equal(t, "MODE2/2352", TypeMode2.String())
+}
+
+func TestMSF(t *testing.T) {
+	tables := []struct {
+		got  int
+		want string
+	}{
+		{0, "00:00:00"},
+		{74, "00:00:74"},
+		{150, "00:02:00"},
+		{4500, "01:00:00"},
+		{549149, "122:01:74"},
+	}
+
+	for _, table := range tables {
+		assert.Equal(t, table.want, MSF(table.got))
+	}
+}
+
+func TestParseMSF(t *testing.T) {
+	tables := []struct {
+		got  string
+		want int
+		err  error
+	}{
+		{"00:00:00", 0, nil},
+		{"00:02:00", 150, nil},
+		{"122:01:74", 549149, nil},
+		{"00:60:00", 0, errInvalidMSF},
+		{"00:00:75", 0, errInvalidMSF},
+		{"00:00", 0, errInvalidMSF},
+		{"00:-1:00", 0, errInvalidMSF},
+	}
+
+	for _, table := range tables {
+		n, err := ParseMSF(table.got)
+		assert.Equal(t, table.err, err)
+		assert.Equal(t, table.want, n)
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	tables := []struct {
+		got  string
+		want *Sheet
+		err  error
+	}{
+		// Redump-style sheet with density area markers
+		{
+			`REM SINGLE-DENSITY AREA
+FILE "disc (Track 1).bin" BINARY
+  TRACK 01 MODE1/2352
+    INDEX 01 00:00:00
+FILE "disc (Track 2).bin" BINARY
+  TRACK 02 AUDIO
+    INDEX 00 00:00:00
+    INDEX 01 00:02:00
+REM HIGH-DENSITY AREA
+FILE "disc (Track 3).bin" BINARY
+  TRACK 03 MODE1/2352
+    INDEX 01 00:00:00
+`,
+			&Sheet{
+				Files: []File{
+					{
+						Name: "disc (Track 1).bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   TypeMode1,
+								Area:   AreaSingleDensity,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+					{
+						Name: "disc (Track 2).bin",
+						Tracks: []Track{
+							{
+								Number: 2,
+								Type:   TypeAudio,
+								Area:   AreaSingleDensity,
+								Indexes: []Index{
+									{Number: 0, Offset: 0},
+									{Number: 1, Offset: 150},
+								},
+							},
+						},
+					},
+					{
+						Name: "disc (Track 3).bin",
+						Tracks: []Track{
+							{
+								Number: 3,
+								Type:   TypeMode1,
+								Area:   AreaHighDensity,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			nil,
+		},
+		// Single file sheet with a pregap and ignored metadata
+		{
+			`CATALOG 0000000000000
+FILE disc.bin BINARY
+TRACK 01 MODE1/2352
+REM a comment
+INDEX 01 00:00:00
+TRACK 02 AUDIO
+FLAGS DCP
+PREGAP 00:02:00
+INDEX 01 00:04:00
+`,
+			&Sheet{
+				Files: []File{
+					{
+						Name: "disc.bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   TypeMode1,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+							{
+								Number: 2,
+								Type:   TypeAudio,
+								Pregap: 150,
+								Indexes: []Index{
+									{Number: 1, Offset: 300},
+								},
+							},
+						},
+					},
+				},
+			},
+			nil,
+		},
+		// Multi-session sheet
+		{
+			`REM SESSION 01
+FILE "track01.raw" BINARY
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+REM SESSION 02
+FILE "track02.bin" BINARY
+  TRACK 02 MODE2/2352
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+`,
+			&Sheet{
+				Files: []File{
+					{
+						Name: "track01.raw",
+						Tracks: []Track{
+							{
+								Number:  1,
+								Type:    TypeAudio,
+								Session: 1,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+					{
+						Name: "track02.bin",
+						Tracks: []Track{
+							{
+								Number:  2,
+								Type:    TypeMode2,
+								Session: 2,
+								Pregap:  150,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			nil,
+		},
+		{
+			"REM SESSION 02\nFILE \"track01.bin\" BINARY\nTRACK 01 MODE1/2352\nINDEX 01 00:00:00\nREM SESSION 01\nTRACK 02 AUDIO\nINDEX 01 00:01:00\n",
+			nil,
+			errInvalidSession,
+		},
+		{
+			"FILE \"track01.wav\" WAVE\n",
+			nil,
+			errUnsupportedFileType,
+		},
+		{
+			"FILE \"track01.iso\" BINARY\nTRACK 01 MODE1/2048\n",
+			nil,
+			errUnsupportedType,
+		},
+		{
+			"FILE \"track01.bin\" BINARY\nTRACK 01 MODE1/2352\nPOSTGAP 00:02:00\n",
+			nil,
+			errUnsupportedCommand,
+		},
+		{
+			"TRACK 01 MODE1/2352\n",
+			nil,
+			errMissingFile,
+		},
+		{
+			"FILE \"track01.bin\" BINARY\nINDEX 01 00:00:00\n",
+			nil,
+			errMissingTrack,
+		},
+		{
+			"FILE \"track01.bin\" BINARY\nTRACK 01 MODE1/2352\nINDEX 01 00:00\n",
+			nil,
+			errInvalidMSF,
+		},
+		{
+			"FILE \"track01.bin\" BINARY\nTRACK 01 MODE1/2352\nINDEX 01\n",
+			nil,
+			errInvalidCommand,
+		},
+		{
+			"FILE \"track01.bin\" BINARY\nTRACK 01 MODE1/2352\n",
+			nil,
+			errMissingIndex,
+		},
+	}
+
+	for _, table := range tables {
+		s := new(Sheet)
+		err := s.UnmarshalText([]byte(table.got))
+		assert.True(t, errors.Is(err, table.err), err)
+		if err == nil {
+			assert.Equal(t, table.want, s)
+		}
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	tables := []struct {
+		got  Sheet
+		want string
+		err  error
+	}{
+		// Single file containing multiple tracks with indexes
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "disc image.bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   TypeMode1,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+							{
+								Number: 2,
+								Type:   TypeAudio,
+								Indexes: []Index{
+									{Number: 0, Offset: 300},
+									{Number: 1, Offset: 450},
+								},
+							},
+						},
+					},
+				},
+				Flags: TrimWhitespace,
+			},
+			`FILE "disc image.bin" BINARY
+TRACK 01 MODE1/2352
+INDEX 01 00:00:00
+TRACK 02 AUDIO
+INDEX 00 00:04:00
+INDEX 01 00:06:00
+`,
+			nil,
+		},
+		// Multiple sessions
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "track01.raw",
+						Tracks: []Track{
+							{
+								Number:  1,
+								Type:    TypeAudio,
+								Session: 1,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+					{
+						Name: "track02.bin",
+						Tracks: []Track{
+							{
+								Number:  2,
+								Type:    TypeMode2,
+								Session: 2,
+								Pregap:  150,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			`REM SESSION 01
+FILE "track01.raw" BINARY
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+REM SESSION 02
+FILE "track02.bin" BINARY
+  TRACK 02 MODE2/2352
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+`,
+			nil,
+		},
+		// No tracks
+		{
+			Sheet{},
+			"",
+			errNotEnoughTracks,
+		},
+		// Jump in track number
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "track02.bin",
+						Tracks: []Track{
+							{
+								Number: 2,
+								Type:   TypeMode1,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			"",
+			errNonContinuousTracks,
+		},
+		// Invalid track type
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "track01.bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   Type(-1),
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			"",
+			errInvalidType,
+		},
+		// Negative pregap
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "track01.bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   TypeMode1,
+								Pregap: -1,
+								Indexes: []Index{
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			"",
+			errInvalidPregap,
+		},
+		// No INDEX 01
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "track01.bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   TypeMode1,
+								Indexes: []Index{
+									{Number: 0, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			"",
+			errMissingIndex,
+		},
+		// Indexes go backwards
+		{
+			Sheet{
+				Files: []File{
+					{
+						Name: "track01.bin",
+						Tracks: []Track{
+							{
+								Number: 1,
+								Type:   TypeMode1,
+								Indexes: []Index{
+									{Number: 0, Offset: 150},
+									{Number: 1, Offset: 0},
+								},
+							},
+						},
+					},
+				},
+			},
+			"",
+			errInvalidIndex,
+		},
+	}
+
+	for _, table := range tables {
+		b, err := table.got.MarshalText()
+		assert.Equal(t, table.err, err)
+		if err == nil {
+			assert.Equal(t, table.want, string(b))
+		}
+	}
+}
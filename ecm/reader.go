@@ -0,0 +1,235 @@
+/*
+Package ecm implements reading and writing of ECM (Error Code Modeler)
+files. ECM reduces the size of a raw CD-ROM track by removing the sync
+pattern, EDC and ECC from each data sector where they can be regenerated
+from the remaining data, anything else is stored as is.
+*/
+package ecm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/bodgit/dreamcast/sector"
+)
+
+const (
+	// Extension is the conventional file extension used
+	Extension = ".ecm"
+)
+
+const (
+	typeLiteral = iota
+	typeMode1
+	typeMode2Form1
+	typeMode2Form2
+)
+
+const (
+	// Mode 2 sectors are stored without the sync pattern and header
+	mode2Size   = sector.Size - 16
+	maxCount    = 0x80000000
+	endOfRecord = 0xffffffff
+)
+
+var magic = []byte("ECM\x00")
+
+// The sector mode, and number of bytes stored and decoded for each type
+var (
+	modes        = [4]sector.Mode{typeLiteral: sector.ModeUnknown, typeMode1: sector.Mode1, typeMode2Form1: sector.Mode2Form1, typeMode2Form2: sector.Mode2Form2}
+	storedSizes  = [4]int{typeLiteral: 1, typeMode1: 3 + sector.DataSize, typeMode2Form1: 0x804, typeMode2Form2: 0x918}
+	decodedSizes = [4]int{typeLiteral: 1, typeMode1: sector.Size, typeMode2Form1: mode2Size, typeMode2Form2: mode2Size}
+)
+
+var (
+	errInvalidHeader = errors.New("invalid ECM header")
+	errInvalidRecord = errors.New("invalid ECM record")
+	errChecksum      = errors.New("ECM checksum mismatch")
+)
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readRecord reads the type and count of the next record. The count is
+// zero at the end of the records
+func readRecord(r io.ByteReader) (int, uint32, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, unexpected(err)
+	}
+
+	t, n := int(c&3), uint32(c>>2&0x1f)
+	for shift := uint(5); c&0x80 != 0; shift += 7 {
+		if shift > 31 {
+			return 0, 0, errInvalidRecord
+		}
+		if c, err = r.ReadByte(); err != nil {
+			return 0, 0, unexpected(err)
+		}
+		n |= uint32(c&0x7f) << shift
+	}
+
+	if n == endOfRecord {
+		return 0, 0, nil
+	}
+
+	if n++; n >= maxCount {
+		return 0, 0, errInvalidRecord
+	}
+
+	return t, n, nil
+}
+
+// Reader decodes an ECM file back to the original track
+type Reader struct {
+	r   *bufio.Reader
+	t   int
+	n   uint32
+	b   []byte
+	buf []byte
+	edc uint32
+	err error
+}
+
+// NewReader returns a Reader that decodes the ECM file read from r
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		r:   bufio.NewReader(r),
+		buf: make([]byte, sector.Size),
+	}
+
+	b := make([]byte, len(magic))
+	if _, err := io.ReadFull(reader.r, b); err != nil {
+		return nil, unexpected(err)
+	}
+
+	if !bytes.Equal(b, magic) {
+		return nil, errInvalidHeader
+	}
+
+	return reader, nil
+}
+
+func (r *Reader) next() error {
+	if r.n == 0 {
+		var err error
+		if r.t, r.n, err = readRecord(r.r); err != nil {
+			return err
+		}
+
+		// The records are followed by the EDC of the decoded data
+		if r.n == 0 {
+			b := make([]byte, 4)
+			if _, err := io.ReadFull(r.r, b); err != nil {
+				return unexpected(err)
+			}
+			if binary.LittleEndian.Uint32(b) != r.edc {
+				return errChecksum
+			}
+			return io.EOF
+		}
+	}
+
+	s := r.buf
+	switch r.t {
+	case typeLiteral:
+		n := uint32(len(s))
+		if r.n < n {
+			n = r.n
+		}
+		if _, err := io.ReadFull(r.r, s[:n]); err != nil {
+			return unexpected(err)
+		}
+		r.b, r.n = s[:n], r.n-n
+		r.edc = sector.UpdateEDC(r.edc, r.b)
+		return nil
+	case typeMode1:
+		header, _ := sector.Header(sector.Mode1, 0)
+		copy(s, header)
+		if _, err := io.ReadFull(r.r, s[12:15]); err != nil {
+			return unexpected(err)
+		}
+		if _, err := io.ReadFull(r.r, s[16:16+sector.DataSize]); err != nil {
+			return unexpected(err)
+		}
+		if err := sector.Generate(s, modes[r.t]); err != nil {
+			return err
+		}
+		r.b = s
+	default:
+		// The header isn't used when generating Mode 2 sectors and
+		// only the subheader onwards is returned
+		header, _ := sector.Header(sector.Mode2Form1, 0)
+		copy(s, header)
+		if _, err := io.ReadFull(r.r, s[20:20+storedSizes[r.t]]); err != nil {
+			return unexpected(err)
+		}
+		copy(s[16:20], s[20:24])
+		if err := sector.Generate(s, modes[r.t]); err != nil {
+			return err
+		}
+		r.b = s[16:]
+	}
+
+	r.n--
+	r.edc = sector.UpdateEDC(r.edc, r.b)
+
+	return nil
+}
+
+// Read reads the decoded data. The checksum is verified once all of the
+// records are read
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.b) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+
+	return n, nil
+}
+
+// Size returns the size of the decoded data of the ECM file read from r.
+// The records are skipped over without regenerating any sectors or
+// verifying the checksum
+func Size(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+
+	b := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, b); err != nil {
+		return 0, unexpected(err)
+	}
+
+	if !bytes.Equal(b, magic) {
+		return 0, errInvalidHeader
+	}
+
+	var size int64
+	for {
+		t, n, err := readRecord(br)
+		if err != nil {
+			return 0, err
+		}
+
+		if n == 0 {
+			return size, nil
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, br, int64(n)*int64(storedSizes[t])); err != nil {
+			return 0, unexpected(err)
+		}
+		size += int64(n) * int64(decodedSizes[t])
+	}
+}
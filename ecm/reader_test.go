@@ -0,0 +1,108 @@
+package ecm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTrack() []byte {
+	b := new(bytes.Buffer)
+	for i := 0; i < 8; i++ {
+		s, _ := sector.New(sector.Mode1, i, []byte(fmt.Sprintf("MODE1 %d", i)))
+		b.Write(s)
+	}
+	for i := 0; i < 4; i++ {
+		s, _ := sector.New(sector.Mode2Form1, 8+i, []byte(fmt.Sprintf("FORM1 %d", i)))
+		b.Write(s)
+		s, _ = sector.New(sector.Mode2Form2, 12+i, []byte(fmt.Sprintf("FORM2 %d", i)))
+		b.Write(s)
+	}
+	// A damaged sector and some audio
+	s, _ := sector.New(sector.Mode1, 16, nil)
+	s[sector.Size-1] ^= 0xff
+	b.Write(s)
+	b.Write(bytes.Repeat([]byte{0x12, 0x34}, sector.Size))
+	// A partial sector
+	b.WriteString("SEGA SEGAKATANA ")
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	// Three Mode 1 sectors, the subheader and data of a Mode 2 Form 1
+	// sector and three literal bytes
+	data := make([]byte, 0, 4*sector.Size)
+	for i := 0; i < 3; i++ {
+		s, _ := sector.New(sector.Mode1, i, []byte{byte(i)})
+		data = append(data, s...)
+	}
+	s, _ := sector.New(sector.Mode2Form1, 3, []byte{3})
+	data = append(data, s[16:]...)
+	data = append(data, 1, 2, 3)
+
+	b := append([]byte{}, magic...)
+	b = append(b, 2<<2|typeMode1)
+	for i := 0; i < 3; i++ {
+		b = append(b, data[i*sector.Size+12:i*sector.Size+15]...)
+		b = append(b, data[i*sector.Size+16:i*sector.Size+16+sector.DataSize]...)
+	}
+	b = append(b, typeMode2Form1)
+	b = append(b, s[20:24]...)
+	b = append(b, s[24:24+sector.DataSize]...)
+	b = append(b, 2<<2|typeLiteral, 1, 2, 3)
+	b = append(b, 0xfc, 0xff, 0xff, 0xff, 0x3f)
+	edc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(edc, sector.EDC(data))
+	b = append(b, edc...)
+
+	r, err := NewReader(bytes.NewReader(b))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	got, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	size, err := Size(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), size)
+}
+
+func TestReaderErrors(t *testing.T) {
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+	_, _ = w.Write(testTrack())
+	_ = w.Close()
+
+	badChecksum := append([]byte{}, b.Bytes()...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+
+	badRecord := append(append([]byte{}, magic...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+
+	tables := []struct {
+		got []byte
+		err error
+	}{
+		{magic[:2], io.ErrUnexpectedEOF},
+		{[]byte("ECM\x01"), errInvalidHeader},
+		{b.Bytes()[:b.Len()-5], io.ErrUnexpectedEOF},
+		{badChecksum, errChecksum},
+		{badRecord, errInvalidRecord},
+	}
+
+	for _, table := range tables {
+		r, err := NewReader(bytes.NewReader(table.got))
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		assert.True(t, errors.Is(err, table.err), err)
+	}
+}
@@ -0,0 +1,183 @@
+package ecm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/bodgit/dreamcast/sector"
+)
+
+// Records are written once this many bytes are pending
+const maxPending = 1 << 16
+
+// Writer encodes a raw track as an ECM file. Data is expected to be
+// a sequence of raw 2352 byte sectors, Mode 1 sectors are stored without
+// the sync pattern, EDC and ECC, Mode 2 sectors are also stored without
+// the header. Any sector that can't be regenerated exactly, such as audio,
+// is stored as is
+type Writer struct {
+	w       io.Writer
+	started bool
+	closed  bool
+	buf     []byte
+	s       []byte
+	t       int
+	n       uint32
+	pending bytes.Buffer
+	edc     uint32
+	err     error
+}
+
+// NewWriter returns a Writer that writes an ECM file to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		buf: make([]byte, 0, sector.Size),
+		s:   make([]byte, sector.Size),
+	}
+}
+
+func writeRecord(w io.Writer, t int, n uint32) error {
+	b := []byte{byte(n&0x1f)<<2 | byte(t)}
+	for n >>= 5; n != 0; n >>= 7 {
+		b[len(b)-1] |= 0x80
+		b = append(b, byte(n&0x7f))
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func (w *Writer) flush() error {
+	if !w.started {
+		if _, err := w.w.Write(magic); err != nil {
+			return err
+		}
+		w.started = true
+	}
+
+	if w.n == 0 {
+		return nil
+	}
+
+	if err := writeRecord(w.w, w.t, w.n-1); err != nil {
+		return err
+	}
+
+	if _, err := w.pending.WriteTo(w.w); err != nil {
+		return err
+	}
+	w.n = 0
+
+	return nil
+}
+
+func (w *Writer) add(t int, b []byte) error {
+	if t != w.t || w.pending.Len()+len(b) > maxPending {
+		if err := w.flush(); err != nil {
+			return err
+		}
+		w.t = t
+	}
+
+	w.pending.Write(b)
+	if t == typeLiteral {
+		w.n += uint32(len(b))
+	} else {
+		w.n++
+	}
+
+	return nil
+}
+
+// generates returns true if the sector is identical to one generated from
+// the stored data as the passed mode
+func (w *Writer) generates(b []byte, mode sector.Mode) bool {
+	copy(w.s, b)
+	if err := sector.Generate(w.s, mode); err != nil {
+		return false
+	}
+	return bytes.Equal(w.s, b)
+}
+
+func (w *Writer) encode(b []byte) error {
+	switch sector.ModeOf(b) {
+	case sector.Mode1:
+		if w.generates(b, modes[typeMode1]) {
+			return w.add(typeMode1, append(b[12:15:15], b[16:16+sector.DataSize]...))
+		}
+	case sector.Mode2Form1, sector.Mode2Form2:
+		// Both copies of the subheader must match as only one is
+		// stored, either form is tried regardless of the subheader
+		if !bytes.Equal(b[16:20], b[20:24]) {
+			break
+		}
+		for _, t := range []int{typeMode2Form1, typeMode2Form2} {
+			if w.generates(b, modes[t]) {
+				if err := w.add(typeLiteral, b[:16]); err != nil {
+					return err
+				}
+				return w.add(t, b[20:20+storedSizes[t]])
+			}
+		}
+	}
+
+	return w.add(typeLiteral, b)
+}
+
+// Write encodes the sectors written
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.edc = sector.UpdateEDC(w.edc, p)
+
+	n := 0
+	for len(p) > 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+
+		if len(w.buf) < cap(w.buf) {
+			break
+		}
+
+		if w.err = w.encode(w.buf); w.err != nil {
+			return n, w.err
+		}
+		w.buf = w.buf[:0]
+	}
+
+	return n, nil
+}
+
+// Close stores any partial sector and writes the end of the records and
+// checksum. It does not close the underlying io.Writer
+func (w *Writer) Close() error {
+	if w.closed || w.err != nil {
+		return w.err
+	}
+	w.closed = true
+
+	if len(w.buf) > 0 {
+		if w.err = w.add(typeLiteral, w.buf); w.err != nil {
+			return w.err
+		}
+	}
+
+	if w.err = w.flush(); w.err != nil {
+		return w.err
+	}
+
+	if w.err = writeRecord(w.w, typeLiteral, endOfRecord); w.err != nil {
+		return w.err
+	}
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, w.edc)
+	_, w.err = w.w.Write(b)
+
+	return w.err
+}
@@ -0,0 +1,50 @@
+package ecm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	track := testTrack()
+
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+
+	// Write in odd sized chunks
+	for r := bytes.NewReader(track); r.Len() > 0; {
+		if _, err := io.CopyN(w, r, 1000); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	assert.Nil(t, w.Close())
+	assert.Nil(t, w.Close())
+
+	// Only the data sectors are reduced in size
+	assert.Equal(t, true, b.Len() < len(track)-16*(sector.Size-0x918))
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	got, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, track, got)
+
+	size, err := Size(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(track)), size)
+}
+
+func TestWriterEmpty(t *testing.T) {
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, append(append([]byte{}, magic...), 0xfc, 0xff, 0xff, 0xff, 0x3f, 0, 0, 0, 0), b.Bytes())
+}
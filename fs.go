@@ -0,0 +1,167 @@
+package dreamcast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/bodgit/dreamcast/iso9660"
+)
+
+var errNoLowDensityArea = errors.New("no low density area")
+
+// FS is an ISO 9660 filesystem read from the data tracks of a game. It
+// implements the fs.FS, fs.ReadDirFS and fs.StatFS interfaces.
+type FS struct {
+	*iso9660.FS
+	r *SectorReader
+}
+
+// Close closes any track left open by reading the filesystem
+func (f *FS) Close() error {
+	return f.r.Close()
+}
+
+func (g Game) newFS(start int) (*FS, error) {
+	r, err := g.NewSectorReader(false)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys, err := iso9660.New(r, int64(start))
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return &FS{fsys, r}, nil
+}
+
+// FS returns the filesystem of the game. For a GD-ROM this is the
+// filesystem in the high density area, which starts at sector 45000 and
+// spans the third track and the last data track. For a CD it's the
+// filesystem in the data track of the last session. The sectors are mapped
+// to the 2048 bytes of user data so the returned FS can be used with
+// fs.WalkDir, fs.ReadFile, etc. Use Close once finished
+func (g Game) FS() (*FS, error) {
+	track, err := g.ipBinTrack()
+	if err != nil {
+		return nil, err
+	}
+	return g.newFS(g.gdiFile.Tracks[track].Start)
+}
+
+// LowDensityFS returns the small filesystem in the first track of a
+// GD-ROM, usually holding little more than a warning that the disc won't
+// play in a CD player. Use Close once finished
+func (g Game) LowDensityFS() (*FS, error) {
+	if g.Layout() != LayoutGDROM {
+		return nil, errNoLowDensityArea
+	}
+	return g.newFS(g.gdiFile.Tracks[0].Start)
+}
+
+// createFile creates the named file, with the modification time if the
+// writer supports it
+func createFile(writer Writer, name string, modTime time.Time) (io.WriteCloser, error) {
+	if w, ok := writer.(ModTimeWriter); ok {
+		return w.CreateFileModTime(name, modTime)
+	}
+	return writer.CreateFile(name)
+}
+
+// Extract writes every file in the filesystem returned by FS to the
+// writer, keeping the directory structure. The modification times are
+// kept if the writer implements ModTimeWriter. If the writer implements
+// DirWriter then every directory is created, even if empty, and given its
+// modification time once everything in it has been written. If the
+// ManifestFile of the writer config is set then a manifest is also
+// written, listing the LBA, size and path of each file, one per line
+func (g Game) Extract(writer Writer) error {
+	fsys, err := g.FS()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	dw, isDirWriter := writer.(DirWriter)
+
+	type dir struct {
+		name    string
+		modTime time.Time
+	}
+	var dirs []dir
+
+	manifest := new(bytes.Buffer)
+	if err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if !isDirWriter {
+				return nil
+			}
+			dirs = append(dirs, dir{name, info.ModTime()})
+			return dw.CreateDir(name)
+		}
+
+		lba, size, err := fsys.Extent(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(manifest, "%d %d %s\n", lba, size, name)
+
+		src, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dst, err := createFile(writer, name, info.ModTime())
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+
+		return dst.Close()
+	}); err != nil {
+		return err
+	}
+
+	// Writing to a directory changes its modification time so set them
+	// last, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := dw.SetDirModTime(dirs[i].name, dirs[i].modTime); err != nil {
+			return err
+		}
+	}
+
+	if writer.Config().ManifestFile == "" {
+		return nil
+	}
+
+	w, err := writer.CreateFile(writer.Config().ManifestFile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := manifest.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
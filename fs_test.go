@@ -0,0 +1,185 @@
+package dreamcast
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/iso9660"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFSGame writes a game with the IP.BIN, or the default IP.BIN if nil,
+// and the volume in the third track. The files, which must already be
+// added to the volume, are placed one after the other in name order
+// following the volume. It returns the LBA of the first file
+func writeFSGame(t *testing.T, dir string, ip *IPBin, v *iso9660.Volume, files map[string][]byte) int64 {
+	if ip == nil {
+		var err error
+		if ip, err = NewIPBin(make([]byte, ipBinLength)); !assert.Nil(t, err) {
+			t.FailNow()
+		}
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	blocks := v.Blocks()
+	size := blocks
+	for _, name := range names {
+		assert.Nil(t, v.SetExtent(name, gdi.TrackThreeStart+size))
+		size += (int64(len(files[name])) + iso9660.BlockSize - 1) / iso9660.BlockSize
+	}
+
+	head, err := v.MarshalBinary(size)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	copy(head, b)
+
+	for _, name := range names {
+		data := make([]byte, (len(files[name])+iso9660.BlockSize-1)/iso9660.BlockSize*iso9660.BlockSize)
+		copy(data, files[name])
+		head = append(head, data...)
+	}
+
+	writeGame(t, dir, head)
+
+	return gdi.TrackThreeStart + blocks
+}
+
+func TestExtract(t *testing.T) {
+	fileTime := time.Date(1999, time.September, 9, 12, 0, 0, 0, time.UTC)
+	dirTime := time.Date(1998, time.November, 27, 12, 0, 0, 0, time.UTC)
+
+	data := make([]byte, iso9660.BlockSize+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// Author a volume with a file in a directory and an empty directory
+	v := iso9660.NewVolume(gdi.TrackThreeStart)
+	assert.Nil(t, v.AddFile("1st_read.bin", 5, fileTime))
+	assert.Nil(t, v.AddDir("dir", dirTime))
+	assert.Nil(t, v.AddFile("dir/data.txt", int64(len(data)), fileTime))
+	assert.Nil(t, v.AddDir("empty", dirTime))
+
+	dir := t.TempDir()
+	lba := writeFSGame(t, dir, nil, v, map[string][]byte{
+		"1st_read.bin": []byte("HELLO"),
+		"dir/data.txt": data,
+	})
+
+	reader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	out := t.TempDir()
+	writer, err := NewDirectoryWriter(out, WriterConfig{ManifestFile: "manifest.txt"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Extract(writer))
+
+	for name, expected := range map[string][]byte{
+		"1ST_READ.BIN": []byte("HELLO"),
+		"DIR/DATA.TXT": data,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+
+		fi, err := os.Stat(filepath.Join(out, filepath.FromSlash(name)))
+		if assert.Nil(t, err) {
+			assert.True(t, fileTime.Equal(fi.ModTime()), name)
+		}
+	}
+
+	// Directories are created even if empty and keep their modification
+	// time after being written to
+	for _, name := range []string{"DIR", "EMPTY"} {
+		fi, err := os.Stat(filepath.Join(out, name))
+		if assert.Nil(t, err) {
+			assert.True(t, fi.IsDir())
+			assert.True(t, dirTime.Equal(fi.ModTime()), name)
+		}
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(out, "manifest.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%d 5 1ST_READ.BIN\n%d %d DIR/DATA.TXT\n", lba, lba+1, len(data)), string(manifest))
+
+	// The same again but into a zip file
+	zipFile := filepath.Join(t.TempDir(), "game.zip")
+	zw, err := NewZipFileWriter(zipFile, WriterConfig{ManifestFile: "manifest.txt"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Extract(zw))
+	if !assert.Nil(t, zw.Close()) {
+		return
+	}
+
+	zr, err := zip.OpenReader(zipFile)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer zr.Close()
+
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	for name, expected := range map[string][]byte{
+		"1ST_READ.BIN": []byte("HELLO"),
+		"DIR/DATA.TXT": data,
+		"manifest.txt": manifest,
+	} {
+		f, ok := entries[name]
+		if !assert.True(t, ok, name) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if !assert.Nil(t, err) {
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+
+		if name != "manifest.txt" {
+			assert.True(t, fileTime.Equal(f.Modified), name)
+		}
+	}
+
+	for _, name := range []string{"DIR/", "EMPTY/"} {
+		f, ok := entries[name]
+		if assert.True(t, ok, name) {
+			assert.True(t, f.FileInfo().IsDir())
+			assert.True(t, dirTime.Equal(f.Modified), name)
+		}
+	}
+}
@@ -7,21 +7,26 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/bodgit/dreamcast/cue"
 	"github.com/bodgit/dreamcast/gdi"
-	"github.com/vchimishuk/chub/cue"
+	"github.com/bodgit/dreamcast/sector"
 )
 
 const (
 	pauseData = 150
 	preGap    = 75
+
+	// Sectors between the end of one session and the start of the next
+	firstLeadOut = 6750
+	leadOut      = 2250
+	leadIn       = 4500
 )
 
 var (
-	errInvalidType             = errors.New("invalid track type")
-	errInvalidSize             = errors.New("invalid track size")
-	errInvalidCueFile          = errors.New("invalid cue file")
-	errInvalidGame             = errors.New("invalid game")
-	errInconsistentAudioTracks = errors.New("inconsistent audio tracks")
+	errInvalidType        = errors.New("invalid track type")
+	errInvalidSize        = errors.New("invalid track size")
+	errInvalidGame        = errors.New("invalid game")
+	errInconsistentLayout = errors.New("inconsistent track layout")
 )
 
 // Game represents a Sega Dreamcast game image
@@ -32,14 +37,68 @@ type Game struct {
 	CueFile string
 	// IPBin represents the IP.BIN initial program found in the third track
 	IPBin *IPBin
+	// IPBinErrors lists any IP.BIN fields that couldn't be decoded, or
+	// are likely to be rejected, when read with NewLenientGame
+	IPBinErrors FieldErrors
 
 	reader  Reader
 	gdiFile *gdi.File
+	files   []trackFile
+	sectors []byte // The raw sectors holding IP.BIN
+}
+
+var cueTypeToGDIType = map[cue.Type]gdi.Type{
+	cue.TypeAudio: gdi.TypeAudio,
+	cue.TypeMode1: gdi.TypeData,
+	cue.TypeMode2: gdi.TypeData,
+}
+
+var gdiTypeToCueType = map[gdi.Type]cue.Type{
+	gdi.TypeAudio: cue.TypeAudio,
+	gdi.TypeData:  cue.TypeMode1,
 }
 
-var cueTrackTypeToGDIType = map[cue.TrackDataType]gdi.Type{
-	cue.DataTypeAudio:      gdi.TypeAudio,
-	cue.DataTypeMode1_2352: gdi.TypeData,
+// trackFile locates the data for a track. Usually each track is stored in
+// its own file, however a cue sheet can describe several tracks stored
+// within the same file
+type trackFile struct {
+	name    string
+	offset  int64
+	size    int64 // -1 means the remainder of the file
+	session int   // 0 means a single session GD-ROM
+	mode2   bool
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (g Game) openTrack(i int) (io.ReadCloser, error) {
+	tf := g.files[i]
+
+	file, err := g.reader.OpenFile(tf.name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, file, tf.offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if tf.size < 0 {
+		return file, nil
+	}
+
+	return readCloser{io.LimitReader(file, tf.size), file}, nil
+}
+
+func (g Game) trackSize(i int) (uint64, error) {
+	if g.files[i].size < 0 {
+		return g.reader.FileSize(g.files[i].name)
+	}
+	return uint64(g.files[i].size), nil
 }
 
 func (g *Game) newFromCueFile() error {
@@ -47,62 +106,182 @@ func (g *Game) newFromCueFile() error {
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 	g.CueFile = filename
 
-	sheet, err := cue.Parse(r)
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
-	start := 0
+	sheet := new(cue.Sheet)
+	if err := sheet.UnmarshalText(b); err != nil {
+		return err
+	}
+
+	inferSessions(sheet)
+
+	return g.layoutCueSheet(sheet)
+}
+
+// inferSessions places the tracks of a cue sheet without any session or
+// area markers, that can't describe a GD-ROM, into sessions like a
+// selfboot or MIL-CD disc. The last data track and anything after it is
+// placed in the second session, any tracks before it in the first
+func inferSessions(sheet *cue.Sheet) {
+	data, first, n := -1, cue.TypeAudio, 0
+	for _, file := range sheet.Files {
+		for _, t := range file.Tracks {
+			if t.Area != cue.AreaUnknown || t.Session != 0 {
+				return
+			}
+			if n == 0 {
+				first = t.Type
+			}
+			if t.Type != cue.TypeAudio {
+				data = n
+			}
+			n++
+		}
+	}
+
+	// A GD-ROM has at least three tracks, starting with a data track
+	if data < 0 || (n >= 3 && first != cue.TypeAudio) {
+		return
+	}
+
+	n = 0
+	for i := range sheet.Files {
+		for j := range sheet.Files[i].Tracks {
+			sheet.Files[i].Tracks[j].Session = 1
+			if n >= data && data > 0 {
+				sheet.Files[i].Tracks[j].Session = 2
+			}
+			n++
+		}
+	}
+}
+
+func (g *Game) layoutCueSheet(sheet *cue.Sheet) error {
+	// A cue sheet with session markers describes a CD layout, such as a
+	// selfboot or MIL-CD disc, rather than a GD-ROM
+	multiSession := false
 	for _, file := range sheet.Files {
 		for _, t := range file.Tracks {
-			trackType, ok := cueTrackTypeToGDIType[t.DataType]
+			multiSession = multiSession || t.Session != 0
+		}
+	}
+
+	// start is the sector on the disc that corresponds to the beginning
+	// of the current file
+	start, area, session := 0, cue.AreaSingleDensity, 0
+	for _, file := range sheet.Files {
+		size, err := g.reader.FileSize(file.Name)
+		if err != nil {
+			return err
+		}
+
+		if size%gdi.SectorSize != 0 {
+			return errInvalidSize
+		}
+		sectors := int(size / gdi.SectorSize)
+
+		for i, t := range file.Tracks {
+			trackType, ok := cueTypeToGDIType[t.Type]
 			if !ok {
 				return errInvalidType
 			}
 
+			// A track starts with either INDEX 00 or INDEX 01
+			offset, end := t.Indexes[0].Offset, sectors
+			if i+1 < len(file.Tracks) {
+				end = file.Tracks[i+1].Indexes[0].Offset
+			}
+			if offset > end {
+				return errInvalidSize
+			}
+
+			switch {
+			case multiSession:
+				// Skip over the lead-out of the previous
+				// session and the lead-in of this one
+				if session != 0 && t.Session > session {
+					start += sessionGap(session)
+				}
+				session = t.Session
+			default:
+				// Without any area markers assume the high
+				// density area begins with the third track as
+				// usual
+				next := t.Area
+				if next == cue.AreaUnknown {
+					next = cue.AreaSingleDensity
+					if t.Number >= 3 {
+						next = cue.AreaHighDensity
+					}
+				}
+				if next == cue.AreaHighDensity && area != cue.AreaHighDensity {
+					start = gdi.TrackThreeStart - offset
+				}
+				area = next
+			}
+
+			// The pregap isn't stored in the file so shifts this
+			// and any subsequent tracks in the file along
+			start += t.Pregap
+
 			track := gdi.Track{
 				Number:     t.Number,
-				Start:      start,
+				Start:      start + offset,
 				Type:       trackType,
 				SectorSize: gdi.SectorSize,
 				Name:       file.Name,
 				Zero:       0,
 			}
 
-			switch t.Number {
-			case 2:
-				start = gdi.TrackThreeStart
-			default:
-				size, err := g.reader.FileSize(file.Name)
-				if err != nil {
-					return err
-				}
-
-				if size%gdi.SectorSize != 0 {
-					return errInvalidSize
-				}
-
-				start += int(size / uint64(gdi.SectorSize))
+			// Give each track its own name if they share a file
+			if len(file.Tracks) > 1 {
+				track.Name = GDemuTrackName(track)
 			}
 
 			g.gdiFile.Tracks = append(g.gdiFile.Tracks, track)
+			g.files = append(g.files, trackFile{
+				name:    file.Name,
+				offset:  int64(offset) * gdi.SectorSize,
+				size:    int64(end-offset) * gdi.SectorSize,
+				session: t.Session,
+				mode2:   t.Type == cue.TypeMode2,
+			})
 		}
+
+		start += sectors
 	}
 	g.gdiFile.Count = len(g.gdiFile.Tracks)
 
-	// This checks the tracks are all of the correct type
-	if !g.gdiFile.IsValid() {
-		return errInvalidCueFile
+	if multiSession {
+		return nil
 	}
 
-	return nil
+	// This checks the tracks are all of the correct type
+	return g.gdiFile.Validate()
 }
 
 // NewGame returns a Game object read using the passed Reader. A GDI file is
-// searched for first, followed by a cue sheet.
+// searched for first, followed by a cue sheet. A cue sheet can also
+// describe a CD, such as a selfboot or MIL-CD disc, in which case IP.BIN is
+// read from the data track in the last session.
 func NewGame(reader Reader) (*Game, error) {
+	return newGame(reader, false)
+}
+
+// NewLenientGame is like NewGame however an IP.BIN with fields that can't
+// be decoded doesn't cause an error. Such fields are left as the zero value
+// and listed in IPBinErrors along with any warnings
+func NewLenientGame(reader Reader) (*Game, error) {
+	return newGame(reader, true)
+}
+
+func newGame(reader Reader, lenient bool) (*Game, error) {
 	game := &Game{
 		reader:  reader,
 		gdiFile: new(gdi.File),
@@ -128,58 +307,257 @@ func NewGame(reader Reader) (*Game, error) {
 		if err := game.gdiFile.UnmarshalText(b); err != nil {
 			return nil, err
 		}
+
+		for _, track := range game.gdiFile.Tracks {
+			game.files = append(game.files, trackFile{
+				name: track.Name,
+				size: -1,
+			})
+		}
 	}
 
-	if err := game.readIPBin(); err != nil {
+	if err := game.readIPBin(lenient); err != nil {
 		return nil, err
 	}
 
 	return game, nil
 }
 
-func (g *Game) readIPBin() error {
-	file, err := g.reader.OpenFile(g.gdiFile.Tracks[2].Name)
-	if err != nil {
-		return err
+// ipBinTrack returns the index of the track containing IP.BIN. This is the
+// third track of a GD-ROM, otherwise it's the first data track of the last
+// session
+func (g Game) ipBinTrack() (int, error) {
+	if g.gdiFile.IsValid() {
+		return 2, nil
 	}
-	defer file.Close()
 
+	track, session := -1, 0
+	for i, t := range g.gdiFile.Tracks {
+		if t.IsDataTrack() && g.files[i].session > session {
+			track, session = i, g.files[i].session
+		}
+	}
+
+	if track < 0 {
+		return 0, errInvalidGame
+	}
+
+	return track, nil
+}
+
+// ipBinSectors is the number of sectors holding IP.BIN
+const ipBinSectors = ipBinLength / sector.DataSize
+
+// userData returns the offset of the 2048 bytes of user data in the raw
+// sector, skipping over the sync data and header, plus the subheader of a
+// Mode 2 sector
+func userData(b []byte) int {
+	if sector.ModeOf(b) == sector.Mode2Form1 {
+		return 24
+	}
+	return 16
+}
+
+// ipBinData returns the user data of the raw sectors holding IP.BIN
+func (g Game) ipBinData() []byte {
 	buf := new(bytes.Buffer)
 	buf.Grow(ipBinLength) // Size the buffer to 32 KiB
 
 	// Loop over the first 16 sectors
-	for i := 0; i < 16; i++ {
-		// Skip over the sync data
-		if _, err := io.CopyN(ioutil.Discard, file, 16); err != nil {
-			return err
+	for i := 0; i < ipBinSectors; i++ {
+		b := g.sectors[i*gdi.SectorSize : (i+1)*gdi.SectorSize]
+		offset := userData(b)
+		buf.Write(b[offset : offset+sector.DataSize])
+	}
+
+	return buf.Bytes()
+}
+
+func (g *Game) readIPBin(lenient bool) error {
+	track, err := g.ipBinTrack()
+	if err != nil {
+		return err
+	}
+
+	file, err := g.openTrack(track)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	g.sectors = make([]byte, ipBinSectors*gdi.SectorSize)
+	if _, err := io.ReadFull(file, g.sectors); err != nil {
+		return err
+	}
+
+	g.IPBin = new(IPBin)
+	if lenient {
+		g.IPBinErrors, err = g.IPBin.UnmarshalLenient(g.ipBinData())
+		return err
+	}
+
+	if err := g.IPBin.UnmarshalBinary(g.ipBinData()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// patchFields are the parts of the IP.BIN changed by SetRegionFree and
+// SetVGA, everything else is kept exactly as read
+var patchFields = []struct {
+	offset, end int
+}{
+	{offsetAreaSymbols, offsetPeripherals},
+	{offsetPeripherals, offsetProductNumber},
+	{offsetRegionStrings, offsetRegionStrings + numRegions*regionStringSize},
+}
+
+// patchIPBin returns the raw sectors holding IP.BIN with any changes made
+// to the regions, region strings or peripherals written back, or nil if
+// there are no changes
+func (g Game) patchIPBin() ([]byte, error) {
+	ip, err := g.IPBin.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare against the original IP.BIN having been through the same
+	// round trip so any differences in formatting aren't seen as changes
+	original := new(IPBin)
+	if _, err := original.UnmarshalLenient(g.ipBinData()); err != nil {
+		return nil, err
+	}
+
+	b, err := original.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the patched fields are copied, any field that couldn't be
+	// decoded would otherwise be overwritten with its zero value
+	data := g.ipBinData()
+	changed := false
+	for _, f := range patchFields {
+		if !bytes.Equal(b[f.offset:f.end], ip[f.offset:f.end]) {
+			copy(data[f.offset:f.end], ip[f.offset:f.end])
+			changed = true
 		}
+	}
 
-		// Read 2048 bytes
-		if _, err := io.CopyN(buf, file, 2048); err != nil {
-			return err
+	if !changed {
+		return nil, nil
+	}
+
+	b = make([]byte, len(g.sectors))
+	copy(b, g.sectors)
+
+	for i := 0; i < ipBinSectors; i++ {
+		s := b[i*gdi.SectorSize : (i+1)*gdi.SectorSize]
+		offset := userData(s)
+		d := data[i*sector.DataSize : (i+1)*sector.DataSize]
+		if bytes.Equal(s[offset:offset+sector.DataSize], d) {
+			continue
 		}
 
-		// Skip the rest of the sector
-		if _, err := io.CopyN(ioutil.Discard, file, gdi.SectorSize-2064); err != nil {
-			return err
+		copy(s[offset:], d)
+		if err := sector.Regenerate(s); err != nil {
+			return nil, err
 		}
 	}
 
-	g.IPBin = new(IPBin)
-	if err := g.IPBin.UnmarshalBinary(buf.Bytes()); err != nil {
+	return b, nil
+}
+
+// SetRegionFree permits the game in every region, updating both the area
+// symbols and region strings of the IP.BIN
+func (g *Game) SetRegionFree() {
+	g.IPBin.SetRegions(RegionJapan, RegionUSA, RegionEurope)
+}
+
+// SetVGA marks the game as supporting the VGA box
+func (g *Game) SetVGA() {
+	g.IPBin.Peripherals |= uint32(PeripheralVGABox)
+}
+
+// IPBinFile returns the name of, and offset within, the file holding the
+// raw sectors of IP.BIN
+func (g Game) IPBinFile() (string, int64, error) {
+	track, err := g.ipBinTrack()
+	if err != nil {
+		return "", 0, err
+	}
+
+	return g.files[track].name, g.files[track].offset, nil
+}
+
+// PatchIPBin writes any changes to the regions, region strings or
+// peripherals of the IP.BIN back to its raw sectors, recomputing the EDC
+// and ECC. w should be the file returned by IPBinFile
+func (g Game) PatchIPBin(w io.WriterAt) error {
+	b, err := g.patchIPBin()
+	if err != nil || b == nil {
 		return err
 	}
 
-	return nil
+	_, offset, err := g.IPBinFile()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.WriteAt(b, offset)
+	return err
+}
+
+// isMultiSession returns true if the tracks are laid out in sessions like
+// a CD rather than a GD-ROM
+func (g Game) isMultiSession() bool {
+	return len(g.files) > 0 && g.files[0].session != 0
+}
+
+// Layout describes how the tracks of a game are laid out on the disc
+type Layout int
+
+const (
+	// LayoutGDROM is a GD-ROM, with a single density area followed by a
+	// high density area starting with the third track
+	LayoutGDROM Layout = iota
+	// LayoutCD is a CD, such as a CD-R selfboot or a MIL-CD disc, with
+	// the tracks in sessions. IP.BIN is at the start of the data track in
+	// the last session
+	LayoutCD
+)
+
+var layoutNames = map[Layout]string{
+	LayoutGDROM: "GD-ROM",
+	LayoutCD:    "CD",
+}
+
+func (l Layout) String() string {
+	return layoutNames[l]
+}
+
+// Hardware returns the hardware the game is for, either the Dreamcast or
+// one of the NAOMI arcade boards
+func (g Game) Hardware() Hardware {
+	return g.IPBin.Hardware()
+}
+
+// Layout returns the layout of the tracks
+func (g Game) Layout() Layout {
+	if g.isMultiSession() {
+		return LayoutCD
+	}
+	return LayoutGDROM
 }
 
 func (g Game) isValid() error {
-	if !g.gdiFile.IsValid() {
+	if !g.isMultiSession() && !g.gdiFile.IsValid() {
 		return errInvalidGame
 	}
 
-	for _, track := range g.gdiFile.Tracks {
-		size, err := g.reader.FileSize(track.Name)
+	for i := range g.gdiFile.Tracks {
+		size, err := g.trackSize(i)
 		if err != nil {
 			return err
 		}
@@ -192,42 +570,47 @@ func (g Game) isValid() error {
 	return nil
 }
 
+// isRedump returns true if a GD-ROM is in the Redump layout. This is
+// found from where each track starts relative to the end of the previous
+// file rather than the contents of the audio, so a game without any audio
+// tracks in the high density area is detected correctly. In the Redump
+// layout the pause is stored at the start of each track so it follows
+// straight on, in the TOSEC layout there's a gap instead
 func (g Game) isRedump() (bool, error) {
 	if err := g.isValid(); err != nil {
 		return false, err
 	}
 
-	audioTracks, redumpTracks := 0, 0
-	for _, track := range g.gdiFile.Tracks {
-		if !track.IsAudioTrack() {
+	// The Redump and TOSEC layouts only differ for GD-ROM images
+	if g.isMultiSession() {
+		return false, nil
+	}
+
+	redumpTracks, tosecTracks := 0, 0
+	for i, track := range g.gdiFile.Tracks {
+		// The first track of each area has no pause
+		if i == 0 || i == 2 {
 			continue
 		}
 
-		audioTracks++
-
-		file, err := g.reader.OpenFile(track.Name)
+		size, err := g.trackSize(i - 1)
 		if err != nil {
 			return false, err
 		}
-		defer file.Close()
 
-		buf := new(bytes.Buffer)
-		if _, err := io.CopyN(buf, file, 16); err != nil {
-			return false, err
-		}
-
-		if bytes.Compare(buf.Bytes(), bytes.Repeat([]byte{0}, 16)) == 0 {
+		switch track.Start - g.gdiFile.Tracks[i-1].Start - int(size/gdi.SectorSize) {
+		case 0:
 			redumpTracks++
+		case pauseData:
+			tosecTracks++
 		}
-
-		file.Close()
 	}
 
-	if redumpTracks > 0 && redumpTracks < audioTracks {
-		return false, errInconsistentAudioTracks
+	if redumpTracks > 0 && tosecTracks > 0 {
+		return false, errInconsistentLayout
 	}
 
-	return redumpTracks == audioTracks, nil
+	return redumpTracks > 0, nil
 }
 
 func writeGDIFile(writer Writer, gdiFile *gdi.File) error {
@@ -253,33 +636,172 @@ func writeGDIFile(writer Writer, gdiFile *gdi.File) error {
 	return nil
 }
 
-func writeCueFile(writer Writer, gdiFile *gdi.File) error {
-	// TODO
+func sessionGap(session int) int {
+	if session == 1 {
+		return firstLeadOut + leadIn
+	}
+	return leadOut + leadIn
+}
+
+// writeCueFile writes a cue sheet for the tracks of gdiFile. The files
+// give the session and mode of each track, lengths is the number of
+// sectors written to each file and pauses is the number of those sectors
+// before the track starts
+func writeCueFile(writer Writer, gdiFile *gdi.File, files []trackFile, lengths, pauses []int) error {
+	sheet := new(cue.Sheet)
+	if writer.Config().TrimWhitespace {
+		sheet.Flags = cue.TrimWhitespace
+	}
+
+	for i, track := range gdiFile.Tracks {
+		t := cue.Track{
+			Number: track.Number,
+			Type:   gdiTypeToCueType[track.Type],
+			Area:   cue.AreaSingleDensity,
+			Indexes: []cue.Index{
+				{
+					Number: 1,
+					Offset: 0,
+				},
+			},
+		}
+
+		if files[i].mode2 {
+			t.Type = cue.TypeMode2
+		}
+
+		session := files[i].session
+		switch {
+		case session != 0:
+			t.Area, t.Session = cue.AreaUnknown, session
+		case i >= 2:
+			t.Area = cue.AreaHighDensity
+		}
+
+		// Any pause stored at the start of the file sits between INDEX
+		// 00 and INDEX 01
+		if pauses[i] > 0 {
+			t.Indexes = []cue.Index{
+				{
+					Number: 0,
+					Offset: 0,
+				},
+				{
+					Number: 1,
+					Offset: pauses[i],
+				},
+			}
+		}
+
+		// Any gap between the end of the previous track in the same
+		// area or session and the start of this one isn't stored in
+		// either file
+		if i > 0 && (i != 2 || session != 0) {
+			prev := gdiFile.Tracks[i-1]
+			gap := track.Start - prev.Start - lengths[i-1]
+			if prevSession := files[i-1].session; session > prevSession {
+				gap -= sessionGap(prevSession)
+			}
+			if gap > 0 {
+				t.Pregap = gap
+			}
+		}
+
+		sheet.Files = append(sheet.Files, cue.File{
+			Name:   track.Name,
+			Tracks: []cue.Track{t},
+		})
+	}
+
+	b, err := sheet.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	file, err := writer.CreateFile(writer.Config().CueFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(b); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func writeSectors(w io.Writer, mode sector.Mode, lba, count int) error {
+	for i := 0; i < count; i++ {
+		b := make([]byte, gdi.SectorSize)
+		if mode != sector.ModeUnknown {
+			var err error
+			if b, err = sector.New(mode, lba+i, nil); err != nil {
+				return err
+			}
+		}
+
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (g Game) isLastDataTrack(track gdi.Track) bool {
+	return !g.isMultiSession() && track.IsDataTrack() && track.Number == g.gdiFile.Count && track.Number > 3
+}
+
+// Write writes the game using the passed Writer. The tracks are converted
+// to either the TOSEC or Redump layout as per the WriterConfig. Any changes
+// made to the regions, region strings or peripherals of the IP.BIN are
+// written back into the track it was read from.
 func (g Game) Write(writer Writer) error {
 	isRedump, err := g.isRedump()
 	if err != nil {
 		return err
 	}
 
+	ipBinTrack, err := g.ipBinTrack()
+	if err != nil {
+		return err
+	}
+
+	patch, err := g.patchIPBin()
+	if err != nil {
+		return err
+	}
+
+	toRedump := writer.Config().Redump && !g.isMultiSession()
+
 	gdiFile := g.gdiFile.Copy()
+	lengths := make([]int, len(gdiFile.Tracks))
+	pauses := make([]int, len(gdiFile.Tracks))
 
 	var dst io.WriteCloser
+	var pregap *bytes.Buffer
 	for i, track := range g.gdiFile.Tracks {
-		src, err := g.reader.OpenFile(track.Name)
+		src, err := g.openTrack(i)
 		if err != nil {
 			return err
 		}
 		defer src.Close()
 
-		if isRedump {
+		if i == ipBinTrack && patch != nil {
+			if _, err := io.CopyN(ioutil.Discard, src, int64(len(patch))); err != nil {
+				return err
+			}
+			src = readCloser{io.MultiReader(bytes.NewReader(patch), src), src}
+		}
+
+		if isRedump && !toRedump {
 			switch {
-			case track.IsDataTrack() && track.Number == g.gdiFile.Count && track.Number > 3:
+			case g.isLastDataTrack(track):
 				if _, err := io.CopyN(dst, src, preGap*gdi.SectorSize); err != nil {
 					return err
 				}
+				lengths[i-1] += preGap
 				gdiFile.Tracks[i].Start += preGap
 				fallthrough
 			case track.IsAudioTrack():
@@ -298,15 +820,71 @@ func (g Game) Write(writer Writer) error {
 			dst.Close()
 		}
 
-		dst, err = writer.CreateFile(gdiFile.Tracks[i].Name)
+		dst, err = createTrackFile(writer, gdiFile.Tracks[i])
 		if err != nil {
 			return err
 		}
 		defer dst.Close()
 
-		if _, err := io.Copy(dst, src); err != nil {
+		if toRedump {
+			switch {
+			case g.isLastDataTrack(track):
+				pauses[i] = preGap + pauseData
+			case track.IsAudioTrack():
+				pauses[i] = pauseData
+			}
+		}
+
+		if !isRedump && toRedump {
+			switch {
+			case g.isLastDataTrack(track):
+				// Put back the pregap taken from the end of the
+				// previous track, followed by a regenerated pause
+				if _, err := io.Copy(dst, pregap); err != nil {
+					return err
+				}
+				if err := writeSectors(dst, sector.Mode1, track.Start-pauseData, pauseData); err != nil {
+					return err
+				}
+				gdiFile.Tracks[i].Start -= preGap + pauseData
+			case track.IsAudioTrack():
+				if err := writeSectors(dst, sector.ModeUnknown, 0, pauseData); err != nil {
+					return err
+				}
+				gdiFile.Tracks[i].Start -= pauseData
+			}
+			lengths[i] += pauses[i]
+
+			// The pregap of the last data track is found at the
+			// end of the previous track so hold it back
+			if i+1 < len(g.gdiFile.Tracks) && g.isLastDataTrack(g.gdiFile.Tracks[i+1]) {
+				size, err := g.trackSize(i)
+				if err != nil {
+					return err
+				}
+
+				sectors := int(size/gdi.SectorSize) - preGap
+				if sectors < 0 {
+					return errInvalidSize
+				}
+
+				if _, err := io.CopyN(dst, src, int64(sectors)*gdi.SectorSize); err != nil {
+					return err
+				}
+				lengths[i] += sectors
+
+				pregap = new(bytes.Buffer)
+				if _, err := io.CopyN(pregap, src, preGap*gdi.SectorSize); err != nil {
+					return err
+				}
+			}
+		}
+
+		n, err := io.Copy(dst, src)
+		if err != nil {
 			return err
 		}
+		lengths[i] += int(n / gdi.SectorSize)
 
 		src.Close()
 	}
@@ -320,7 +898,7 @@ func (g Game) Write(writer Writer) error {
 	}
 
 	if writer.Config().CueFile != "" {
-		if err := writeCueFile(writer, gdiFile); err != nil {
+		if err := writeCueFile(writer, gdiFile, g.files, lengths, pauses); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,584 @@
+package dreamcast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/dreamcast/cue"
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferSessions(t *testing.T) {
+	tables := []struct {
+		types    []cue.Type
+		sessions []int
+	}{
+		// Selfboot
+		{[]cue.Type{cue.TypeAudio, cue.TypeMode2}, []int{1, 2}},
+		{[]cue.Type{cue.TypeAudio, cue.TypeAudio, cue.TypeMode1, cue.TypeAudio}, []int{1, 1, 2, 2}},
+		// Data only
+		{[]cue.Type{cue.TypeMode1}, []int{1}},
+		{[]cue.Type{cue.TypeMode1, cue.TypeAudio}, []int{1, 1}},
+		// GD-ROM
+		{[]cue.Type{cue.TypeMode1, cue.TypeAudio, cue.TypeMode1}, []int{0, 0, 0}},
+		// Audio only
+		{[]cue.Type{cue.TypeAudio, cue.TypeAudio}, []int{0, 0}},
+	}
+
+	for _, table := range tables {
+		sheet := new(cue.Sheet)
+		for _, typ := range table.types {
+			sheet.Files = append(sheet.Files, cue.File{
+				Tracks: []cue.Track{
+					{
+						Type: typ,
+					},
+				},
+			})
+		}
+
+		inferSessions(sheet)
+
+		var sessions []int
+		for _, file := range sheet.Files {
+			sessions = append(sessions, file.Tracks[0].Session)
+		}
+		assert.Equal(t, table.sessions, sessions)
+	}
+}
+
+// writeGame writes a GD-ROM in the TOSEC layout with a single data track of
+// at least 20 sectors in the high density area starting with b. The warning
+// track starts with silence, as it often does
+func writeGame(t *testing.T, dir string, b []byte) {
+	writeDataTrack(t, filepath.Join(dir, "track01.bin"), 0, 300, nil)
+	warning := append(make([]byte, 10*gdi.SectorSize), bytes.Repeat([]byte{0xaa}, 290*gdi.SectorSize)...)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "track02.raw"), warning, 0644))
+	length := 20
+	if n := (len(b) + sector.DataSize - 1) / sector.DataSize; n > length {
+		length = n
+	}
+	writeDataTrack(t, filepath.Join(dir, "track03.bin"), gdi.TrackThreeStart, length, b)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.gdi"), []byte("3\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n"), 0644))
+}
+
+func TestPatchIPBin(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.SetRegions(RegionJapan)
+	ip.Peripherals &^= uint32(PeripheralVGABox)
+
+	original, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	// A release date that can't be decoded
+	copy(original[offsetReleaseDate:], "2000XX01")
+
+	dir := t.TempDir()
+	writeGame(t, dir, original)
+
+	reader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewLenientGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, g.IPBinErrors.has("ReleaseDate"))
+
+	// Nothing to patch yet
+	b, err := g.patchIPBin()
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+
+	g.SetRegionFree()
+	g.SetVGA()
+
+	b, err = g.patchIPBin()
+	if !assert.Nil(t, err) || !assert.Len(t, b, ipBinSectors*gdi.SectorSize) {
+		return
+	}
+
+	data := new(bytes.Buffer)
+	for i := 0; i < ipBinSectors; i++ {
+		s := b[i*gdi.SectorSize : (i+1)*gdi.SectorSize]
+		d, err := sector.Data(s)
+		assert.Nil(t, err)
+		data.Write(d)
+
+		// The EDC and ECC are regenerated
+		expected, err := sector.New(sector.Mode1, gdi.TrackThreeStart+i, d)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, s)
+	}
+
+	// Only the patched fields change, the broken release date is kept
+	patched := data.Bytes()
+	assert.Equal(t, "JUE     ", string(patched[offsetAreaSymbols:offsetPeripherals]))
+	assert.Equal(t, "E000F10 ", string(patched[offsetPeripherals:offsetProductNumber]))
+	assert.Equal(t, "2000XX01", string(patched[offsetReleaseDate:offsetReleaseDate+8]))
+	for i := range original {
+		inField := false
+		for _, f := range patchFields {
+			inField = inField || i >= f.offset && i < f.end
+		}
+		if !inField && original[i] != patched[i] {
+			t.Errorf("byte %#x changed", i)
+			break
+		}
+	}
+
+	patchedIP := new(IPBin)
+	errs, err := patchedIP.UnmarshalLenient(patched)
+	assert.Nil(t, err)
+	assert.Len(t, errs, 1)
+	assert.Nil(t, patchedIP.VerifyRegions())
+	assert.Equal(t, [numRegions]string{regionStrings[RegionJapan], regionStrings[RegionUSA], regionStrings[RegionEurope]}, patchedIP.RegionStrings)
+
+	// Patching the track in place
+	name, offset, err := g.IPBinFile()
+	assert.Nil(t, err)
+	assert.Equal(t, "track03.bin", name)
+	assert.Equal(t, int64(0), offset)
+
+	track, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR, 0)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.PatchIPBin(f))
+	assert.Nil(t, f.Close())
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, name))
+	assert.Nil(t, err)
+	assert.Equal(t, append(append([]byte{}, b...), track[len(b):]...), got)
+
+	// Writing the game with the patch applied
+	out := t.TempDir()
+	writer, err := NewDirectoryWriter(out, WriterConfig{GDIFile: "disc.gdi"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Write(writer))
+
+	got, err = ioutil.ReadFile(filepath.Join(out, name))
+	assert.Nil(t, err)
+	assert.Equal(t, append(append([]byte{}, b...), track[len(b):]...), got)
+}
+
+// writeTOSECGame writes a GD-ROM in the TOSEC layout with an audio track
+// and a final data track in the high density area. The pregap of the final
+// data track is at the end of the audio track
+func writeTOSECGame(t *testing.T, dir string, ip []byte) {
+	writeGame(t, dir, ip)
+
+	audio := bytes.Repeat([]byte{0x11, 0x22}, 300*gdi.SectorSize/2)
+	writeDataTrack(t, filepath.Join(dir, "pregap.bin"), 45470, preGap, nil)
+	pregap, err := ioutil.ReadFile(filepath.Join(dir, "pregap.bin"))
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(filepath.Join(dir, "pregap.bin")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "track04.raw"), append(audio, pregap...), 0644))
+
+	writeDataTrack(t, filepath.Join(dir, "track05.bin"), 45695, 50, nil)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.gdi"), []byte("5\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n4 45170 0 2352 track04.raw 0\n5 45695 4 2352 track05.bin 0\n"), 0644))
+}
+
+func readDir(t *testing.T, dir string) map[string][]byte {
+	fis, err := ioutil.ReadDir(dir)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	files := make(map[string][]byte)
+	for _, fi := range fis {
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		assert.Nil(t, err)
+		files[fi.Name()] = b
+	}
+	return files
+}
+
+func TestWriteRedump(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, fn := range []func(*testing.T, string, []byte){writeGame, writeTOSECGame} {
+		tosec := t.TempDir()
+		fn(t, tosec, b)
+		original := readDir(t, tosec)
+
+		// Convert to the Redump layout and back again
+		redump, roundTrip := t.TempDir(), t.TempDir()
+		for _, dirs := range [][2]string{{tosec, redump}, {redump, roundTrip}} {
+			reader, err := NewDirectoryReader(dirs[0])
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer reader.Close()
+
+			g, err := NewGame(reader)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			isRedump, err := g.isRedump()
+			assert.Nil(t, err)
+			assert.Equal(t, dirs[0] == redump, isRedump)
+
+			writer, err := NewDirectoryWriter(dirs[1], WriterConfig{GDIFile: "disc.gdi", Redump: dirs[1] == redump})
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Nil(t, g.Write(writer))
+		}
+
+		assert.Equal(t, original, readDir(t, roundTrip))
+
+		converted := readDir(t, redump)
+		silence := make([]byte, pauseData*gdi.SectorSize)
+
+		// Audio tracks start with the pause
+		assert.Equal(t, original["track01.bin"], converted["track01.bin"])
+		assert.Equal(t, append(silence, original["track02.raw"]...), converted["track02.raw"])
+		assert.Equal(t, original["track03.bin"], converted["track03.bin"])
+
+		if len(original) == 4 {
+			assert.Equal(t, "3\n1     0 4 2352 track01.bin 0\n2   300 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n", string(converted["disc.gdi"]))
+			continue
+		}
+		assert.Equal(t, "5\n1     0 4 2352 track01.bin 0\n2   300 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n4 45020 0 2352 track04.raw 0\n5 45470 4 2352 track05.bin 0\n", string(converted["disc.gdi"]))
+
+		// The pregap is held back from the audio track and placed
+		// before a regenerated pause at the start of the data track
+		audio := original["track04.raw"][:300*gdi.SectorSize]
+		pregap := original["track04.raw"][300*gdi.SectorSize:]
+		assert.Equal(t, append(silence, audio...), converted["track04.raw"])
+
+		pause := new(bytes.Buffer)
+		for i := 0; i < pauseData; i++ {
+			s, err := sector.New(sector.Mode1, 45545+i, nil)
+			assert.Nil(t, err)
+			pause.Write(s)
+		}
+		assert.Equal(t, append(append(append([]byte{}, pregap...), pause.Bytes()...), original["track05.bin"]...), converted["track05.bin"])
+	}
+}
+
+func TestWriteCueFile(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tables := []struct {
+		redump bool
+		cue    string
+	}{
+		// Any gap that isn't stored in the file is a PREGAP
+		{
+			false,
+			"REM SINGLE-DENSITY AREA\nFILE \"track01.bin\" BINARY\n  TRACK 01 MODE1/2352\n    INDEX 01 00:00:00\nFILE \"track02.raw\" BINARY\n  TRACK 02 AUDIO\n    PREGAP 00:02:00\n    INDEX 01 00:00:00\nREM HIGH-DENSITY AREA\nFILE \"track03.bin\" BINARY\n  TRACK 03 MODE1/2352\n    INDEX 01 00:00:00\nFILE \"track04.raw\" BINARY\n  TRACK 04 AUDIO\n    PREGAP 00:02:00\n    INDEX 01 00:00:00\nFILE \"track05.bin\" BINARY\n  TRACK 05 MODE1/2352\n    PREGAP 00:02:00\n    INDEX 01 00:00:00\n",
+		},
+		// The pause, and the pregap of the final data track, stored at
+		// the start of the file sits between INDEX 00 and INDEX 01
+		{
+			true,
+			"REM SINGLE-DENSITY AREA\nFILE \"track01.bin\" BINARY\n  TRACK 01 MODE1/2352\n    INDEX 01 00:00:00\nFILE \"track02.raw\" BINARY\n  TRACK 02 AUDIO\n    INDEX 00 00:00:00\n    INDEX 01 00:02:00\nREM HIGH-DENSITY AREA\nFILE \"track03.bin\" BINARY\n  TRACK 03 MODE1/2352\n    INDEX 01 00:00:00\nFILE \"track04.raw\" BINARY\n  TRACK 04 AUDIO\n    INDEX 00 00:00:00\n    INDEX 01 00:02:00\nFILE \"track05.bin\" BINARY\n  TRACK 05 MODE1/2352\n    INDEX 00 00:00:00\n    INDEX 01 00:03:00\n",
+		},
+	}
+
+	for _, table := range tables {
+		dir := t.TempDir()
+		writeTOSECGame(t, dir, b)
+
+		reader, err := NewDirectoryReader(dir)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer reader.Close()
+
+		g, err := NewGame(reader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		out := t.TempDir()
+		writer, err := NewDirectoryWriter(out, WriterConfig{GDIFile: "disc.gdi", CueFile: "disc.cue", Redump: table.redump})
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Nil(t, g.Write(writer))
+
+		files := readDir(t, out)
+		assert.Equal(t, table.cue, string(files["disc.cue"]))
+
+		gdiReader, err := NewDirectoryReader(out)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer gdiReader.Close()
+
+		gdiGame, err := NewGame(gdiReader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// Without the GDI file the cue sheet is read instead, which
+		// should place every track at the same start
+		assert.Nil(t, os.Remove(filepath.Join(out, "disc.gdi")))
+
+		cueReader, err := NewDirectoryReader(out)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer cueReader.Close()
+
+		cueGame, err := NewGame(cueReader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, "disc.cue", cueGame.CueFile)
+		assert.Equal(t, gdiGame.Tracks(), cueGame.Tracks())
+
+		isRedump, err := cueGame.isRedump()
+		assert.Nil(t, err)
+		assert.Equal(t, table.redump, isRedump)
+	}
+}
+
+func TestSingleBinCueFile(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tosec := t.TempDir()
+	writeTOSECGame(t, tosec, b)
+	original := readDir(t, tosec)
+
+	// Convert to the Redump layout first so each pause is stored
+	reader, err := NewDirectoryReader(tosec)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	redump := t.TempDir()
+	writer, err := NewDirectoryWriter(redump, WriterConfig{GDIFile: "disc.gdi", Redump: true})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Write(writer))
+	converted := readDir(t, redump)
+
+	// Store each area in a single file, with the start of each track
+	// given by its INDEX 00 offset
+	dir := t.TempDir()
+	var low, high []byte
+	for _, name := range []string{"track01.bin", "track02.raw"} {
+		low = append(low, converted[name]...)
+	}
+	for _, name := range []string{"track03.bin", "track04.raw", "track05.bin"} {
+		high = append(high, converted[name]...)
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "low.bin"), low, 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "high.bin"), high, 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.cue"), []byte("REM SINGLE-DENSITY AREA\nFILE \"low.bin\" BINARY\n  TRACK 01 MODE1/2352\n    INDEX 01 00:00:00\n  TRACK 02 AUDIO\n    INDEX 00 00:04:00\n    INDEX 01 00:06:00\nREM HIGH-DENSITY AREA\nFILE \"high.bin\" BINARY\n  TRACK 03 MODE1/2352\n    INDEX 01 00:00:00\n  TRACK 04 AUDIO\n    INDEX 00 00:00:20\n    INDEX 01 00:02:20\n  TRACK 05 MODE1/2352\n    INDEX 00 00:06:20\n    INDEX 01 00:09:20\n"), 0644))
+
+	cueReader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cueReader.Close()
+
+	cueGame, err := NewGame(cueReader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Each track starts where it does in the Redump GDI file and reads
+	// back the same sectors, only the names differ
+	tracks := cueGame.Tracks()
+	if !assert.Equal(t, 5, len(tracks)) {
+		return
+	}
+	for i, expected := range []int{0, 300, gdi.TrackThreeStart, 45020, 45470} {
+		assert.Equal(t, expected, tracks[i].Start)
+		assert.Equal(t, GDemuTrackName(tracks[i]), tracks[i].Name)
+
+		rc, err := cueGame.OpenTrack(tracks[i].Number)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, converted[filepath.Base(g.Tracks()[i].Name)], b)
+		rc.Close()
+	}
+
+	// Writing it back in the TOSEC layout recreates the original
+	out := t.TempDir()
+	writer, err = NewDirectoryWriter(out, WriterConfig{GDIFile: "disc.gdi"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, cueGame.Write(writer))
+	assert.Equal(t, original, readDir(t, out))
+}
+
+func TestMultiSessionCueFile(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// The data track in the second session starts after the lead-out of
+	// the first session and the lead-in of the second
+	start := 300 + firstLeadOut + leadIn
+	marked := "REM SESSION 01\nFILE \"track01.raw\" BINARY\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\nREM SESSION 02\nFILE \"track02.bin\" BINARY\n  TRACK 02 MODE1/2352\n    INDEX 01 00:00:00\n"
+
+	tables := []string{
+		marked,
+		// Without session markers the sessions are inferred
+		"FILE \"track01.raw\" BINARY\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\nFILE \"track02.bin\" BINARY\n  TRACK 02 MODE1/2352\n    INDEX 01 00:00:00\n",
+	}
+
+	for _, table := range tables {
+		dir := t.TempDir()
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "track01.raw"), bytes.Repeat([]byte{0x11, 0x22}, 300*gdi.SectorSize/2), 0644))
+		writeDataTrack(t, filepath.Join(dir, "track02.bin"), start, 20, b)
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.cue"), []byte(table), 0644))
+
+		reader, err := NewDirectoryReader(dir)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer reader.Close()
+
+		g, err := NewGame(reader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, LayoutCD, g.Layout())
+		assert.Equal(t, []gdi.Track{
+			{Number: 1, Start: 0, Type: gdi.TypeAudio, SectorSize: gdi.SectorSize, Name: "track01.raw"},
+			{Number: 2, Start: start, Type: gdi.TypeData, SectorSize: gdi.SectorSize, Name: "track02.bin"},
+		}, g.Tracks())
+
+		// The gap between the sessions isn't written as a PREGAP
+		out := t.TempDir()
+		writer, err := NewDirectoryWriter(out, WriterConfig{CueFile: "disc.cue"})
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Nil(t, g.Write(writer))
+
+		files := readDir(t, out)
+		assert.Equal(t, marked, string(files["disc.cue"]))
+
+		cueReader, err := NewDirectoryReader(out)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer cueReader.Close()
+
+		cueGame, err := NewGame(cueReader)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, g.Tracks(), cueGame.Tracks())
+	}
+}
+
+func TestNewGameMalformed(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tables := []struct {
+		name    string
+		content string
+		short   bool
+	}{
+		// Only two tracks so there's no track 3 to read IP.BIN from
+		{"disc.gdi", "2\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n", false},
+		{"disc.gdi", "3\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n", false},
+		// Track 3 isn't made of 2352 byte sectors
+		{"disc.gdi", "3\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n3 45000 4 2048 track03.bin 0\n", false},
+		// Track 3 doesn't exist
+		{"disc.gdi", "3\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n3 45000 4 2352 track04.bin 0\n", false},
+		// Track 3 is too short to hold IP.BIN
+		{"disc.gdi", "3\n1     0 4 2352 track01.bin 0\n2   450 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n", true},
+		// A GD-ROM cue sheet without a high density area
+		{"disc.cue", "REM SINGLE-DENSITY AREA\nFILE \"track01.bin\" BINARY\n  TRACK 01 MODE1/2352\n    INDEX 01 00:00:00\nFILE \"track02.raw\" BINARY\n  TRACK 02 AUDIO\n    INDEX 01 00:00:00\n", false},
+		// A cue sheet without any data track
+		{"disc.cue", "FILE \"track02.raw\" BINARY\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n", false},
+	}
+
+	for _, table := range tables {
+		dir := t.TempDir()
+		writeGame(t, dir, b)
+		assert.Nil(t, os.Remove(filepath.Join(dir, "disc.gdi")))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, table.name), []byte(table.content), 0644))
+		if table.short {
+			assert.Nil(t, os.Truncate(filepath.Join(dir, "track03.bin"), (ipBinSectors-1)*gdi.SectorSize))
+		}
+
+		reader, err := NewDirectoryReader(dir)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		_, err = NewGame(reader)
+		assert.NotNil(t, err, table.content)
+		_, err = NewLenientGame(reader)
+		assert.NotNil(t, err, table.content)
+
+		reader.Close()
+	}
+}
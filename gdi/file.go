@@ -178,6 +178,12 @@ func (f File) validate() error {
 	return nil
 }
 
+// Validate checks if the GDI file is valid, returning the first problem
+// found
+func (f File) Validate() error {
+	return f.validate()
+}
+
 // IsValid checks if the GDI file is valid or not
 func (f File) IsValid() bool {
 	if err := f.validate(); err != nil {
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package gdi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzUnmarshalText(f *testing.F) {
+	for _, seed := range []string{
+		"3\n1 0 4 2352 track01.bin 0\n2 756 0 2352 \"track02.raw\" 0\n3 45000 4 2352 track03.bin 0\n",
+		"3\r\n1 0 4 2352 \"track 01.bin\" 0\r\n2 756 0 2352 \"track 02.raw\" 0\r\n3 45000 4 2352 \"track 03.bin\" 0\r\n",
+		"1\n1 0 4 2352 \"track01.bin 0\n",
+		"",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		file := new(File)
+		if err := file.UnmarshalText(b); err != nil {
+			return
+		}
+
+		// Anything that unmarshals should be valid so marshal again
+		_, err := file.MarshalText()
+		assert.Nil(t, err)
+	})
+}
@@ -145,6 +145,7 @@ func TestIsValid(t *testing.T) {
 
 	for _, table := range tables {
 		assert.Equal(t, table.want, table.got.IsValid())
+		assert.Equal(t, table.want, table.got.Validate() == nil)
 	}
 }
 
@@ -344,6 +345,42 @@ INVALID 0 4 2352 track01.bin 0
 	}
 }
 
+func TestUnmarshalTextMalformed(t *testing.T) {
+	tables := []struct {
+		got string
+		err error
+	}{
+		// No input at all
+		{"", errNotEnoughTracks},
+		{"\n\n", &strconv.NumError{Func: "Atoi", Num: "", Err: strconv.ErrSyntax}},
+		// A count but no tracks
+		{"3\n", errInconsistentTracks},
+		// Only two tracks so there's no track 3
+		{"2\n1 0 4 2352 track01.bin 0\n2 756 0 2352 track02.raw 0\n", errNotEnoughTracks},
+		{"3\n1 0 4 2352 track01.bin 0\n2 756 0 2352 track02.raw 0\n", errInconsistentTracks},
+		// Track 3 isn't made of 2352 byte sectors
+		{"3\n1 0 4 2352 track01.bin 0\n2 756 0 2352 track02.raw 0\n3 45000 4 2048 track03.iso 0\n", errInvalidSectorSize},
+		// Missing and extra fields
+		{"3\n1 0 4 2352 track01.bin\n", errInvalidTrack},
+		{"3\n1 0 4 2352 track01.bin 0 0\n", errInvalidTrack},
+		{"3\n\n", errInvalidTrack},
+		// More tracks than the count
+		{"1\n1 0 4 2352 track01.bin 0\n2 756 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n", errNotEnoughTracks},
+		{"-1\n", errNotEnoughTracks},
+		{"\x00\xff\n\x00", &strconv.NumError{Func: "Atoi", Num: "\x00\xff", Err: strconv.ErrSyntax}},
+	}
+
+	for _, table := range tables {
+		f := new(File)
+		assert.Equal(t, table.err, f.UnmarshalText([]byte(table.got)), table.got)
+
+		// Whatever was decoded can't be marshalled again
+		_, err := f.MarshalText()
+		assert.NotNil(t, err)
+		assert.False(t, f.IsValid())
+	}
+}
+
 func TestMarshalText(t *testing.T) {
 	tables := []struct {
 		got  File
@@ -1,9 +1,9 @@
 module github.com/bodgit/dreamcast
 
-go 1.13
+go 1.16
 
 require (
 	github.com/bodgit/plumbing v0.0.0-20200416224122-022a88494db8
 	github.com/stretchr/testify v1.5.1
-	github.com/vchimishuk/chub v0.0.0-20190501162134-36f1f5f7c9ef
+	github.com/ulikunitz/xz v0.5.12
 )
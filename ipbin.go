@@ -1,12 +1,18 @@
 package dreamcast
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/bodgit/dreamcast/mr"
 )
 
 const (
@@ -28,30 +34,180 @@ const (
 	offsetProducer          = 0x070
 	offsetSoftwareName      = 0x080
 	offsetTOC               = 0x100
+	offsetTOCEntries        = offsetTOC + 4
+	offsetRegionStrings     = 0x3700
+	offsetLogo              = 0x3820
+	tocEntries              = 97
+)
+
+const (
+	// RegionJapan is the index of Japan, Taiwan and the Philippines
+	RegionJapan = iota
+	// RegionUSA is the index of the USA and Canada
+	RegionUSA
+	// RegionEurope is the index of Europe
+	RegionEurope
+	numRegions
+)
+
+// Each region string follows a short branch instruction
+const (
+	regionStringSize   = 0x20
+	regionStringOffset = 4
+)
+
+const (
+	defaultHardwareID     = "SEGA SEGAKATANA"
+	naomiHardwareID       = "SEGA NAOMI"
+	naomi2HardwareID      = "SEGA NAOMI2"
+	defaultMakerID        = "SEGA ENTERPRISES"
+	defaultDevice         = "GD-ROM"
+	defaultPeripherals    = 0xe000f10
+	defaultProductNumber  = "T0000"
+	defaultProductVersion = "V1.000"
+	defaultBootFilename   = "1ST_READ.BIN"
+)
+
+var tocMagic = []byte("TOC1")
+
+var (
+	errInvalidIPBin = errors.New("incorrect amount of bytes for IP.BIN")
+	errFieldTooLong = errors.New("field too long")
+	errInvalidCRC   = errors.New("invalid CRC")
+	errNoLogo       = errors.New("no logo")
+	errRegion       = errors.New("region string mismatch")
+
+	errUnknownRegion     = errors.New("unknown region")
+	errUnknownPeripheral = errors.New("unknown peripheral")
+)
+
+var (
+	regionSymbols = [numRegions]byte{'J', 'U', 'E'}
+	regionNames   = [numRegions]string{"Japan", "USA", "Europe"}
+	regionStrings = [numRegions]string{"For JAPAN,TAIWAN,PHILIPINES.", "For USA and CANADA.", "For EUROPE."}
 )
 
 // Region represents the permitted regions
 type Region [offsetPeripherals - offsetAreaSymbols]byte
 
+// IsRegion returns true if the region with the passed index is permitted
+func (r Region) IsRegion(region int) bool {
+	return region >= 0 && region < numRegions && r[region] == regionSymbols[region]
+}
+
 // IsRegionJapan returns true if Japan region is permitted
 func (r Region) IsRegionJapan() bool {
-	return r[0] == 'J'
+	return r.IsRegion(RegionJapan)
 }
 
 // IsRegionUSA returns true if USA region is permitted
 func (r Region) IsRegionUSA() bool {
-	return r[1] == 'U'
+	return r.IsRegion(RegionUSA)
 }
 
 // IsRegionEurope returns true if Europe region is permitted
 func (r Region) IsRegionEurope() bool {
-	return r[2] == 'E'
+	return r.IsRegion(RegionEurope)
+}
+
+// Names returns the human-readable names of the permitted regions
+func (r Region) Names() []string {
+	var names []string
+	for i, name := range regionNames {
+		if r.IsRegion(i) {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 func (r Region) String() string {
 	return string(r[:])
 }
 
+// MarshalText encodes the region as the area symbols, such as "JUE"
+func (r Region) MarshalText() ([]byte, error) {
+	return []byte(strings.TrimRight(r.String(), space)), nil
+}
+
+// UnmarshalText decodes the region from the area symbols
+func (r *Region) UnmarshalText(text []byte) error {
+	if len(text) > len(r) {
+		return fmt.Errorf("%w: %q", errFieldTooLong, text)
+	}
+	copy(r[:], text)
+	copy(r[len(text):], strings.Repeat(space, len(r)-len(text)))
+	return nil
+}
+
+// MarshalJSON encodes the region as the list of permitted region names
+func (r Region) MarshalJSON() ([]byte, error) {
+	names := r.Names()
+	if names == nil {
+		names = []string{}
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON decodes the region from a list of permitted region names
+func (r *Region) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+
+	copy(r[:], strings.Repeat(space, len(r)))
+	for _, name := range names {
+		i := indexOf(regionNames[:], name)
+		if i < 0 {
+			return fmt.Errorf("%w: %q", errUnknownRegion, name)
+		}
+		r[i] = regionSymbols[i]
+	}
+
+	return nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Hardware identifies the hardware a disc is for
+type Hardware int
+
+const (
+	// HardwareUnknown is used for an unrecognised hardware ID
+	HardwareUnknown Hardware = iota
+	// HardwareDreamcast is used for a Dreamcast disc, the hardware ID
+	// is "SEGA SEGAKATANA"
+	HardwareDreamcast
+	// HardwareNaomi is used for a NAOMI GD-ROM
+	HardwareNaomi
+	// HardwareNaomi2 is used for a NAOMI 2 GD-ROM
+	HardwareNaomi2
+)
+
+var hardwareNames = map[Hardware]string{
+	HardwareUnknown:   "Unknown",
+	HardwareDreamcast: "Dreamcast",
+	HardwareNaomi:     "NAOMI",
+	HardwareNaomi2:    "NAOMI 2",
+}
+
+func (h Hardware) String() string {
+	return hardwareNames[h]
+}
+
+// IsNaomi returns true if the hardware is either NAOMI or NAOMI 2
+func (h Hardware) IsNaomi() bool {
+	return h == HardwareNaomi || h == HardwareNaomi2
+}
+
 // Peripheral maps to each peripheral option
 type Peripheral int
 
@@ -87,18 +243,100 @@ const (
 	PeripheralMouse
 )
 
+var peripheralNames = map[Peripheral]string{
+	PeripheralWindowsCE:          "WindowsCE",
+	PeripheralVGABox:             "VGABox",
+	PeripheralOtherExpansions:    "OtherExpansions",
+	PeripheralVibrationPack:      "VibrationPack",
+	PeripheralMicrophone:         "Microphone",
+	PeripheralMemoryCard:         "MemoryCard",
+	PeripheralStartABDirections:  "StartABDirections",
+	PeripheralCButton:            "CButton",
+	PeripheralDButton:            "DButton",
+	PeripheralXButton:            "XButton",
+	PeripheralYButton:            "YButton",
+	PeripheralZButton:            "ZButton",
+	PeripheralExpandedDirections: "ExpandedDirections",
+	PeripheralRTrigger:           "RTrigger",
+	PeripheralLTrigger:           "LTrigger",
+	PeripheralHorizontal:         "Horizontal",
+	PeripheralVertical:           "Vertical",
+	PeripheralExpandedHorizontal: "ExpandedHorizontal",
+	PeripheralExpandedVertical:   "ExpandedVertical",
+	PeripheralGun:                "Gun",
+	PeripheralKeyboard:           "Keyboard",
+	PeripheralMouse:              "Mouse",
+}
+
 // IsSet returns true if the given peripheral is set
 func (p Peripheral) IsSet(peripherals uint32) bool {
 	return (peripherals & uint32(p)) != 0
 }
 
+// PeripheralsOf expands the peripheral bitmask into the individual
+// peripherals that are set, in order
+func PeripheralsOf(peripherals uint32) []Peripheral {
+	var p []Peripheral
+	for bit := Peripheral(1); bit != 0 && uint32(bit) <= peripherals; bit <<= 1 {
+		if bit.IsSet(peripherals) {
+			p = append(p, bit)
+		}
+	}
+	return p
+}
+
+// PeripheralMask combines the individual peripherals back into a bitmask
+func PeripheralMask(peripherals []Peripheral) uint32 {
+	var mask uint32
+	for _, p := range peripherals {
+		mask |= uint32(p)
+	}
+	return mask
+}
+
+// String returns the name of the peripheral, such as "VibrationPack", or
+// the hexadecimal value for a bit without a name
+func (p Peripheral) String() string {
+	if name, ok := peripheralNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", uint32(p))
+}
+
+// MarshalText encodes the peripheral as its name
+func (p Peripheral) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes the peripheral from its name or hexadecimal value
+func (p *Peripheral) UnmarshalText(text []byte) error {
+	for peripheral, name := range peripheralNames {
+		if name == string(text) {
+			*p = peripheral
+			return nil
+		}
+	}
+
+	if strings.HasPrefix(string(text), "0x") {
+		if v, err := strconv.ParseUint(string(text[2:]), 16, 32); err == nil {
+			*p = Peripheral(v)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q", errUnknownPeripheral, text)
+}
+
 // IPBin represents the IP.BIN initial program. It implements the
-// encoding.BinaryUnmarshaler interface.
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler interfaces.
 type IPBin struct {
-	bytes          []byte
-	HardwareID     string
-	MakerID        string
-	CRC            uint16
+	bytes      []byte
+	HardwareID string
+	MakerID    string
+	CRC        uint16
+	// Device is the type of disc, usually GD-ROM, or CD-ROM for a MIL-CD
+	// disc
+	Device         string
 	Disc           int
 	TotalDiscs     int
 	Regions        Region
@@ -110,6 +348,10 @@ type IPBin struct {
 	Producer       string
 	SoftwareName   string
 	TOC            []Track
+	// RegionStrings are the strings for each region found in the
+	// bootstrap, indexed by RegionJapan, RegionUSA and RegionEurope.
+	// The string is empty if the region is not permitted
+	RegionStrings [numRegions]string
 }
 
 func crc(b []byte) uint16 {
@@ -127,14 +369,108 @@ func crc(b []byte) uint16 {
 	return n & 0xffff
 }
 
-// UnmarshalBinary decodes the IP.BIN from binary form
-func (ip *IPBin) UnmarshalBinary(b []byte) error {
-	if len(b) != ipBinLength {
-		return errors.New("incorrect amount of bytes for IP.BIN")
+// ComputeCRC returns the CRC expected in the device information, which is
+// calculated over the space-padded product number and version
+func (ip IPBin) ComputeCRC() uint16 {
+	return crc([]byte(fmt.Sprintf("%-*s%-*s", offsetProductVersion-offsetProductNumber, ip.ProductNumber, offsetReleaseDate-offsetProductVersion, ip.ProductVersion)))
+}
+
+// VerifyCRC returns an error if the CRC doesn't match the one computed from
+// the product number and version
+func (ip IPBin) VerifyCRC() error {
+	if expected := ip.ComputeCRC(); ip.CRC != expected {
+		return fmt.Errorf("%w: %04X, expected %04X", errInvalidCRC, ip.CRC, expected)
 	}
+	return nil
+}
+
+// UpdateCRC sets the CRC to the one computed from the product number and
+// version
+func (ip *IPBin) UpdateCRC() {
+	ip.CRC = ip.ComputeCRC()
+}
 
+// FieldError records a field of the IP.BIN that couldn't be decoded, or
+// that decoded to a value the BIOS is likely to reject
+type FieldError struct {
+	// Field is the name of the matching IPBin field
+	Field string
+	// Offset is the offset of the field within the IP.BIN
+	Offset int
+	// Value is the raw value of the field
+	Value string
+	// Warning is true if the field was still decoded
+	Warning bool
+	Err     error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s at %#03x %q: %v", e.Field, e.Offset, e.Value, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors is the list of field errors and warnings found when leniently
+// decoding an IP.BIN
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	s := make([]string, 0, len(e))
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Warnings returns just the warnings
+func (e FieldErrors) Warnings() FieldErrors {
+	var warnings FieldErrors
+	for _, err := range e {
+		if err.Warning {
+			warnings = append(warnings, err)
+		}
+	}
+	return warnings
+}
+
+// Errors returns just the fields that couldn't be decoded
+func (e FieldErrors) Errors() FieldErrors {
+	var errs FieldErrors
+	for _, err := range e {
+		if !err.Warning {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (e FieldErrors) has(name string) bool {
+	for _, err := range e {
+		if err.Field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshal decodes as much of the IP.BIN as possible, any field that
+// can't be decoded is left as the zero value
+func (ip *IPBin) unmarshal(b []byte) FieldErrors {
 	ip.bytes = b
 
+	var errs FieldErrors
+	field := func(name string, offset, end int, err error) {
+		errs = append(errs, &FieldError{
+			Field:  name,
+			Offset: offset,
+			Value:  string(ip.bytes[offset:end]),
+			Err:    err,
+		})
+	}
+
 	// Copy out all of the simple space-padded strings
 	ip.HardwareID = strings.TrimRight(string(ip.bytes[offsetHardwareID:offsetMakerID]), space)
 	ip.MakerID = strings.TrimRight(string(ip.bytes[offsetMakerID:offsetDeviceInformation]), space)
@@ -145,40 +481,53 @@ func (ip *IPBin) UnmarshalBinary(b []byte) error {
 	ip.SoftwareName = strings.TrimRight(string(ip.bytes[offsetSoftwareName:offsetTOC]), space)
 
 	// Copy out the CRC and decode it back into a number
-	crc, err := hex.DecodeString(string(ip.bytes[offsetDeviceInformation : offsetDeviceInformation+4]))
-	if err != nil {
-		return err
+	ip.CRC = 0
+	if crc, err := hex.DecodeString(string(ip.bytes[offsetDeviceInformation : offsetDeviceInformation+4])); err != nil {
+		field("CRC", offsetDeviceInformation, offsetDeviceInformation+4, err)
+	} else {
+		ip.CRC = binary.BigEndian.Uint16(crc)
 	}
-	ip.CRC = binary.BigEndian.Uint16(crc)
 
-	// Extract the current disc and total disc counts
-	if _, err = fmt.Sscanf(string(ip.bytes[offsetDeviceInformation+5:offsetPeripherals]), "GD-ROM%d/%d", &ip.Disc, &ip.TotalDiscs); err != nil {
-		return err
+	// Extract the type of disc, and the current disc and total disc counts
+	ip.Device, ip.Disc, ip.TotalDiscs = "", 0, 0
+	if _, err := fmt.Sscanf(string(ip.bytes[offsetDeviceInformation+5:offsetAreaSymbols]), "%6s%d/%d", &ip.Device, &ip.Disc, &ip.TotalDiscs); err != nil {
+		field("Disc", offsetDeviceInformation+5, offsetAreaSymbols, err)
 	}
 
-	// Extract the regions
+	// Extract the regions and the matching strings checked by the BIOS
 	copy(ip.Regions[:], ip.bytes[offsetAreaSymbols:offsetPeripherals])
+	for i := range ip.RegionStrings {
+		offset := offsetRegionStrings + i*regionStringSize
+		ip.RegionStrings[i] = strings.TrimRight(string(ip.bytes[offset+regionStringOffset:offset+regionStringSize]), space)
+	}
 
 	// Extract the peripheral bitmask. The number is seven digits long and
 	// needs padding to an even number of digits in order to decode it
-	peripherals, err := hex.DecodeString("0" + string(ip.bytes[offsetPeripherals:offsetProductNumber-1]))
-	if err != nil {
-		return err
+	// NAOMI has no use for the peripherals so the field isn't always
+	// a valid number
+	ip.Peripherals = 0
+	if peripherals, err := hex.DecodeString("0" + string(ip.bytes[offsetPeripherals:offsetProductNumber-1])); err != nil {
+		if !ip.Hardware().IsNaomi() {
+			field("Peripherals", offsetPeripherals, offsetProductNumber-1, err)
+		}
+	} else {
+		ip.Peripherals = binary.BigEndian.Uint32(peripherals)
 	}
-	ip.Peripherals = binary.BigEndian.Uint32(peripherals)
 
 	// Parse the release date into a proper time object
+	var err error
 	if ip.ReleaseDate, err = time.Parse("20060102", strings.TrimRight(string(ip.bytes[offsetReleaseDate:offsetBootFilename]), space)); err != nil {
-		return err
+		field("ReleaseDate", offsetReleaseDate, offsetBootFilename, err)
 	}
 
 	// 99 tracks potentially on a GDROM, but the first two are in the low
 	// density area so we ignore those
-	for i := 0; i < 97; i++ {
-		start := ip.bytes[offsetTOC+4+i*4 : offsetTOC+7+i*4]
+	ip.TOC = nil
+	for i := 0; i < tocEntries; i++ {
+		start := ip.bytes[offsetTOCEntries+i*4 : offsetTOCEntries+3+i*4]
 		track := Track{
 			Start: int(start[0]) + int(start[1])<<8 + int(start[2])<<16 - pauseData,
-			Type:  int(ip.bytes[offsetTOC+7+i*4]),
+			Type:  int(ip.bytes[offsetTOCEntries+3+i*4]),
 		}
 		if !track.IsAudioTrack() && !track.IsDataTrack() {
 			break
@@ -189,7 +538,238 @@ func (ip *IPBin) UnmarshalBinary(b []byte) error {
 	for i := 0; i < len(ip.TOC)-1; i++ {
 		ip.TOC[i].Length = ip.TOC[i+1].Start - ip.TOC[i].Start - pauseData
 	}
-	ip.TOC[len(ip.TOC)-1].Length = lastSector - ip.TOC[len(ip.TOC)-1].Start - pauseData
+	if len(ip.TOC) > 0 {
+		ip.TOC[len(ip.TOC)-1].Length = lastSector - ip.TOC[len(ip.TOC)-1].Start - pauseData
+	}
+
+	return errs
+}
+
+// UnmarshalBinary decodes the IP.BIN from binary form
+func (ip *IPBin) UnmarshalBinary(b []byte) error {
+	if len(b) != ipBinLength {
+		return errInvalidIPBin
+	}
+
+	if errs := ip.unmarshal(b); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// UnmarshalLenient decodes the IP.BIN from binary form like UnmarshalBinary
+// however any field that can't be decoded is left as the zero value rather
+// than failing. Those fields are returned, along with warnings if the CRC
+// or region strings don't match. An error is only returned if b can't be
+// an IP.BIN
+func (ip *IPBin) UnmarshalLenient(b []byte) (FieldErrors, error) {
+	if len(b) != ipBinLength {
+		return nil, errInvalidIPBin
+	}
+
+	errs := ip.unmarshal(b)
+
+	if !errs.has("CRC") {
+		if err := ip.VerifyCRC(); err != nil {
+			errs = append(errs, &FieldError{
+				Field:   "CRC",
+				Offset:  offsetDeviceInformation,
+				Value:   string(ip.bytes[offsetDeviceInformation : offsetDeviceInformation+4]),
+				Warning: true,
+				Err:     err,
+			})
+		}
+	}
+
+	if err := ip.VerifyRegions(); err != nil {
+		errs = append(errs, &FieldError{
+			Field:   "RegionStrings",
+			Offset:  offsetRegionStrings,
+			Value:   ip.Regions.String(),
+			Warning: true,
+			Err:     err,
+		})
+	}
+
+	return errs, nil
+}
+
+func putString(b []byte, s string) error {
+	if len(s) > len(b) {
+		return fmt.Errorf("%w: %q", errFieldTooLong, s)
+	}
+	copy(b, s)
+	copy(b[len(s):], strings.Repeat(space, len(b)-len(s)))
+	return nil
+}
+
+// Hardware returns the hardware the disc is for, based on the hardware ID
+func (ip IPBin) Hardware() Hardware {
+	switch id := strings.ToUpper(ip.HardwareID); {
+	case id == defaultHardwareID:
+		return HardwareDreamcast
+	case strings.HasPrefix(id, naomi2HardwareID):
+		return HardwareNaomi2
+	case strings.HasPrefix(id, naomiHardwareID):
+		return HardwareNaomi
+	}
+	return HardwareUnknown
+}
+
+// device returns the type of disc, defaulting to a GD-ROM
+func (ip IPBin) device() string {
+	if ip.Device == "" {
+		return defaultDevice
+	}
+	return ip.Device
+}
+
+// MarshalBinary encodes the IP.BIN into binary form. The bootstrap and
+// anything else not represented by a field is kept from the IP.BIN that
+// was unmarshalled or passed to NewIPBin. If the TOC is empty then the
+// existing TOC is also kept
+func (ip IPBin) MarshalBinary() ([]byte, error) {
+	b := make([]byte, ipBinLength)
+	copy(b, ip.bytes)
+
+	for _, f := range []struct {
+		offset, end int
+		s           string
+	}{
+		{offsetHardwareID, offsetMakerID, ip.HardwareID},
+		{offsetMakerID, offsetDeviceInformation, ip.MakerID},
+		{offsetDeviceInformation, offsetAreaSymbols, fmt.Sprintf("%04X %s%d/%d", ip.CRC, ip.device(), ip.Disc, ip.TotalDiscs)},
+		{offsetAreaSymbols, offsetPeripherals, string(ip.Regions[:])},
+		{offsetProductNumber, offsetProductVersion, ip.ProductNumber},
+		{offsetProductVersion, offsetReleaseDate, ip.ProductVersion},
+		{offsetReleaseDate, offsetBootFilename, ip.ReleaseDate.Format("20060102")},
+		{offsetBootFilename, offsetProducer, ip.BootFilename},
+		{offsetProducer, offsetSoftwareName, ip.Producer},
+		{offsetSoftwareName, offsetTOC, ip.SoftwareName},
+		{offsetRegionStrings + regionStringOffset, offsetRegionStrings + regionStringSize, ip.RegionStrings[RegionJapan]},
+		{offsetRegionStrings + regionStringSize + regionStringOffset, offsetRegionStrings + 2*regionStringSize, ip.RegionStrings[RegionUSA]},
+		{offsetRegionStrings + 2*regionStringSize + regionStringOffset, offsetRegionStrings + 3*regionStringSize, ip.RegionStrings[RegionEurope]},
+	} {
+		if err := putString(b[f.offset:f.end], f.s); err != nil {
+			return nil, err
+		}
+	}
+
+	// The peripherals have no meaning on NAOMI so the field is kept as is
+	if !ip.Hardware().IsNaomi() {
+		if err := putString(b[offsetPeripherals:offsetProductNumber], fmt.Sprintf("%07X", ip.Peripherals)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ip.TOC) > tocEntries {
+		return nil, errFieldTooLong
+	}
+
+	if len(ip.TOC) > 0 {
+		copy(b[offsetTOC:], tocMagic)
+		for i := 0; i < tocEntries; i++ {
+			entry := b[offsetTOCEntries+i*4 : offsetTOCEntries+4+i*4]
+			if i >= len(ip.TOC) {
+				binary.LittleEndian.PutUint32(entry, 0xffffffff)
+				continue
+			}
+			binary.LittleEndian.PutUint32(entry, uint32(ip.TOC[i].Start+pauseData))
+			entry[3] = byte(ip.TOC[i].Type)
+		}
+	}
+
+	return b, nil
+}
+
+// NewIPBin returns an IPBin for a new disc with the fields set to the
+// same defaults as makeip. The bootstrap is not distributed so must be
+// passed in, usually as the 32 KiB IP.TMPL file used by makeip
+func NewIPBin(bootstrap []byte) (*IPBin, error) {
+	if len(bootstrap) != ipBinLength {
+		return nil, errInvalidIPBin
+	}
+
+	ip := &IPBin{
+		bytes:          append([]byte{}, bootstrap...),
+		HardwareID:     defaultHardwareID,
+		MakerID:        defaultMakerID,
+		Device:         defaultDevice,
+		Disc:           1,
+		TotalDiscs:     1,
+		Peripherals:    defaultPeripherals,
+		ProductNumber:  defaultProductNumber,
+		ProductVersion: defaultProductVersion,
+		ReleaseDate:    time.Now().UTC().Truncate(24 * time.Hour),
+		BootFilename:   defaultBootFilename,
+		RegionStrings:  regionStrings,
+	}
+	copy(ip.Regions[:], "JUE     ")
+	ip.UpdateCRC()
+
+	return ip, nil
+}
+
+// SetRegions sets the permitted regions, updating both the area symbols
+// and region strings
+func (ip *IPBin) SetRegions(regions ...int) {
+	for i := range ip.RegionStrings {
+		ip.Regions[i], ip.RegionStrings[i] = ' ', ""
+	}
+	for _, i := range regions {
+		if i >= 0 && i < numRegions {
+			ip.Regions[i], ip.RegionStrings[i] = regionSymbols[i], regionStrings[i]
+		}
+	}
+}
+
+// VerifyRegions returns an error if the region strings don't match the
+// permitted regions. The BIOS checks both so a disc with a mismatch may
+// not boot. NAOMI discs are not checked
+func (ip IPBin) VerifyRegions() error {
+	// The NAOMI BIOS has its own region settings
+	if ip.Hardware().IsNaomi() {
+		return nil
+	}
+
+	for i, s := range ip.RegionStrings {
+		expected := ""
+		if ip.Regions.IsRegion(i) {
+			expected = regionStrings[i]
+		}
+		if s != expected {
+			return fmt.Errorf("%w: %s string is %q, expected %q", errRegion, regionNames[i], s, expected)
+		}
+	}
+	return nil
+}
+
+// Logo returns the MR image shown under the Sega license screen
+func (ip IPBin) Logo() (image.Image, error) {
+	if len(ip.bytes) != ipBinLength || !bytes.HasPrefix(ip.bytes[offsetLogo:], []byte("MR")) {
+		return nil, errNoLogo
+	}
+	return mr.Decode(bytes.NewReader(ip.bytes[offsetLogo : offsetLogo+mr.MaxSize]))
+}
+
+// SetLogo replaces the MR image shown under the Sega license screen, the
+// image is subject to the same limits as mr.Encode. Passing nil removes
+// the image
+func (ip *IPBin) SetLogo(m image.Image) error {
+	b := new(bytes.Buffer)
+	if m != nil {
+		if err := mr.Encode(b, m); err != nil {
+			return err
+		}
+	}
+
+	// Don't modify the bytes that were unmarshalled
+	c := make([]byte, ipBinLength)
+	copy(c, ip.bytes)
+	copy(c[offsetLogo:offsetLogo+mr.MaxSize], make([]byte, mr.MaxSize))
+	copy(c[offsetLogo:], b.Bytes())
+	ip.bytes = c
 
 	return nil
 }
@@ -198,6 +778,137 @@ func (ip IPBin) String() string {
 	return hex.Dump(ip.bytes)
 }
 
+const releaseDateFormat = "2006-01-02"
+
+// ipBinJSON is the JSON form of the IP.BIN fields
+type ipBinJSON struct {
+	HardwareID     string
+	MakerID        string
+	CRC            string
+	Device         string
+	Disc           int
+	TotalDiscs     int
+	Regions        Region
+	Peripherals    []Peripheral
+	ProductNumber  string
+	ProductVersion string
+	ReleaseDate    string
+	BootFilename   string
+	Producer       string
+	SoftwareName   string
+	TOC            []Track
+	RegionStrings  [numRegions]string
+}
+
+// MarshalJSON encodes the IP.BIN fields as JSON. The regions and
+// peripherals are encoded as lists of names, the CRC as hexadecimal and
+// the release date as YYYY-MM-DD
+func (ip IPBin) MarshalJSON() ([]byte, error) {
+	peripherals := PeripheralsOf(ip.Peripherals)
+	if peripherals == nil {
+		peripherals = []Peripheral{}
+	}
+
+	return json.Marshal(ipBinJSON{
+		HardwareID:     ip.HardwareID,
+		MakerID:        ip.MakerID,
+		CRC:            fmt.Sprintf("%04X", ip.CRC),
+		Device:         ip.device(),
+		Disc:           ip.Disc,
+		TotalDiscs:     ip.TotalDiscs,
+		Regions:        ip.Regions,
+		Peripherals:    peripherals,
+		ProductNumber:  ip.ProductNumber,
+		ProductVersion: ip.ProductVersion,
+		ReleaseDate:    ip.ReleaseDate.Format(releaseDateFormat),
+		BootFilename:   ip.BootFilename,
+		Producer:       ip.Producer,
+		SoftwareName:   ip.SoftwareName,
+		TOC:            ip.TOC,
+		RegionStrings:  ip.RegionStrings,
+	})
+}
+
+// UnmarshalJSON decodes the IP.BIN fields from JSON. The bootstrap isn't
+// included so is kept from any IP.BIN previously unmarshalled or passed to
+// NewIPBin
+func (ip *IPBin) UnmarshalJSON(b []byte) error {
+	var j ipBinJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	crc, err := strconv.ParseUint(j.CRC, 16, 16)
+	if err != nil {
+		return err
+	}
+
+	date, err := time.Parse(releaseDateFormat, j.ReleaseDate)
+	if err != nil {
+		return err
+	}
+
+	ip.HardwareID = j.HardwareID
+	ip.MakerID = j.MakerID
+	ip.CRC = uint16(crc)
+	ip.Device = j.Device
+	ip.Disc = j.Disc
+	ip.TotalDiscs = j.TotalDiscs
+	ip.Regions = j.Regions
+	ip.Peripherals = PeripheralMask(j.Peripherals)
+	ip.ProductNumber = j.ProductNumber
+	ip.ProductVersion = j.ProductVersion
+	ip.ReleaseDate = date
+	ip.BootFilename = j.BootFilename
+	ip.Producer = j.Producer
+	ip.SoftwareName = j.SoftwareName
+	ip.TOC = j.TOC
+	ip.RegionStrings = j.RegionStrings
+
+	return nil
+}
+
+// MarshalText encodes the IP.BIN fields as human-readable text, one field
+// per line
+func (ip IPBin) MarshalText() ([]byte, error) {
+	b := new(bytes.Buffer)
+
+	var names []string
+	for _, p := range PeripheralsOf(ip.Peripherals) {
+		names = append(names, p.String())
+	}
+
+	for _, f := range []struct {
+		name, value string
+	}{
+		{"Hardware ID", ip.HardwareID},
+		{"Maker ID", ip.MakerID},
+		{"CRC", fmt.Sprintf("%04X", ip.CRC)},
+		{"Disc", fmt.Sprintf("%s %d/%d", ip.device(), ip.Disc, ip.TotalDiscs)},
+		{"Regions", strings.Join(ip.Regions.Names(), ", ")},
+		{"Peripherals", strings.Join(names, ", ")},
+		{"Product Number", ip.ProductNumber},
+		{"Product Version", ip.ProductVersion},
+		{"Release Date", ip.ReleaseDate.Format(releaseDateFormat)},
+		{"Boot Filename", ip.BootFilename},
+		{"Producer", ip.Producer},
+		{"Software Name", ip.SoftwareName},
+	} {
+		fmt.Fprintf(b, "%-16s %s\n", f.name+":", f.value)
+	}
+
+	// The TOC starts with the third track
+	for i, t := range ip.TOC {
+		kind := "Audio"
+		if t.IsDataTrack() {
+			kind = "Data"
+		}
+		fmt.Fprintf(b, "%-16s %s, start %d, length %d\n", fmt.Sprintf("Track %d:", i+3), kind, t.Start, t.Length)
+	}
+
+	return b.Bytes(), nil
+}
+
 // Track represents a TOC entry found in the IP.BIN initial program
 type Track struct {
 	// Start refers to the first sector of the track
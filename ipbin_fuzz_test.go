@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package dreamcast
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if err != nil {
+		f.Fatal(err)
+	}
+	ip.SoftwareName = "FUZZ"
+	ip.TOC = []Track{
+		{Start: 45000, Type: typeData},
+		{Start: 50000, Type: typeAudio},
+		{Start: 60000, Type: typeData},
+	}
+
+	m := image.NewPaletted(image.Rect(0, 0, 16, 16), color.Palette{color.Black, color.White})
+	for i := range m.Pix {
+		m.Pix[i] = byte(i / 3 % 2)
+	}
+	if err := ip.SetLogo(m); err != nil {
+		f.Fatal(err)
+	}
+
+	b, err := ip.MarshalBinary()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b)
+
+	// The same IP.BIN but with broken fields and no TOC
+	c := append([]byte{}, b...)
+	copy(c[offsetDeviceInformation:], "XXXX GD-ROMX/X")
+	copy(c[offsetPeripherals:], "XXXXXXX")
+	copy(c[offsetReleaseDate:], "XXXXXXXX")
+	copy(c[offsetTOC:offsetRegionStrings], make([]byte, offsetRegionStrings-offsetTOC))
+	f.Add(c)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ip := new(IPBin)
+		strict := ip.UnmarshalBinary(b)
+
+		errs, err := new(IPBin).UnmarshalLenient(b)
+		if err != nil {
+			assert.Equal(t, errInvalidIPBin, err)
+			assert.Equal(t, errInvalidIPBin, strict)
+			return
+		}
+
+		// The strict parse fails if and only if the lenient parse
+		// finds a field it can't decode
+		assert.Equal(t, strict == nil, len(errs.Errors()) == 0)
+		if strict != nil {
+			return
+		}
+
+		_, _ = ip.Logo()
+
+		if b, err := ip.MarshalBinary(); err == nil {
+			_, err = new(IPBin).UnmarshalLenient(b)
+			assert.Nil(t, err)
+		}
+	})
+}
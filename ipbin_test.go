@@ -0,0 +1,683 @@
+package dreamcast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/bodgit/dreamcast/mr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPBinJSON(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.SetRegions(RegionUSA, RegionEurope)
+	ip.Peripherals = uint32(PeripheralVibrationPack | PeripheralKeyboard | 1<<1)
+	ip.TOC = []Track{{Start: 45000, Length: 4700, Type: typeData}}
+
+	b, err := json.Marshal(ip)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var j map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &j))
+	assert.Equal(t, []interface{}{"0x2", "VibrationPack", "Keyboard"}, j["Peripherals"])
+	assert.Equal(t, []interface{}{"USA", "Europe"}, j["Regions"])
+
+	c := &IPBin{bytes: ip.bytes}
+	assert.Nil(t, json.Unmarshal(b, c))
+	assert.Equal(t, ip, c)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"Regions":["Mars"]}`), c))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"Peripherals":["Joystick"]}`), c))
+}
+
+func TestIPBinDevice(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.Device = "CD-ROM"
+
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "CD-ROM1/1", string(b[offsetDeviceInformation+5:offsetDeviceInformation+14]))
+
+	c := new(IPBin)
+	assert.Nil(t, c.UnmarshalBinary(b))
+	assert.Equal(t, "CD-ROM", c.Device)
+	assert.Equal(t, 1, c.Disc)
+}
+
+func TestIPBinNaomi(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.HardwareID = "SEGA NAOMI2"
+	ip.RegionStrings = [numRegions]string{}
+
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	copy(b[offsetPeripherals:], "NAOMI2  ")
+
+	// Neither the peripherals nor the region strings are checked
+	c := new(IPBin)
+	errs, err := c.UnmarshalLenient(b)
+	assert.Nil(t, err)
+	assert.Nil(t, errs)
+	assert.Equal(t, HardwareNaomi2, c.Hardware())
+
+	// The peripherals are kept as is
+	d, err := c.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, b, d)
+}
+
+func TestPeripheral(t *testing.T) {
+	assert.Equal(t, "VibrationPack", PeripheralVibrationPack.String())
+	assert.Equal(t, []Peripheral{PeripheralVGABox, PeripheralMouse}, PeripheralsOf(uint32(PeripheralVGABox|PeripheralMouse)))
+	assert.Nil(t, PeripheralsOf(0))
+
+	var p Peripheral
+	assert.Nil(t, p.UnmarshalText([]byte("Gun")))
+	assert.Equal(t, PeripheralGun, p)
+}
+
+func TestIPBinRoundTrip(t *testing.T) {
+	// Fill the bootstrap, which isn't represented by any field, with a
+	// pattern so it's obvious if any of it is lost
+	b := make([]byte, ipBinLength)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	copy(b, fmt.Sprintf("%-16s%-16s%-16s%-8s%-8s%-10s%-6s%-16s%-16s%-16s%-128s",
+		"SEGA SEGAKATANA", "SEGA ENTERPRISES", "1A2B GD-ROM1/2", "JUE", "E000F10", "MK-51000", "V1.005", "19990909",
+		"1ST_READ.BIN", "SEGA ENTERPRISES", "SONIC ADVENTURE"))
+
+	copy(b[offsetTOC:], tocMagic)
+	for i := 0; i < tocEntries; i++ {
+		binary.LittleEndian.PutUint32(b[offsetTOCEntries+i*4:], 0xffffffff)
+	}
+	for i, entry := range []uint32{45150 | typeData<<24, 50000 | typeAudio<<24, 60000 | typeData<<24} {
+		binary.LittleEndian.PutUint32(b[offsetTOCEntries+i*4:], entry)
+	}
+
+	for i, s := range regionStrings {
+		copy(b[offsetRegionStrings+i*regionStringSize+regionStringOffset:], fmt.Sprintf("%-*s", regionStringSize-regionStringOffset, s))
+	}
+
+	ip := new(IPBin)
+	if !assert.Nil(t, ip.UnmarshalBinary(b)) {
+		return
+	}
+
+	assert.Equal(t, "SEGA SEGAKATANA", ip.HardwareID)
+	assert.Equal(t, "SEGA ENTERPRISES", ip.MakerID)
+	assert.Equal(t, uint16(0x1a2b), ip.CRC)
+	assert.Equal(t, "GD-ROM", ip.Device)
+	assert.Equal(t, 1, ip.Disc)
+	assert.Equal(t, 2, ip.TotalDiscs)
+	assert.Equal(t, []string{"Japan", "USA", "Europe"}, ip.Regions.Names())
+	assert.Equal(t, uint32(0xe000f10), ip.Peripherals)
+	assert.Equal(t, "MK-51000", ip.ProductNumber)
+	assert.Equal(t, "V1.005", ip.ProductVersion)
+	assert.Equal(t, time.Date(1999, time.September, 9, 0, 0, 0, 0, time.UTC), ip.ReleaseDate)
+	assert.Equal(t, "1ST_READ.BIN", ip.BootFilename)
+	assert.Equal(t, "SEGA ENTERPRISES", ip.Producer)
+	assert.Equal(t, "SONIC ADVENTURE", ip.SoftwareName)
+	assert.Equal(t, []Track{
+		{Start: 45000, Length: 4700, Type: typeData},
+		{Start: 49850, Length: 9850, Type: typeAudio},
+		{Start: 59850, Length: lastSector - 59850 - pauseData, Type: typeData},
+	}, ip.TOC)
+	assert.Equal(t, regionStrings, ip.RegionStrings)
+
+	// Marshalling it again gives back exactly the same bytes
+	c, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, b, c)
+}
+
+func TestNewIPBin(t *testing.T) {
+	_, err := NewIPBin(make([]byte, ipBinLength-1))
+	assert.Equal(t, errInvalidIPBin, err)
+
+	bootstrap := make([]byte, ipBinLength)
+	for i := range bootstrap {
+		bootstrap[i] = byte(i * 7)
+	}
+
+	ip, err := NewIPBin(bootstrap)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// The same defaults as makeip
+	assert.Equal(t, "SEGA SEGAKATANA", ip.HardwareID)
+	assert.Equal(t, "SEGA ENTERPRISES", ip.MakerID)
+	assert.Equal(t, "GD-ROM", ip.Device)
+	assert.Equal(t, 1, ip.Disc)
+	assert.Equal(t, 1, ip.TotalDiscs)
+	assert.Equal(t, []string{"Japan", "USA", "Europe"}, ip.Regions.Names())
+	assert.Equal(t, uint32(0xe000f10), ip.Peripherals)
+	assert.Equal(t, "T0000", ip.ProductNumber)
+	assert.Equal(t, "V1.000", ip.ProductVersion)
+	assert.Equal(t, "1ST_READ.BIN", ip.BootFilename)
+	assert.Equal(t, "", ip.Producer)
+	assert.Equal(t, "", ip.SoftwareName)
+	assert.Nil(t, ip.TOC)
+	assert.Equal(t, regionStrings, ip.RegionStrings)
+	assert.Nil(t, ip.VerifyCRC())
+	assert.Nil(t, ip.VerifyRegions())
+
+	// The release date is today, with no time
+	now := time.Now().UTC()
+	assert.Equal(t, time.UTC, ip.ReleaseDate.Location())
+	assert.True(t, ip.ReleaseDate.Equal(now.Truncate(24*time.Hour)) || ip.ReleaseDate.Equal(now.Truncate(24*time.Hour).Add(-24*time.Hour)))
+
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, fmt.Sprintf("SEGA SEGAKATANA SEGA ENTERPRISES%04X GD-ROM1/1  JUE     E000F10 T0000     V1.000%s        1ST_READ.BIN    ", ip.CRC, ip.ReleaseDate.Format("20060102")), string(b[:offsetProducer]))
+
+	// The bootstrap is kept as is apart from the fields and the bootstrap
+	// passed in isn't modified
+	assert.Equal(t, bootstrap[offsetTOC:offsetRegionStrings], b[offsetTOC:offsetRegionStrings])
+	assert.Equal(t, bootstrap[offsetRegionStrings+3*regionStringSize:], b[offsetRegionStrings+3*regionStringSize:])
+	for i := range bootstrap {
+		if bootstrap[i] != byte(i*7) {
+			t.Errorf("bootstrap modified at %#x", i)
+			break
+		}
+	}
+}
+
+func TestIPBinCRC(t *testing.T) {
+	// The BIOS uses CRC-16/CCITT-FALSE, check it against the published
+	// check value for that algorithm
+	assert.Equal(t, uint16(0x29b1), crc([]byte("123456789")))
+
+	tables := []struct {
+		productNumber, productVersion string
+		crc                           uint16
+	}{
+		{"T0000", "V1.000", 0xb6d8},
+		{"MK-51000", "V1.005", 0x3b97},
+		{"MK-51000", "V1.000", 0x6b32},
+		{"HDR-0001", "V1.000", 0x0244},
+	}
+
+	for _, table := range tables {
+		ip := &IPBin{
+			ProductNumber:  table.productNumber,
+			ProductVersion: table.productVersion,
+			CRC:            table.crc ^ 0xffff,
+		}
+
+		// The CRC is computed over the fields padded with spaces
+		assert.Equal(t, table.crc, ip.ComputeCRC())
+		assert.Equal(t, crc([]byte(fmt.Sprintf("%-10s%-6s", table.productNumber, table.productVersion))), ip.ComputeCRC())
+
+		err := ip.VerifyCRC()
+		assert.True(t, errors.Is(err, errInvalidCRC))
+		assert.Equal(t, fmt.Sprintf("invalid CRC: %04X, expected %04X", table.crc^0xffff, table.crc), err.Error())
+
+		ip.UpdateCRC()
+		assert.Equal(t, table.crc, ip.CRC)
+		assert.Nil(t, ip.VerifyCRC())
+	}
+
+	// The CRC is stored as four uppercase hex digits
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.ProductNumber, ip.ProductVersion = "MK-51000", "V1.005"
+	ip.UpdateCRC()
+
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "3B97", string(b[offsetDeviceInformation:offsetDeviceInformation+4]))
+
+	c := new(IPBin)
+	assert.Nil(t, c.UnmarshalBinary(b))
+	assert.Nil(t, c.VerifyCRC())
+}
+
+func TestIPBinRegions(t *testing.T) {
+	tables := []struct {
+		regions []int
+		symbols string
+		names   []string
+	}{
+		{nil, "        ", nil},
+		{[]int{RegionJapan}, "J       ", []string{"Japan"}},
+		{[]int{RegionUSA}, " U      ", []string{"USA"}},
+		{[]int{RegionEurope}, "  E     ", []string{"Europe"}},
+		{[]int{RegionJapan, RegionUSA}, "JU      ", []string{"Japan", "USA"}},
+		{[]int{RegionJapan, RegionEurope}, "J E     ", []string{"Japan", "Europe"}},
+		{[]int{RegionUSA, RegionEurope}, " UE     ", []string{"USA", "Europe"}},
+		{[]int{RegionEurope, RegionJapan, RegionUSA}, "JUE     ", []string{"Japan", "USA", "Europe"}},
+		// Unknown regions are ignored
+		{[]int{-1, RegionUSA, numRegions}, " U      ", []string{"USA"}},
+	}
+
+	for _, table := range tables {
+		ip, err := NewIPBin(make([]byte, ipBinLength))
+		if !assert.Nil(t, err) {
+			return
+		}
+		ip.SetRegions(table.regions...)
+
+		assert.Equal(t, table.symbols, ip.Regions.String())
+		assert.Equal(t, table.names, ip.Regions.Names())
+		assert.Nil(t, ip.VerifyRegions())
+
+		b, err := ip.MarshalBinary()
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, table.symbols, string(b[offsetAreaSymbols:offsetPeripherals]))
+
+		// Each permitted region has its string after the branch
+		// instruction at 0x3700, any other region is blank
+		for i := 0; i < numRegions; i++ {
+			expected := ""
+			if ip.Regions.IsRegion(i) {
+				expected = regionStrings[i]
+			}
+			assert.Equal(t, expected, ip.RegionStrings[i])
+
+			offset := offsetRegionStrings + i*regionStringSize
+			assert.Equal(t, make([]byte, regionStringOffset), b[offset:offset+regionStringOffset])
+			assert.Equal(t, fmt.Sprintf("%-*s", regionStringSize-regionStringOffset, expected), string(b[offset+regionStringOffset:offset+regionStringSize]))
+		}
+
+		c := new(IPBin)
+		if !assert.Nil(t, c.UnmarshalBinary(b)) {
+			continue
+		}
+		assert.Equal(t, ip.Regions, c.Regions)
+		assert.Equal(t, ip.RegionStrings, c.RegionStrings)
+		assert.Nil(t, c.VerifyRegions())
+	}
+
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.SetRegions(RegionJapan, RegionEurope)
+	assert.True(t, ip.Regions.IsRegionJapan())
+	assert.False(t, ip.Regions.IsRegionUSA())
+	assert.True(t, ip.Regions.IsRegionEurope())
+
+	// A string for a region that isn't permitted, a missing string for
+	// one that is, and a string that doesn't match are all mismatches
+	for _, s := range [][numRegions]string{
+		{regionStrings[RegionJapan], regionStrings[RegionUSA], regionStrings[RegionEurope]},
+		{regionStrings[RegionJapan], "", ""},
+		{regionStrings[RegionJapan], "", "For MARS."},
+	} {
+		ip.RegionStrings = s
+		assert.True(t, errors.Is(ip.VerifyRegions(), errRegion))
+	}
+
+	// NAOMI discs aren't checked
+	ip.HardwareID = naomiHardwareID
+	assert.Nil(t, ip.VerifyRegions())
+}
+
+func TestIPBinLogo(t *testing.T) {
+	bootstrap := make([]byte, ipBinLength)
+	for i := range bootstrap {
+		bootstrap[i] = byte(i * 7)
+	}
+
+	ip, err := NewIPBin(bootstrap)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = ip.Logo()
+	assert.Equal(t, errNoLogo, err)
+
+	m := image.NewRGBA(image.Rect(0, 0, mr.MaxWidth, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < mr.MaxWidth; x++ {
+			m.Set(x, y, color.RGBA{byte(x / 40), byte(y / 10), 0x80, 0xff})
+		}
+	}
+	if !assert.Nil(t, ip.SetLogo(m)) {
+		return
+	}
+
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "MR", string(b[offsetLogo:offsetLogo+2]))
+
+	// Only the space for the logo is changed
+	assert.Equal(t, bootstrap[offsetRegionStrings+3*regionStringSize:offsetLogo], b[offsetRegionStrings+3*regionStringSize:offsetLogo])
+	assert.Equal(t, bootstrap[offsetLogo+mr.MaxSize:], b[offsetLogo+mr.MaxSize:])
+
+	c := new(IPBin)
+	if !assert.Nil(t, c.UnmarshalBinary(b)) {
+		return
+	}
+
+	logo, err := c.Logo()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, m.Bounds(), logo.Bounds())
+	for y := 0; y < 40; y++ {
+		for x := 0; x < mr.MaxWidth; x++ {
+			assert.Equal(t, m.At(x, y), color.RGBAModel.Convert(logo.At(x, y)))
+		}
+	}
+
+	// A smaller logo doesn't leave any of the previous one behind
+	small := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}})
+	small.Pix = []byte{0, 1, 1, 0}
+	if !assert.Nil(t, c.SetLogo(small)) {
+		return
+	}
+	d, err := c.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	size := int(binary.LittleEndian.Uint32(d[offsetLogo+2:]))
+	assert.Equal(t, make([]byte, mr.MaxSize-size), d[offsetLogo+size:offsetLogo+mr.MaxSize])
+
+	// Setting the logo doesn't modify the bytes that were unmarshalled
+	assert.Equal(t, "MR", string(b[offsetLogo:offsetLogo+2]))
+	assert.NotEqual(t, b[offsetLogo:offsetLogo+mr.MaxSize], d[offsetLogo:offsetLogo+mr.MaxSize])
+
+	// Passing nil removes the logo
+	assert.Nil(t, c.SetLogo(nil))
+	_, err = c.Logo()
+	assert.Equal(t, errNoLogo, err)
+	d, err = c.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, make([]byte, mr.MaxSize), d[offsetLogo:offsetLogo+mr.MaxSize])
+}
+
+func TestIPBinLogoTooLarge(t *testing.T) {
+	// Every pixel differs from its neighbours so nothing compresses and
+	// the encoded image won't fit in the space at 0x3820
+	noisy := image.NewRGBA(image.Rect(0, 0, mr.MaxWidth, mr.MaxHeight))
+	for y := 0; y < mr.MaxHeight; y++ {
+		for x := 0; x < mr.MaxWidth; x++ {
+			noisy.Set(x, y, color.RGBA{byte((x + y) % 2), 0, 0, 0xff})
+		}
+	}
+
+	tables := []image.Image{
+		noisy,
+		image.NewRGBA(image.Rect(0, 0, mr.MaxWidth+1, 1)),
+		image.NewRGBA(image.Rect(0, 0, 1, mr.MaxHeight+1)),
+	}
+
+	for _, table := range tables {
+		ip, err := NewIPBin(make([]byte, ipBinLength))
+		if !assert.Nil(t, err) {
+			return
+		}
+		before, err := ip.MarshalBinary()
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// The IP.BIN is left as it was
+		assert.NotNil(t, ip.SetLogo(table))
+		after, err := ip.MarshalBinary()
+		assert.Nil(t, err)
+		assert.Equal(t, before, after)
+	}
+}
+
+func TestIPBinMalformedTOC(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	valid, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	entry := func(start, kind int) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(start+pauseData)|uint32(kind)<<24)
+		return b
+	}
+
+	tables := []struct {
+		toc  []byte
+		want []Track
+	}{
+		// No valid entries at all
+		{make([]byte, tocEntries*4), nil},
+		{bytes.Repeat([]byte{0xff}, tocEntries*4), nil},
+		{bytes.Repeat([]byte(" "), tocEntries*4), nil},
+		// The first invalid entry ends the TOC
+		{append(entry(45000, 0x02), entry(50000, typeData)...), nil},
+		{append(entry(45000, typeData), entry(50000, 0x02)...), []Track{{Start: 45000, Length: lastSector - 45000 - pauseData, Type: typeData}}},
+		// A start that's less than the pause
+		{entry(-pauseData, typeData), []Track{{Start: -pauseData, Length: lastSector, Type: typeData}}},
+	}
+
+	for _, table := range tables {
+		b := append([]byte{}, valid...)
+		copy(b[offsetTOCEntries:offsetTOCEntries+tocEntries*4], make([]byte, tocEntries*4))
+		copy(b[offsetTOCEntries:], table.toc)
+
+		c := new(IPBin)
+		if !assert.Nil(t, c.UnmarshalBinary(b)) {
+			continue
+		}
+		assert.Equal(t, table.want, c.TOC)
+
+		errs, err := new(IPBin).UnmarshalLenient(b)
+		assert.Nil(t, err)
+		assert.Nil(t, errs)
+
+		_, err = c.MarshalText()
+		assert.Nil(t, err)
+
+		// An empty TOC is kept as is
+		d, err := c.MarshalBinary()
+		assert.Nil(t, err)
+		if table.want == nil {
+			assert.Equal(t, b, d)
+		}
+	}
+
+	// Anything other than 32 KiB is rejected
+	for _, b := range [][]byte{nil, valid[:ipBinLength-1], append(valid, 0)} {
+		assert.Equal(t, errInvalidIPBin, new(IPBin).UnmarshalBinary(b))
+		_, err := new(IPBin).UnmarshalLenient(b)
+		assert.Equal(t, errInvalidIPBin, err)
+	}
+}
+
+func TestIPBinFieldErrors(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	ip.UpdateCRC()
+	ip.ReleaseDate = time.Date(1999, time.September, 9, 0, 0, 0, 0, time.UTC)
+
+	valid, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tables := []struct {
+		name   string
+		offset int
+		patch  string
+		err    *FieldError
+		check  func(*IPBin)
+	}{
+		{
+			"bad date",
+			offsetReleaseDate,
+			"1999XX09",
+			&FieldError{Field: "ReleaseDate", Offset: offsetReleaseDate, Value: "1999XX09        "},
+			func(c *IPBin) {
+				assert.True(t, c.ReleaseDate.IsZero())
+			},
+		},
+		{
+			"non-hex peripherals",
+			offsetPeripherals,
+			"E0G0F10",
+			&FieldError{Field: "Peripherals", Offset: offsetPeripherals, Value: "E0G0F10", Err: hex.InvalidByteError('G')},
+			func(c *IPBin) {
+				assert.Equal(t, uint32(0), c.Peripherals)
+			},
+		},
+		{
+			"malformed GD-ROM field",
+			offsetDeviceInformation + 5,
+			"GD-ROMX/1",
+			&FieldError{Field: "Disc", Offset: offsetDeviceInformation + 5, Value: "GD-ROMX/1  "},
+			func(c *IPBin) {
+				assert.Equal(t, "GD-ROM", c.Device)
+				assert.Equal(t, 0, c.Disc)
+				assert.Equal(t, 0, c.TotalDiscs)
+			},
+		},
+		{
+			"non-hex CRC",
+			offsetDeviceInformation,
+			"XXXX",
+			&FieldError{Field: "CRC", Offset: offsetDeviceInformation, Value: "XXXX", Err: hex.InvalidByteError('X')},
+			func(c *IPBin) {
+				assert.Equal(t, uint16(0), c.CRC)
+			},
+		},
+		{
+			"CRC mismatch",
+			offsetDeviceInformation,
+			fmt.Sprintf("%04X", ip.CRC^1),
+			&FieldError{Field: "CRC", Offset: offsetDeviceInformation, Value: fmt.Sprintf("%04X", ip.CRC^1), Warning: true, Err: errInvalidCRC},
+			func(c *IPBin) {
+				assert.Equal(t, ip.CRC^1, c.CRC)
+			},
+		},
+		{
+			"wrong region string",
+			offsetRegionStrings + regionStringSize + regionStringOffset,
+			fmt.Sprintf("%-*s", regionStringSize-regionStringOffset, "For MARS."),
+			&FieldError{Field: "RegionStrings", Offset: offsetRegionStrings, Value: "JUE     ", Warning: true, Err: errRegion},
+			func(c *IPBin) {
+				assert.Equal(t, "For MARS.", c.RegionStrings[RegionUSA])
+			},
+		},
+		{
+			"region string without area symbol",
+			offsetAreaSymbols,
+			"J E     ",
+			&FieldError{Field: "RegionStrings", Offset: offsetRegionStrings, Value: "J E     ", Warning: true, Err: errRegion},
+			func(c *IPBin) {
+				assert.False(t, c.Regions.IsRegionUSA())
+				assert.Equal(t, regionStrings[RegionUSA], c.RegionStrings[RegionUSA])
+			},
+		},
+	}
+
+	for _, table := range tables {
+		func() {
+			b := append([]byte{}, valid...)
+			copy(b[table.offset:], table.patch)
+
+			// The error matches the field and the value left in
+			// the field is checked
+			check := func(errs FieldErrors, c *IPBin) {
+				if !assert.Len(t, errs, 1, table.name) {
+					return
+				}
+				assert.Equal(t, table.err.Field, errs[0].Field, table.name)
+				assert.Equal(t, table.err.Offset, errs[0].Offset)
+				assert.Equal(t, table.err.Value, errs[0].Value)
+				assert.Equal(t, table.err.Warning, errs[0].Warning)
+				if table.err.Err != nil {
+					assert.True(t, errors.Is(errs[0], table.err.Err), errs[0])
+				} else {
+					assert.NotNil(t, errs[0].Err)
+				}
+				table.check(c)
+			}
+
+			c := new(IPBin)
+			errs, err := c.UnmarshalLenient(b)
+			assert.Nil(t, err)
+			check(errs, c)
+
+			// Warnings aren't found by unmarshal and don't stop
+			// UnmarshalBinary
+			c = new(IPBin)
+			errs = c.unmarshal(b)
+			err = new(IPBin).UnmarshalBinary(b)
+			if table.err.Warning {
+				assert.Len(t, errs, 0)
+				assert.Nil(t, err)
+			} else {
+				check(errs, c)
+				assert.Equal(t, errs[0], err)
+			}
+
+			// A game reads the same errors when lenient
+			dir := t.TempDir()
+			writeGame(t, dir, b)
+			reader, err := NewDirectoryReader(dir)
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer reader.Close()
+
+			g, err := NewLenientGame(reader)
+			if assert.Nil(t, err) {
+				check(g.IPBinErrors, g.IPBin)
+			}
+
+			_, err = NewGame(reader)
+			if table.err.Warning {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		}()
+	}
+
+	// The valid IP.BIN has no errors at all
+	errs, err := new(IPBin).UnmarshalLenient(valid)
+	assert.Nil(t, err)
+	assert.Nil(t, errs)
+}
@@ -0,0 +1,385 @@
+/*
+Package iso9660 implements read-only access to an ISO 9660 filesystem as
+an fs.FS. Only the primary volume descriptor is used so any Joliet or Rock
+Ridge extensions are ignored. It can also master the structures of a new
+filesystem with a Volume.
+*/
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BlockSize is the size of each logical block
+	BlockSize = 2048
+	// SystemArea is the number of blocks reserved at the start of the
+	// volume before the volume descriptors
+	SystemArea = 16
+)
+
+const (
+	typePrimary    = 1
+	typeTerminator = 255
+
+	offsetRootRecord = 156
+	minRecordSize    = 33
+	maxDirSize       = 1 << 24
+
+	flagDirectory = 0x02
+)
+
+var standardID = []byte("CD001")
+
+var (
+	errNoPrimaryVolume = errors.New("no primary volume descriptor")
+	errInvalidRecord   = errors.New("invalid directory record")
+	errBlockSize       = errors.New("unsupported logical block size")
+)
+
+// FS is an ISO 9660 filesystem. It implements the fs.FS, fs.ReadDirFS and
+// fs.StatFS interfaces.
+type FS struct {
+	r    io.ReaderAt
+	root *record
+	// VolumeID is the volume identifier from the primary volume
+	// descriptor
+	VolumeID string
+}
+
+// record is a decoded directory record
+type record struct {
+	name    string
+	extent  int64
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// New returns an FS reading the filesystem from r, which is addressed in
+// 2048 byte logical blocks by their absolute LBA. start is the LBA of the
+// beginning of the volume, the volume descriptors are found 16 blocks
+// after it. The directory records are expected to use absolute LBAs, as
+// is the case for a multi-session CD or the high density area of a GD-ROM
+func New(r io.ReaderAt, start int64) (*FS, error) {
+	b := make([]byte, BlockSize)
+	for lba := start + SystemArea; ; lba++ {
+		if _, err := r.ReadAt(b, lba*BlockSize); err != nil {
+			if err == io.EOF {
+				return nil, errNoPrimaryVolume
+			}
+			return nil, err
+		}
+
+		if !bytes.Equal(b[1:6], standardID) || b[0] == typeTerminator {
+			return nil, errNoPrimaryVolume
+		}
+
+		if b[0] != typePrimary {
+			continue
+		}
+
+		if binary.LittleEndian.Uint16(b[128:]) != BlockSize {
+			return nil, errBlockSize
+		}
+
+		root, _, err := parseRecord(b[offsetRootRecord:])
+		if err != nil {
+			return nil, err
+		}
+		root.name = "."
+
+		return &FS{
+			r:        r,
+			root:     root,
+			VolumeID: strings.TrimRight(string(b[40:72]), " "),
+		}, nil
+	}
+}
+
+// parseRecord decodes the directory record at the start of b, returning
+// the size of the record
+func parseRecord(b []byte) (*record, int, error) {
+	if len(b) < minRecordSize {
+		return nil, 0, errInvalidRecord
+	}
+
+	n, nameLen := int(b[0]), int(b[32])
+	if n < minRecordSize+nameLen || n > len(b) {
+		return nil, 0, errInvalidRecord
+	}
+
+	r := &record{
+		extent:  int64(binary.LittleEndian.Uint32(b[2:])),
+		size:    int64(binary.LittleEndian.Uint32(b[10:])),
+		modTime: recordingTime(b[18:25]),
+		dir:     b[25]&flagDirectory != 0,
+	}
+
+	// Files have a version suffix, and an extension separator even if
+	// there's no extension
+	name := string(b[33 : 33+nameLen])
+	if i := strings.IndexByte(name, ';'); i >= 0 && !r.dir {
+		name = name[:i]
+	}
+	if !r.dir {
+		name = strings.TrimSuffix(name, ".")
+	}
+	r.name = name
+
+	return r, n, nil
+}
+
+// recordingTime decodes the seven byte date and time of a directory
+// record. The last byte is the offset from GMT in 15 minute intervals
+func recordingTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 && b[2] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// readDir returns the records of the directory, excluding the entries for
+// itself and its parent
+func (f *FS) readDir(dir *record) ([]*record, error) {
+	if dir.size > maxDirSize {
+		return nil, errInvalidRecord
+	}
+
+	b := make([]byte, dir.size)
+	if _, err := f.r.ReadAt(b, dir.extent*BlockSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var records []*record
+	for offset := 0; offset < len(b); {
+		// Records don't cross a block boundary, so a zero length
+		// means skip to the next block
+		if b[offset] == 0 {
+			offset = (offset/BlockSize + 1) * BlockSize
+			continue
+		}
+
+		r, n, err := parseRecord(b[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset += n
+
+		if r.name == "\x00" || r.name == "\x01" {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].name < records[j].name
+	})
+
+	return records, nil
+}
+
+// lookup finds the record for the named file. Names are matched without
+// regard to case as ISO 9660 names are usually upper case
+func (f *FS) lookup(op, name string) (*record, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	r := f.root
+	if name == "." {
+		return r, nil
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if !r.dir {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		records, err := f.readDir(r)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		var found *record
+		for _, c := range records {
+			if strings.EqualFold(c.name, elem) {
+				found = c
+				break
+			}
+		}
+
+		if found == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		r = found
+	}
+
+	return r, nil
+}
+
+// Open opens the named file
+func (f *FS) Open(name string) (fs.File, error) {
+	r, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.dir {
+		return &dir{fs: f, record: r, name: path.Base(name)}, nil
+	}
+
+	return &file{
+		SectionReader: io.NewSectionReader(f.r, r.extent*BlockSize, r.size),
+		info:          fileInfo{r, path.Base(name)},
+	}, nil
+}
+
+// ReadDir reads the named directory and returns a list of directory
+// entries sorted by filename
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	r, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	records, err := f.readDir(r)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(records))
+	for _, c := range records {
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{c, c.name}))
+	}
+
+	return entries, nil
+}
+
+// Stat returns a fs.FileInfo describing the named file
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	r, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{r, path.Base(name)}, nil
+}
+
+// Extent returns the LBA of the first block and the size in bytes of the
+// named file
+func (f *FS) Extent(name string) (int64, int64, error) {
+	r, err := f.lookup("extent", name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.extent, r.size, nil
+}
+
+type fileInfo struct {
+	*record
+	name string
+}
+
+func (fi fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi fileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi fileInfo) Sys() interface{} {
+	return nil
+}
+
+type file struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+type dir struct {
+	fs      *FS
+	record  *record
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return fileInfo{d.record, d.name}, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		records, err := d.fs.readDir(d.record)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range records {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(fileInfo{c, c.name}))
+		}
+		d.read = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
@@ -0,0 +1,127 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// offsetReaderAt presents b as if it started at the given offset
+type offsetReaderAt struct {
+	b      []byte
+	offset int64
+}
+
+func (r offsetReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.offset {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return bytes.NewReader(r.b).ReadAt(p, off-r.offset)
+}
+
+type testRecord struct {
+	name   string
+	extent int
+	size   int
+	dir    bool
+}
+
+func putRecord(b []byte, r testRecord) int {
+	n := minRecordSize + len(r.name)
+	n += n % 2
+	b[0] = byte(n)
+	binary.LittleEndian.PutUint32(b[2:], uint32(r.extent))
+	binary.BigEndian.PutUint32(b[6:], uint32(r.extent))
+	binary.LittleEndian.PutUint32(b[10:], uint32(r.size))
+	binary.BigEndian.PutUint32(b[14:], uint32(r.size))
+	copy(b[18:], []byte{99, 9, 9, 12, 0, 0, 36})
+	if r.dir {
+		b[25] = flagDirectory
+	}
+	b[32] = byte(len(r.name))
+	copy(b[33:], r.name)
+	return n
+}
+
+func putDir(b []byte, self, parent int, records ...testRecord) {
+	offset := putRecord(b, testRecord{"\x00", self, BlockSize, true})
+	offset += putRecord(b[offset:], testRecord{"\x01", parent, BlockSize, true})
+	for _, r := range records {
+		offset += putRecord(b[offset:], r)
+	}
+}
+
+var testData = bytes.Repeat([]byte("DATA"), BlockSize/4+3)[:BlockSize+10]
+
+// testImage returns a small volume starting at the passed LBA
+func testImage(start int) []byte {
+	b := make([]byte, 24*BlockSize)
+	block := func(i int) []byte {
+		return b[i*BlockSize : (i+1)*BlockSize]
+	}
+
+	pvd := block(16)
+	pvd[0] = typePrimary
+	copy(pvd[1:], standardID)
+	copy(pvd[40:72], "TEST VOLUME                     ")
+	binary.LittleEndian.PutUint16(pvd[128:], BlockSize)
+	putRecord(pvd[offsetRootRecord:], testRecord{"\x00", start + 19, BlockSize, true})
+
+	term := block(17)
+	term[0] = typeTerminator
+	copy(term[1:], standardID)
+
+	putDir(block(19), start+19, start+19,
+		testRecord{"1ST_READ.BIN;1", start + 21, 5, false},
+		testRecord{"DIR", start + 20, BlockSize, true},
+		testRecord{"NOEXT.;1", start + 21, 0, false},
+	)
+	putDir(block(20), start+20, start+19,
+		testRecord{"DATA.TXT;1", start + 22, BlockSize + 10, false},
+	)
+
+	copy(block(21), "HELLO")
+	copy(b[22*BlockSize:], testData)
+
+	return b
+}
+
+func TestFS(t *testing.T) {
+	fsys, err := New(offsetReaderAt{testImage(45000), 45000 * BlockSize}, 45000)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "TEST VOLUME", fsys.VolumeID)
+	assert.Nil(t, fstest.TestFS(fsys, "1ST_READ.BIN", "DIR/DATA.TXT", "NOEXT"))
+
+	b, err := fs.ReadFile(fsys, "1st_read.bin")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("HELLO"), b)
+
+	b, err = fs.ReadFile(fsys, "DIR/DATA.TXT")
+	assert.Nil(t, err)
+	assert.Equal(t, testData, b)
+
+	extent, size, err := fsys.Extent("DIR/DATA.TXT")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(45022), extent)
+	assert.Equal(t, int64(BlockSize+10), size)
+
+	_, err = fsys.Open("MISSING")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(bytes.NewReader(make([]byte, 20*BlockSize)), 0)
+	assert.Equal(t, errNoPrimaryVolume, err)
+
+	_, err = New(bytes.NewReader(nil), 0)
+	assert.Equal(t, errNoPrimaryVolume, err)
+}
@@ -0,0 +1,335 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	descriptorBlocks = 2 // The primary volume descriptor and terminator
+	rootRecordSize   = 34
+)
+
+var (
+	errExists       = errors.New("file exists")
+	errNoParent     = errors.New("parent directory doesn't exist")
+	errNotFile      = errors.New("not a file")
+	errInvalidName  = errors.New("invalid name")
+	errNotPlaced    = errors.New("file has no extent")
+	errVolumeLocked = errors.New("volume laid out")
+)
+
+// entry is a file or directory added to a Volume
+type entry struct {
+	name     string // The identifier recorded on the disc
+	modTime  time.Time
+	dir      bool
+	size     int64
+	extent   int64
+	parent   *entry
+	children []*entry
+	number   int // The position in the path table of a directory
+}
+
+func (e *entry) blocks() int64 {
+	return (e.size + BlockSize - 1) / BlockSize
+}
+
+// Volume masters the volume descriptors, path tables and directories of
+// an ISO 9660 filesystem. Directories and files are added, which fixes how
+// many blocks the filesystem structures need, then each file is given an
+// extent before the structures are marshalled. The data of each file is
+// written by the caller.
+type Volume struct {
+	// ID is the volume identifier
+	ID string
+	// ModTime is used for the creation and modification times of the
+	// volume
+	ModTime time.Time
+
+	start   int64
+	root    *entry
+	entries map[string]*entry
+	dirs    []*entry
+	blocks  int64
+	ptSize  int
+}
+
+// NewVolume returns a Volume with the volume descriptors starting 16
+// blocks after the LBA start, directory records use absolute LBAs
+func NewVolume(start int64) *Volume {
+	root := &entry{name: "\x00", dir: true}
+	return &Volume{
+		start:   start,
+		root:    root,
+		entries: map[string]*entry{".": root},
+	}
+}
+
+func (v *Volume) add(name string, e *entry) error {
+	if v.blocks != 0 {
+		return errVolumeLocked
+	}
+
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("%w: %q", errInvalidName, name)
+	}
+
+	if _, ok := v.entries[name]; ok {
+		return fmt.Errorf("%w: %q", errExists, name)
+	}
+
+	parent, ok := v.entries[path.Dir(name)]
+	if !ok || !parent.dir {
+		return fmt.Errorf("%w: %q", errNoParent, name)
+	}
+
+	e.parent = parent
+	parent.children = append(parent.children, e)
+	v.entries[name] = e
+
+	return nil
+}
+
+// AddDir adds the named directory, the parent directory must already have
+// been added
+func (v *Volume) AddDir(name string, modTime time.Time) error {
+	return v.add(name, &entry{
+		name:    strings.ToUpper(path.Base(name)),
+		modTime: modTime,
+		dir:     true,
+	})
+}
+
+// AddFile adds the named file of the given size, the parent directory
+// must already have been added
+func (v *Volume) AddFile(name string, size int64, modTime time.Time) error {
+	id := strings.ToUpper(path.Base(name))
+	if !strings.Contains(id, ".") {
+		id += "."
+	}
+
+	return v.add(name, &entry{
+		name:    id + ";1",
+		modTime: modTime,
+		size:    size,
+	})
+}
+
+// recordSize returns the size of a directory record with the identifier
+func recordSize(name string) int {
+	n := minRecordSize + len(name)
+	return n + n%2
+}
+
+// layout sorts the directories and fixes the extent of each directory
+func (v *Volume) layout() {
+	// The path table lists the directories breadth first, sorted by
+	// parent and then by name
+	v.dirs = []*entry{v.root}
+	for i := 0; i < len(v.dirs); i++ {
+		dir := v.dirs[i]
+		dir.number = i + 1
+
+		sort.Slice(dir.children, func(a, b int) bool {
+			return dir.children[a].name < dir.children[b].name
+		})
+
+		for _, c := range dir.children {
+			if c.dir {
+				v.dirs = append(v.dirs, c)
+			}
+		}
+	}
+
+	for _, dir := range v.dirs {
+		v.ptSize += 8 + len(dir.name) + len(dir.name)%2
+	}
+
+	// After the system area and volume descriptors come the two path
+	// tables followed by each directory
+	lba := v.start + SystemArea + descriptorBlocks + 2*int64((v.ptSize+BlockSize-1)/BlockSize)
+	for _, dir := range v.dirs {
+		// Records may not cross a block boundary
+		size := recordSize("\x00") + recordSize("\x01")
+		for _, c := range dir.children {
+			n := recordSize(c.name)
+			if size%BlockSize+n > BlockSize {
+				size += BlockSize - size%BlockSize
+			}
+			size += n
+		}
+
+		dir.extent = lba
+		dir.size = int64((size + BlockSize - 1) / BlockSize * BlockSize)
+		lba += dir.blocks()
+	}
+
+	v.blocks = lba - v.start
+}
+
+// Blocks returns the number of blocks used from the start of the volume up
+// to the end of the last directory, this includes the system area. No
+// more directories or files can be added once called
+func (v *Volume) Blocks() int64 {
+	if v.blocks == 0 {
+		v.layout()
+	}
+	return v.blocks
+}
+
+// SetExtent sets the LBA of the first block of the named file
+func (v *Volume) SetExtent(name string, lba int64) error {
+	e, ok := v.entries[name]
+	if !ok || e.dir {
+		return fmt.Errorf("%w: %q", errNotFile, name)
+	}
+	e.extent = lba
+	return nil
+}
+
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func putText(b []byte, s string) {
+	copy(b, s+strings.Repeat(" ", len(b)))
+}
+
+// putRecordingTime encodes the seven byte date and time of a directory
+// record
+func putRecordingTime(b []byte, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	_, offset := t.Zone()
+	b[0], b[1], b[2] = byte(t.Year()-1900), byte(t.Month()), byte(t.Day())
+	b[3], b[4], b[5] = byte(t.Hour()), byte(t.Minute()), byte(t.Second())
+	b[6] = byte(int8(offset / (15 * 60)))
+}
+
+// putVolumeTime encodes the seventeen byte date and time of a volume
+// descriptor
+func putVolumeTime(b []byte, t time.Time) {
+	if t.IsZero() {
+		copy(b, "0000000000000000")
+		b[16] = 0
+		return
+	}
+	_, offset := t.Zone()
+	copy(b, fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1e7))
+	b[16] = byte(int8(offset / (15 * 60)))
+}
+
+func writeRecord(b []byte, name string, e *entry) int {
+	n := recordSize(name)
+	b[0] = byte(n)
+	putBoth32(b[2:], uint32(e.extent))
+	putBoth32(b[10:], uint32(e.size))
+	putRecordingTime(b[18:25], e.modTime)
+	if e.dir {
+		b[25] = flagDirectory
+	}
+	putBoth16(b[28:], 1)
+	b[32] = byte(len(name))
+	copy(b[33:], name)
+	return n
+}
+
+func (v *Volume) pathTable(order binary.ByteOrder) []byte {
+	b := make([]byte, 0, v.ptSize)
+	for _, dir := range v.dirs {
+		r := make([]byte, 8+len(dir.name)+len(dir.name)%2)
+		r[0] = byte(len(dir.name))
+		order.PutUint32(r[2:], uint32(dir.extent))
+		parent := 1
+		if dir.parent != nil {
+			parent = dir.parent.number
+		}
+		order.PutUint16(r[6:], uint16(parent))
+		copy(r[8:], dir.name)
+		b = append(b, r...)
+	}
+	return b
+}
+
+// MarshalBinary returns the blocks from the start of the volume up to the
+// end of the last directory. The system area is left empty. size is the
+// total number of blocks in the volume, which is used as the volume space
+// size
+func (v *Volume) MarshalBinary(size int64) ([]byte, error) {
+	b := make([]byte, v.Blocks()*BlockSize)
+	block := func(lba int64) []byte {
+		return b[(lba-v.start)*BlockSize:]
+	}
+
+	for name, e := range v.entries {
+		if !e.dir && e.extent == 0 && e.size > 0 {
+			return nil, fmt.Errorf("%w: %q", errNotPlaced, name)
+		}
+	}
+
+	ptBlocks := int64((v.ptSize + BlockSize - 1) / BlockSize)
+	lPathTable := v.start + SystemArea + descriptorBlocks
+	mPathTable := lPathTable + ptBlocks
+
+	pvd := block(v.start + SystemArea)
+	pvd[0] = typePrimary
+	copy(pvd[1:], standardID)
+	pvd[6] = 1
+	putText(pvd[8:40], "")
+	putText(pvd[40:72], strings.ToUpper(v.ID))
+	putBoth32(pvd[80:], uint32(size))
+	putBoth16(pvd[120:], 1)
+	putBoth16(pvd[124:], 1)
+	putBoth16(pvd[128:], BlockSize)
+	putBoth32(pvd[132:], uint32(v.ptSize))
+	binary.LittleEndian.PutUint32(pvd[140:], uint32(lPathTable))
+	binary.BigEndian.PutUint32(pvd[148:], uint32(mPathTable))
+	writeRecord(pvd[offsetRootRecord:offsetRootRecord+rootRecordSize], "\x00", v.root)
+	putText(pvd[190:813], "")
+	putVolumeTime(pvd[813:], v.ModTime)
+	putVolumeTime(pvd[830:], v.ModTime)
+	putVolumeTime(pvd[847:], time.Time{})
+	putVolumeTime(pvd[864:], time.Time{})
+	pvd[881] = 1
+
+	term := block(v.start + SystemArea + 1)
+	term[0] = typeTerminator
+	copy(term[1:], standardID)
+	term[6] = 1
+
+	copy(block(lPathTable), v.pathTable(binary.LittleEndian))
+	copy(block(mPathTable), v.pathTable(binary.BigEndian))
+
+	for _, dir := range v.dirs {
+		d := block(dir.extent)[:dir.size]
+		parent := dir.parent
+		if parent == nil {
+			parent = dir
+		}
+
+		offset := writeRecord(d, "\x00", dir)
+		offset += writeRecord(d[offset:], "\x01", parent)
+		for _, c := range dir.children {
+			if n := recordSize(c.name); offset%BlockSize+n > BlockSize {
+				offset += BlockSize - offset%BlockSize
+			}
+			offset += writeRecord(d[offset:], c.name, c)
+		}
+	}
+
+	return b, nil
+}
@@ -0,0 +1,65 @@
+package iso9660
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolume(t *testing.T) {
+	modTime := time.Date(1999, time.September, 9, 12, 0, 0, 0, time.FixedZone("", 9*60*60))
+
+	v := NewVolume(45000)
+	v.ID = "test volume"
+	v.ModTime = modTime
+
+	assert.Nil(t, v.AddFile("1st_read.bin", 5, modTime))
+	assert.Nil(t, v.AddDir("dir", modTime))
+	assert.Nil(t, v.AddFile("dir/data.txt", int64(len(testData)), modTime))
+	assert.Nil(t, v.AddFile("noext", 0, modTime))
+
+	assert.True(t, errors.Is(v.AddFile("dir/data.txt", 1, modTime), errExists))
+	assert.True(t, errors.Is(v.AddFile("missing/data.txt", 1, modTime), errNoParent))
+	assert.True(t, errors.Is(v.AddFile("../data.txt", 1, modTime), errInvalidName))
+
+	blocks := v.Blocks()
+	assert.Equal(t, int64(SystemArea+descriptorBlocks+2+2), blocks)
+	assert.Equal(t, errVolumeLocked, v.AddDir("late", modTime))
+
+	_, err := v.MarshalBinary(blocks + 3)
+	assert.True(t, errors.Is(err, errNotPlaced))
+
+	assert.Nil(t, v.SetExtent("1st_read.bin", 45000+blocks))
+	assert.Nil(t, v.SetExtent("dir/data.txt", 45000+blocks+1))
+	assert.True(t, errors.Is(v.SetExtent("dir", 0), errNotFile))
+
+	b, err := v.MarshalBinary(blocks + 3)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, int(blocks*BlockSize), len(b))
+
+	b = append(b, make([]byte, 3*BlockSize)...)
+	copy(b[blocks*BlockSize:], "HELLO")
+	copy(b[(blocks+1)*BlockSize:], testData)
+
+	fsys, err := New(offsetReaderAt{b, 45000 * BlockSize}, 45000)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "TEST VOLUME", fsys.VolumeID)
+	assert.Nil(t, fstest.TestFS(fsys, "1ST_READ.BIN", "DIR/DATA.TXT", "NOEXT"))
+
+	data, err := fs.ReadFile(fsys, "DIR/DATA.TXT")
+	assert.Nil(t, err)
+	assert.Equal(t, testData, data)
+
+	fi, err := fsys.Stat("1ST_READ.BIN")
+	assert.Nil(t, err)
+	assert.True(t, modTime.Equal(fi.ModTime()))
+}
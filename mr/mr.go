@@ -0,0 +1,265 @@
+/*
+Package mr implements decoding and encoding of MR images, the run-length
+encoded format used for the logo shown on the Sega license screen when a
+Dreamcast disc boots. The format is registered with the image package.
+*/
+package mr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	// MaxWidth is the maximum width of an image shown on the license
+	// screen
+	MaxWidth = 320
+	// MaxHeight is the maximum height of an image shown on the license
+	// screen
+	MaxHeight = 90
+	// MaxSize is the maximum size of an encoded image that fits in the
+	// IP.BIN
+	MaxSize = 8192
+	// MaxColors is the maximum number of colors in the palette
+	MaxColors = 128
+)
+
+const (
+	headerSize = 0x1e
+	entrySize  = 4
+
+	runShort = 0x80
+	runLong  = 0x81
+	runExtra = 0x82
+	maxRun   = 0x17f
+)
+
+var magic = []byte("MR")
+
+var (
+	errInvalidHeader = errors.New("invalid MR header")
+	errInvalidData   = errors.New("invalid MR data")
+	errTooLarge      = errors.New("image too large")
+	errTooManyColors = errors.New("too many colors")
+)
+
+func init() {
+	image.RegisterFormat("mr", string(magic), Decode, DecodeConfig)
+}
+
+type header struct {
+	Magic  [2]byte
+	Size   uint32
+	_      uint32
+	Offset uint32
+	Width  uint32
+	Height uint32
+	_      uint32
+	Colors uint32
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func readHeader(r io.Reader) (*header, color.Palette, error) {
+	h := new(header)
+	if err := binary.Read(r, binary.LittleEndian, h); err != nil {
+		return nil, nil, unexpected(err)
+	}
+
+	if !bytes.Equal(h.Magic[:], magic) || h.Colors > MaxColors || h.Offset != headerSize+h.Colors*entrySize || h.Size < h.Offset {
+		return nil, nil, errInvalidHeader
+	}
+
+	// Each palette entry is blue, green and red
+	b := make([]byte, h.Colors*entrySize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, nil, unexpected(err)
+	}
+
+	p := make(color.Palette, 0, h.Colors)
+	for i := 0; i < len(b); i += entrySize {
+		p = append(p, color.RGBA{b[i+2], b[i+1], b[i], 0xff})
+	}
+
+	return h, p, nil
+}
+
+// DecodeConfig returns the color model and dimensions of an MR image
+// without decoding the entire image
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, p, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{
+		ColorModel: p,
+		Width:      int(h.Width),
+		Height:     int(h.Height),
+	}, nil
+}
+
+// Decode reads an MR image from r and returns it as an *image.Paletted
+func Decode(r io.Reader) (image.Image, error) {
+	h, p, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Width > MaxWidth || h.Height > MaxHeight {
+		return nil, errTooLarge
+	}
+
+	// No code takes more than three bytes to encode at least one pixel so
+	// don't allocate more than that for a corrupt header
+	if h.Size-h.Offset > 3*h.Width*h.Height {
+		return nil, errInvalidData
+	}
+
+	b := make([]byte, h.Size-h.Offset)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, unexpected(err)
+	}
+
+	m := image.NewPaletted(image.Rect(0, 0, int(h.Width), int(h.Height)), p)
+	pix := m.Pix[:0]
+	for i := 0; i < len(b); {
+		run, index := 1, b[i]
+		switch {
+		case b[i] < runShort:
+			i++
+		case b[i] == runExtra && i+2 < len(b) && b[i+1] >= runShort:
+			run, index = int(b[i+1]&0x7f)+0x100, b[i+2]
+			i += 3
+		case b[i] == runLong && i+2 < len(b):
+			run, index = int(b[i+1]), b[i+2]
+			i += 3
+		case i+1 < len(b):
+			run, index = int(b[i]&0x7f), b[i+1]
+			i += 2
+		default:
+			return nil, errInvalidData
+		}
+
+		if int(index) >= len(p) || len(pix)+run > len(m.Pix) {
+			return nil, errInvalidData
+		}
+
+		for j := 0; j < run; j++ {
+			pix = append(pix, index)
+		}
+	}
+
+	if len(pix) != len(m.Pix) {
+		return nil, errInvalidData
+	}
+
+	return m, nil
+}
+
+func palette(m image.Image) (*image.Paletted, error) {
+	if p, ok := m.(*image.Paletted); ok && len(p.Palette) <= MaxColors {
+		return p, nil
+	}
+
+	bounds := m.Bounds()
+	p := image.NewPaletted(bounds, nil)
+	indexes := make(map[color.RGBA]uint8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+			c.A = 0xff
+			index, ok := indexes[c]
+			if !ok {
+				if len(p.Palette) == MaxColors {
+					return nil, errTooManyColors
+				}
+				index = uint8(len(p.Palette))
+				indexes[c] = index
+				p.Palette = append(p.Palette, c)
+			}
+			p.SetColorIndex(x, y, index)
+		}
+	}
+
+	return p, nil
+}
+
+func compress(pix []byte) []byte {
+	b := new(bytes.Buffer)
+	for i := 0; i < len(pix); {
+		run := 1
+		for i+run < len(pix) && run < maxRun && pix[i] == pix[i+run] {
+			run++
+		}
+
+		switch {
+		case run > 0xff:
+			b.Write([]byte{runExtra, runShort | byte(run-0x100), pix[i]})
+		case run >= runShort:
+			b.Write([]byte{runLong, byte(run), pix[i]})
+		case run > 1:
+			b.Write([]byte{runShort | byte(run), pix[i]})
+		default:
+			b.WriteByte(pix[i])
+		}
+		i += run
+	}
+	return b.Bytes()
+}
+
+// Encode writes the image m to w in MR format. The image must have no more
+// than MaxColors colors and fit within MaxWidth and MaxHeight, and once
+// encoded must be no larger than MaxSize bytes. Any transparency is
+// ignored
+func Encode(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	if bounds.Dx() > MaxWidth || bounds.Dy() > MaxHeight {
+		return errTooLarge
+	}
+
+	p, err := palette(m)
+	if err != nil {
+		return err
+	}
+
+	pix := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := p.PixOffset(bounds.Min.X, y)
+		pix = append(pix, p.Pix[i:i+bounds.Dx()]...)
+	}
+	data := compress(pix)
+
+	h := header{
+		Offset: uint32(headerSize + len(p.Palette)*entrySize),
+		Width:  uint32(bounds.Dx()),
+		Height: uint32(bounds.Dy()),
+		Colors: uint32(len(p.Palette)),
+	}
+	copy(h.Magic[:], magic)
+	h.Size = h.Offset + uint32(len(data))
+
+	if h.Size > MaxSize {
+		return errTooLarge
+	}
+
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, h)
+	for _, c := range p.Palette {
+		r, g, b, _ := c.RGBA()
+		buf.Write([]byte{byte(b >> 8), byte(g >> 8), byte(r >> 8), 0})
+	}
+	buf.Write(data)
+
+	_, err = buf.WriteTo(w)
+	return err
+}
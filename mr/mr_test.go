@@ -0,0 +1,141 @@
+package mr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage() *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, 320, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 320; x++ {
+			switch {
+			case y < 10:
+				// Runs long enough to need every encoding
+				m.Set(x, y, color.RGBA{0xff, 0xff, 0xff, 0xff})
+			case y < 20:
+				m.Set(x, y, color.RGBA{byte(x % 3), 0, 0, 0xff})
+			default:
+				m.Set(x, y, color.RGBA{0, byte(x / 40), byte(y / 10), 0xff})
+			}
+		}
+	}
+	return m
+}
+
+func TestDecode(t *testing.T) {
+	b := []byte{
+		'M', 'R', 0x2b, 0, 0, 0, 0, 0, 0, 0, 0x26, 0, 0, 0, 4, 0, 0, 0,
+		2, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0,
+		0x30, 0x20, 0x10, 0, 0xff, 0xff, 0xff, 0,
+		1, 0x85, 0,
+		0x82, 1, // A run of two
+	}
+	b[2] = byte(len(b))
+
+	m, err := Decode(bytes.NewReader(b))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, image.Rect(0, 0, 4, 2), m.Bounds())
+	assert.Equal(t, color.Palette{color.RGBA{0x10, 0x20, 0x30, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}}, m.(*image.Paletted).Palette)
+	assert.Equal(t, []uint8{1, 0, 0, 0, 0, 0, 1, 1}, m.(*image.Paletted).Pix)
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, "mr", format)
+}
+
+func TestEncode(t *testing.T) {
+	m := testImage()
+
+	b := new(bytes.Buffer)
+	if !assert.Nil(t, Encode(b, m)) {
+		return
+	}
+	assert.True(t, b.Len() <= MaxSize)
+
+	got, format, err := image.Decode(bytes.NewReader(b.Bytes()))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "mr", format)
+
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 320; x++ {
+			assert.Equal(t, m.At(x, y), color.RGBAModel.Convert(got.At(x, y)))
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tables := []struct {
+		m   image.Image
+		err error
+	}{
+		{image.NewRGBA(image.Rect(0, 0, 321, 1)), errTooLarge},
+		{image.NewRGBA(image.Rect(0, 0, 1, 91)), errTooLarge},
+	}
+
+	// Too many colors
+	m := image.NewRGBA(image.Rect(0, 0, 320, 1))
+	for x := 0; x < 320; x++ {
+		m.Set(x, 0, color.RGBA{byte(x), byte(x >> 8), 0, 0xff})
+	}
+	tables = append(tables, struct {
+		m   image.Image
+		err error
+	}{m, errTooManyColors})
+
+	// Too large once encoded
+	m = image.NewRGBA(image.Rect(0, 0, 320, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 320; x++ {
+			m.Set(x, y, color.RGBA{byte((x + y) % 2), 0, 0, 0xff})
+		}
+	}
+	tables = append(tables, struct {
+		m   image.Image
+		err error
+	}{m, errTooLarge})
+
+	for _, table := range tables {
+		assert.Equal(t, table.err, Encode(ioutil.Discard, table.m))
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	b := new(bytes.Buffer)
+	if !assert.Nil(t, Encode(b, testImage())) {
+		return
+	}
+
+	badMagic := append([]byte{}, b.Bytes()...)
+	badMagic[0] = 'X'
+
+	badData := append([]byte{}, b.Bytes()...)
+	badData[len(badData)-1] = 0x7f
+
+	tables := []struct {
+		got []byte
+		err error
+	}{
+		{b.Bytes()[:10], io.ErrUnexpectedEOF},
+		{b.Bytes()[:b.Len()-1], io.ErrUnexpectedEOF},
+		{badMagic, errInvalidHeader},
+		{badData, errInvalidData},
+	}
+
+	for _, table := range tables {
+		_, err := Decode(bytes.NewReader(table.got))
+		assert.True(t, errors.Is(err, table.err), err)
+	}
+}
@@ -0,0 +1,204 @@
+package dreamcast
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProductKind classifies a product number by its prefix
+type ProductKind int
+
+const (
+	// ProductUnknown is used for a product number that can't be decoded
+	ProductUnknown ProductKind = iota
+	// ProductSega is used for Sega first party titles released outside of
+	// Japan, such as MK-51000
+	ProductSega
+	// ProductSegaJapan is used for Sega first party titles released in
+	// Japan, such as HDR-0001
+	ProductSegaJapan
+	// ProductThirdParty is used for titles from a third party licensee,
+	// such as T-8101N
+	ProductThirdParty
+	// ProductNaomi is used for NAOMI GD-ROM titles, such as GDS-0001
+	ProductNaomi
+)
+
+var productKindNames = map[ProductKind]string{
+	ProductUnknown:    "Unknown",
+	ProductSega:       "Sega",
+	ProductSegaJapan:  "Sega Japan",
+	ProductThirdParty: "Third Party",
+	ProductNaomi:      "NAOMI",
+}
+
+func (k ProductKind) String() string {
+	return productKindNames[k]
+}
+
+// Product is a decoded product number
+type Product struct {
+	// Kind is the kind of product number
+	Kind ProductKind
+	// Licensee is the number of the third party licensee, it is zero
+	// for anything other than ProductThirdParty
+	Licensee int
+	// Number is the number of the title, for a third party this is
+	// the number of the title from that licensee
+	Number int
+	// Region is the index of the region the title was released in,
+	// RegionJapan, RegionUSA or RegionEurope, or -1 if it can't be
+	// inferred
+	Region int
+	// Suffix is anything after the number, such as "N" or "D-50"
+	Suffix string
+}
+
+// Publishers maps third party licensee numbers to publisher names
+type Publishers map[int]string
+
+// DefaultPublishers is only a stub holding a few well-known licensee
+// numbers, any other licensee has no publisher. Either add to it or pass a
+// table of your own to Product.Publisher
+var DefaultPublishers = Publishers{
+	12: "Capcom",
+	14: "Namco",
+	97: "Midway",
+}
+
+// sega is the publisher name for first party titles
+const sega = "Sega"
+
+// maxLicenseeDigits is the most digits a third party licensee number has
+const maxLicenseeDigits = 3
+
+var (
+	productRegexp = regexp.MustCompile(`^(MK|HDR|GD[LSTX]|T)-?([0-9]+)([A-Z]*(?:-[0-9]+)?)$`)
+	makerRegexp   = regexp.MustCompile(`^SEGA LC-T-?([0-9]+)$`)
+)
+
+// The regional suffix of a third party product number
+var suffixRegions = map[byte]int{
+	'M': RegionJapan,
+	'N': RegionUSA,
+	'E': RegionEurope,
+	'D': RegionEurope,
+}
+
+// ParseProductNumber decodes the product number found in the IP.BIN. A
+// product number that can't be decoded returns a Product with a Kind of
+// ProductUnknown
+func ParseProductNumber(s string) Product {
+	p := Product{Region: -1}
+
+	m := productRegexp.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return p
+	}
+
+	number, err := strconv.Atoi(m[2])
+	if err != nil {
+		return p
+	}
+	p.Suffix = m[3]
+
+	switch m[1] {
+	case "MK":
+		// European releases have a -50 suffix
+		p.Kind, p.Number, p.Region = ProductSega, number, RegionUSA
+		if strings.HasPrefix(p.Suffix, "-5") {
+			p.Region = RegionEurope
+		}
+	case "HDR":
+		p.Kind, p.Number, p.Region = ProductSegaJapan, number, RegionJapan
+	case "GDL", "GDS", "GDT", "GDX":
+		// The region is set in the NAOMI BIOS instead
+		p.Kind, p.Number = ProductNaomi, number
+	case "T":
+		// The last two digits are the title, the rest is the
+		// licensee
+		digits := len(m[2]) - 2
+		if digits < 1 || digits > maxLicenseeDigits {
+			return Product{Region: -1}
+		}
+		p.Kind = ProductThirdParty
+		p.Licensee, _ = strconv.Atoi(m[2][:digits])
+		p.Number, _ = strconv.Atoi(m[2][digits:])
+		if len(p.Suffix) > 0 {
+			if region, ok := suffixRegions[p.Suffix[0]]; ok {
+				p.Region = region
+			}
+		}
+	}
+
+	return p
+}
+
+// Publisher returns the name of the publisher using the passed table to
+// look up third party licensees. First party titles are always published
+// by Sega. An empty string is returned if the publisher isn't known
+func (p Product) Publisher(publishers Publishers) string {
+	switch p.Kind {
+	case ProductSega, ProductSegaJapan:
+		return sega
+	case ProductThirdParty:
+		return publishers[p.Licensee]
+	}
+	return ""
+}
+
+// Maker is a decoded maker ID
+type Maker struct {
+	// FirstParty is true if the maker is Sega
+	FirstParty bool
+	// Licensee is the number of the third party licensee, or zero if
+	// it isn't known
+	Licensee int
+}
+
+// ParseMakerID decodes the maker ID found in the IP.BIN, either
+// "SEGA ENTERPRISES" for Sega or "SEGA LC-T-xx" for a third party licensee
+func ParseMakerID(s string) Maker {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == defaultMakerID {
+		return Maker{FirstParty: true}
+	}
+
+	if m := makerRegexp.FindStringSubmatch(s); m != nil {
+		if licensee, err := strconv.Atoi(m[1]); err == nil {
+			return Maker{Licensee: licensee}
+		}
+	}
+
+	return Maker{}
+}
+
+// Publisher returns the name of the publisher using the passed table to
+// look up third party licensees, or an empty string if it isn't known
+func (m Maker) Publisher(publishers Publishers) string {
+	if m.FirstParty {
+		return sega
+	}
+	return publishers[m.Licensee]
+}
+
+// Product returns the decoded product number
+func (ip IPBin) Product() Product {
+	return ParseProductNumber(ip.ProductNumber)
+}
+
+// Maker returns the decoded maker ID
+func (ip IPBin) Maker() Maker {
+	return ParseMakerID(ip.MakerID)
+}
+
+// Publisher returns the name of the publisher using the passed table to
+// look up third party licensees. The product number is tried first,
+// followed by the maker ID
+func (ip IPBin) Publisher(publishers Publishers) string {
+	if publisher := ip.Product().Publisher(publishers); publisher != "" {
+		return publisher
+	}
+	return ip.Maker().Publisher(publishers)
+}
@@ -0,0 +1,43 @@
+package dreamcast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProductNumber(t *testing.T) {
+	tables := []struct {
+		s         string
+		want      Product
+		publisher string
+	}{
+		{"MK-51000", Product{Kind: ProductSega, Number: 51000, Region: RegionUSA}, "Sega"},
+		{"MK-51000-50", Product{Kind: ProductSega, Number: 51000, Region: RegionEurope, Suffix: "-50"}, "Sega"},
+		{"HDR-0001", Product{Kind: ProductSegaJapan, Number: 1, Region: RegionJapan}, "Sega"},
+		{"T-1401N", Product{Kind: ProductThirdParty, Licensee: 14, Number: 1, Region: RegionUSA, Suffix: "N"}, "Namco"},
+		{"T-1202M", Product{Kind: ProductThirdParty, Licensee: 12, Number: 2, Region: RegionJapan, Suffix: "M"}, "Capcom"},
+		{"T-8101D-50", Product{Kind: ProductThirdParty, Licensee: 81, Number: 1, Region: RegionEurope, Suffix: "D-50"}, ""},
+		{"T-36802N", Product{Kind: ProductThirdParty, Licensee: 368, Number: 2, Region: RegionUSA, Suffix: "N"}, ""},
+		{"T-123401N", Product{Region: -1}, ""},
+		{"GDS-0001", Product{Kind: ProductNaomi, Number: 1, Region: -1}, ""},
+		{"T0000", Product{Kind: ProductThirdParty, Region: -1}, ""},
+		{"T-1", Product{Region: -1}, ""},
+		{"HOMEBREW", Product{Region: -1}, ""},
+	}
+
+	for _, table := range tables {
+		p := ParseProductNumber(table.s)
+		assert.Equal(t, table.want, p, table.s)
+		assert.Equal(t, table.publisher, p.Publisher(DefaultPublishers), table.s)
+	}
+}
+
+func TestParseMakerID(t *testing.T) {
+	assert.Equal(t, Maker{FirstParty: true}, ParseMakerID("SEGA ENTERPRISES"))
+	assert.Equal(t, Maker{Licensee: 14}, ParseMakerID("SEGA LC-T-14"))
+	assert.Equal(t, Maker{}, ParseMakerID("HOMEBREW"))
+
+	assert.Equal(t, "Capcom", Maker{Licensee: 12}.Publisher(DefaultPublishers))
+	assert.Equal(t, "Acclaim", Maker{Licensee: 81}.Publisher(Publishers{81: "Acclaim"}))
+}
@@ -2,13 +2,21 @@ package dreamcast
 
 import (
 	"archive/zip"
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"github.com/bodgit/dreamcast/cdi"
+	"github.com/bodgit/dreamcast/chd"
+	"github.com/bodgit/dreamcast/cue"
+	"github.com/bodgit/dreamcast/ecm"
 	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/sector"
 	"github.com/bodgit/plumbing"
 )
 
@@ -62,7 +70,7 @@ func NewDirectoryReader(directory string) (r *DirectoryReader, err error) {
 	}
 
 	if !info.IsDir() {
-		err = &os.PathError{"open", directory, syscall.ENOTDIR}
+		err = &os.PathError{Op: "open", Path: directory, Err: syscall.ENOTDIR}
 		return
 	}
 
@@ -95,7 +103,7 @@ func (r DirectoryReader) findFileByExtension(extension string) (io.ReadCloser, s
 		}
 	}
 
-	return nil, "", &os.PathError{"open", r.directory.Name(), syscall.ENOENT}
+	return nil, "", &os.PathError{Op: "open", Path: r.directory.Name(), Err: syscall.ENOENT}
 }
 
 // FindCueFile reads the directory and returns an io.ReadCloser for, and the
@@ -188,7 +196,7 @@ func (r ZipFileReader) findFileByExtension(extension string) (io.ReadCloser, str
 			return f, file.Name, nil
 		}
 	}
-	return nil, "", &os.PathError{"open", r.filename, syscall.ENOENT}
+	return nil, "", &os.PathError{Op: "open", Path: r.filename, Err: syscall.ENOENT}
 }
 
 // FindCueFile reads the zip file and returns an io.ReadCloser for, and the
@@ -210,7 +218,7 @@ func (r ZipFileReader) OpenFile(filename string) (io.ReadCloser, error) {
 			return file.Open()
 		}
 	}
-	return nil, &os.PathError{"open", r.filename, syscall.ENOENT}
+	return nil, &os.PathError{Op: "open", Path: r.filename, Err: syscall.ENOENT}
 }
 
 // FileSize returns the size of the named file
@@ -220,10 +228,486 @@ func (r ZipFileReader) FileSize(filename string) (uint64, error) {
 			return file.UncompressedSize64, nil
 		}
 	}
-	return 0, &os.PathError{"stat", r.filename, syscall.ENOENT}
+	return 0, &os.PathError{Op: "stat", Path: r.filename, Err: syscall.ENOENT}
 }
 
 // Rx returns the number of bytes read
 func (r ZipFileReader) Rx() uint64 {
 	return r.rx.Count()
 }
+
+// CDIReader reads a Dreamcast game from a DiscJuggler CDI image. Each track
+// is presented as a file of raw 2352 byte sectors, described by a cue sheet
+type CDIReader struct {
+	file     *os.File
+	filename string
+	image    *cdi.Image
+	names    map[string]cdi.Track
+	rx       plumbing.WriteCounter
+}
+
+var cdiModeToCueType = map[cdi.Mode]cue.Type{
+	cdi.ModeAudio: cue.TypeAudio,
+	cdi.Mode1:     cue.TypeMode1,
+	cdi.Mode2:     cue.TypeMode2,
+}
+
+func cdiTrackName(number int, track cdi.Track) string {
+	if track.IsAudioTrack() {
+		return fmt.Sprintf("track%02d.raw", number)
+	}
+	return fmt.Sprintf("track%02d.bin", number)
+}
+
+// NewCDIReader returns a CDIReader using the passed CDI image path
+func NewCDIReader(cdiFile string) (r *CDIReader, err error) {
+	r = &CDIReader{
+		filename: cdiFile,
+		names:    make(map[string]cdi.Track),
+	}
+
+	r.file, err = os.Open(cdiFile)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			r.file.Close()
+		}
+	}()
+
+	var info os.FileInfo
+	info, err = r.file.Stat()
+	if err != nil {
+		return
+	}
+
+	r.image, err = cdi.NewImage(plumbing.TeeReaderAt(r.file, &r.rx), info.Size())
+	if err != nil {
+		return
+	}
+
+	for i, track := range r.image.Tracks() {
+		r.names[cdiTrackName(i+1, track)] = track
+	}
+
+	return
+}
+
+// Close closes the CDI image
+func (r CDIReader) Close() error {
+	return r.file.Close()
+}
+
+// Sessions returns the sessions found in the CDI image
+func (r CDIReader) Sessions() []cdi.Session {
+	return r.image.Sessions
+}
+
+// FindCueFile returns an io.ReadCloser for, and the filename of, a cue sheet
+// describing the tracks in the CDI image
+func (r CDIReader) FindCueFile() (io.ReadCloser, string, error) {
+	sheet, number := new(cue.Sheet), 0
+	for i, session := range r.image.Sessions {
+		for _, track := range session.Tracks {
+			number++
+
+			t := cue.Track{
+				Number:  number,
+				Type:    cdiModeToCueType[track.Mode],
+				Session: i + 1,
+				Indexes: []cue.Index{
+					{
+						Number: 1,
+						Offset: 0,
+					},
+				},
+			}
+
+			// The pregap of the first track on the disc is implied
+			if number > 1 {
+				t.Pregap = track.Pregap
+			}
+
+			sheet.Files = append(sheet.Files, cue.File{
+				Name:   cdiTrackName(number, track),
+				Tracks: []cue.Track{t},
+			})
+		}
+	}
+
+	b, err := sheet.MarshalText()
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := filepath.Base(r.filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + cueExtension
+
+	return ioutil.NopCloser(bytes.NewReader(b)), name, nil
+}
+
+// FindGDIFile always returns an error as a CDI image has no GDI file
+func (r CDIReader) FindGDIFile() (io.ReadCloser, string, error) {
+	return nil, "", &os.PathError{Op: "open", Path: r.filename, Err: syscall.ENOENT}
+}
+
+// OpenFile returns an io.ReadCloser for the named track. Sectors are
+// expanded to 2352 bytes if the track is stored with smaller sectors
+func (r CDIReader) OpenFile(filename string) (io.ReadCloser, error) {
+	track, ok := r.names[filename]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: r.filename, Err: syscall.ENOENT}
+	}
+
+	mode := sector.Mode1
+	if track.Mode == cdi.Mode2 {
+		mode = sector.Mode2Form1
+	}
+
+	return ioutil.NopCloser(&trackReader{
+		r:    r.image.TrackReader(track),
+		mode: mode,
+		lba:  track.Start,
+		b:    make([]byte, track.SectorSize),
+	}), nil
+}
+
+// FileSize returns the size of the named track once expanded to 2352 byte
+// sectors
+func (r CDIReader) FileSize(filename string) (uint64, error) {
+	track, ok := r.names[filename]
+	if !ok {
+		return 0, &os.PathError{Op: "stat", Path: r.filename, Err: syscall.ENOENT}
+	}
+
+	return uint64(track.Length) * gdi.SectorSize, nil
+}
+
+// Rx returns the number of bytes read
+func (r CDIReader) Rx() uint64 {
+	return r.rx.Count()
+}
+
+// CHDReader reads a Dreamcast game from a MAME CHD image. Each track is
+// presented as a file of raw 2352 byte sectors, described by a cue sheet
+type CHDReader struct {
+	file     *os.File
+	filename string
+	image    *chd.Image
+	names    map[string]chdTrack
+	rx       plumbing.WriteCounter
+}
+
+type chdTrack struct {
+	chd.Track
+	// lba is the logical block address of the first sector in the file
+	lba int
+}
+
+func chdTrackName(track chd.Track) string {
+	if track.IsAudioTrack() {
+		return fmt.Sprintf("track%02d.raw", track.Number)
+	}
+	return fmt.Sprintf("track%02d.bin", track.Number)
+}
+
+func chdTrackType(track chd.Track) cue.Type {
+	switch {
+	case track.IsAudioTrack():
+		return cue.TypeAudio
+	case strings.HasPrefix(track.Type, "MODE2"):
+		return cue.TypeMode2
+	}
+	return cue.TypeMode1
+}
+
+// NewCHDReader returns a CHDReader using the passed CHD image path
+func NewCHDReader(chdFile string) (r *CHDReader, err error) {
+	r = &CHDReader{
+		filename: chdFile,
+		names:    make(map[string]chdTrack),
+	}
+
+	r.file, err = os.Open(chdFile)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			r.file.Close()
+		}
+	}()
+
+	r.image, err = chd.NewImage(plumbing.TeeReaderAt(r.file, &r.rx))
+	if err != nil {
+		return
+	}
+
+	lba := 0
+	for i, track := range r.image.Tracks {
+		lba += r.gap(i)
+		if r.image.GDROM && track.Number == 3 {
+			lba = gdi.TrackThreeStart
+		}
+
+		r.names[chdTrackName(track)] = chdTrack{track, lba}
+		lba += track.Frames
+	}
+
+	return
+}
+
+// gap returns the number of sectors between the end of the previous track
+// and the start of the file for the track. The tracks are stored back to
+// back so for a GD-ROM this is the usual gap between tracks, otherwise it
+// is any unstored pregap
+func (r CHDReader) gap(i int) int {
+	track := r.image.Tracks[i]
+	switch {
+	case i == 0 || r.image.GDROM && track.Number == 3:
+		return 0
+	case r.image.GDROM:
+		return pauseData
+	case !track.HasPregap():
+		return track.Pregap
+	}
+
+	return 0
+}
+
+// Close closes the CHD image
+func (r CHDReader) Close() error {
+	return r.file.Close()
+}
+
+// Tracks returns the tracks found in the CHD image
+func (r CHDReader) Tracks() []chd.Track {
+	return r.image.Tracks
+}
+
+// FindCueFile returns an io.ReadCloser for, and the filename of, a cue sheet
+// describing the tracks in the CHD image
+func (r CHDReader) FindCueFile() (io.ReadCloser, string, error) {
+	sheet := new(cue.Sheet)
+	for i, track := range r.image.Tracks {
+		t := cue.Track{
+			Number: track.Number,
+			Type:   chdTrackType(track),
+		}
+
+		switch {
+		case r.image.GDROM && track.Number < 3:
+			t.Area = cue.AreaSingleDensity
+		case r.image.GDROM:
+			t.Area = cue.AreaHighDensity
+		default:
+			// A CD has no areas, there is no record of the
+			// sessions however
+			t.Session = 1
+		}
+
+		t.Pregap = r.gap(i)
+
+		switch {
+		case track.HasPregap() && track.Pregap > 0:
+			t.Indexes = []cue.Index{
+				{
+					Number: 0,
+					Offset: 0,
+				},
+				{
+					Number: 1,
+					Offset: track.Pregap,
+				},
+			}
+		default:
+			t.Indexes = []cue.Index{
+				{
+					Number: 1,
+					Offset: 0,
+				},
+			}
+		}
+
+		sheet.Files = append(sheet.Files, cue.File{
+			Name:   chdTrackName(track),
+			Tracks: []cue.Track{t},
+		})
+	}
+
+	b, err := sheet.MarshalText()
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := filepath.Base(r.filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + cueExtension
+
+	return ioutil.NopCloser(bytes.NewReader(b)), name, nil
+}
+
+// FindGDIFile always returns an error as a CHD image has no GDI file
+func (r CHDReader) FindGDIFile() (io.ReadCloser, string, error) {
+	return nil, "", &os.PathError{Op: "open", Path: r.filename, Err: syscall.ENOENT}
+}
+
+// OpenFile returns an io.ReadCloser for the named track. Sectors are
+// expanded to 2352 bytes if the track is stored with smaller sectors
+func (r CHDReader) OpenFile(filename string) (io.ReadCloser, error) {
+	track, ok := r.names[filename]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: r.filename, Err: syscall.ENOENT}
+	}
+
+	mode := sector.Mode1
+	if chdTrackType(track.Track) == cue.TypeMode2 {
+		mode = sector.Mode2Form1
+	}
+
+	return ioutil.NopCloser(&trackReader{
+		r:    r.image.TrackReader(track.Track),
+		mode: mode,
+		lba:  track.lba,
+		b:    make([]byte, track.SectorSize()),
+	}), nil
+}
+
+// FileSize returns the size of the named track once expanded to 2352 byte
+// sectors
+func (r CHDReader) FileSize(filename string) (uint64, error) {
+	track, ok := r.names[filename]
+	if !ok {
+		return 0, &os.PathError{Op: "stat", Path: r.filename, Err: syscall.ENOENT}
+	}
+
+	return uint64(track.Frames) * gdi.SectorSize, nil
+}
+
+// Rx returns the number of bytes read
+func (r CHDReader) Rx() uint64 {
+	return r.rx.Count()
+}
+
+// trackReader expands the sectors of a track stored with less than 2352
+// bytes per sector into raw sectors
+type trackReader struct {
+	r    io.Reader
+	mode sector.Mode
+	lba  int
+	b    []byte
+	buf  bytes.Buffer
+}
+
+func (r *trackReader) next() error {
+	if _, err := io.ReadFull(r.r, r.b); err != nil {
+		return err
+	}
+
+	switch len(r.b) {
+	case sector.DataSize:
+		b, err := sector.New(r.mode, r.lba, r.b)
+		if err != nil {
+			return err
+		}
+		r.buf.Write(b)
+	case sector.Size - 28:
+		// Mode 2 Form 2 sector missing everything but the user data
+		b, err := sector.New(sector.Mode2Form2, r.lba, r.b)
+		if err != nil {
+			return err
+		}
+		r.buf.Write(b)
+	case sector.Size - 16:
+		// Mode 2 sector missing the sync data and header
+		h, err := sector.Header(r.mode, r.lba)
+		if err != nil {
+			return err
+		}
+		r.buf.Write(h)
+		r.buf.Write(r.b)
+	default:
+		// Raw sector, possibly followed by subchannel data
+		r.buf.Write(r.b[:sector.Size])
+	}
+
+	r.lba++
+
+	return nil
+}
+
+func (r *trackReader) Read(p []byte) (int, error) {
+	if r.buf.Len() == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// ECMReader wraps another Reader so that any track stored as an ECM file
+// is transparently decoded. A track is opened using its own name if it
+// exists, otherwise the ECM extension is appended to the name
+type ECMReader struct {
+	Reader
+}
+
+// NewECMReader returns an ECMReader wrapping the passed Reader
+func NewECMReader(r Reader) *ECMReader {
+	return &ECMReader{r}
+}
+
+func (r ECMReader) openFile(filename string) (io.ReadCloser, bool, error) {
+	file, err := r.Reader.OpenFile(filename)
+	switch {
+	case err == nil:
+		return file, strings.HasSuffix(filename, ecm.Extension), nil
+	case !os.IsNotExist(err):
+		return nil, false, err
+	}
+
+	file, ecmErr := r.Reader.OpenFile(filename + ecm.Extension)
+	if ecmErr != nil {
+		return nil, false, err
+	}
+
+	return file, true, nil
+}
+
+// OpenFile returns an io.ReadCloser for the named file, decoding it if it
+// is an ECM file
+func (r ECMReader) OpenFile(filename string) (io.ReadCloser, error) {
+	file, isECM, err := r.openFile(filename)
+	if err != nil || !isECM {
+		return file, err
+	}
+
+	reader, err := ecm.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return readCloser{reader, file}, nil
+}
+
+// FileSize returns the size of the named file. If it is an ECM file then
+// the size once decoded is returned
+func (r ECMReader) FileSize(filename string) (uint64, error) {
+	file, isECM, err := r.openFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if !isECM {
+		return r.Reader.FileSize(filename)
+	}
+
+	size, err := ecm.Size(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(size), nil
+}
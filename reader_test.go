@@ -0,0 +1,128 @@
+package dreamcast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/dreamcast/cdi"
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCDIGame writes a selfboot CDI image with the audio track in the
+// first session and the data track in the second, both preceded by a
+// pregap. The data track is stored with sectors of the passed size
+func writeCDIGame(t *testing.T, name string, audio, data []byte, start, sectorSize int) {
+	f, err := os.Create(name)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	w := cdi.NewWriter(f)
+
+	dst, err := w.CreateTrack(cdi.Track{Mode: cdi.ModeAudio, SectorSize: gdi.SectorSize, Pregap: pauseData, Start: 0})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	_, err = dst.Write(append(make([]byte, pauseData*gdi.SectorSize), audio...))
+	assert.Nil(t, err)
+
+	assert.Nil(t, w.NewSession())
+
+	dst, err = w.CreateTrack(cdi.Track{Mode: cdi.Mode1, SectorSize: sectorSize, Pregap: pauseData, Start: start})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	_, err = dst.Write(make([]byte, pauseData*sectorSize))
+	assert.Nil(t, err)
+
+	// Keep only the user data of each Mode 1 sector if they are short,
+	// skipping the sync data and header
+	offset := 0
+	if sectorSize == sector.DataSize {
+		offset = 16
+	}
+	for i := 0; i < len(data); i += gdi.SectorSize {
+		_, err = dst.Write(data[i+offset : i+offset+sectorSize])
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, w.Close())
+}
+
+func TestCDIReader(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// The data track in the second session starts after the lead-out of
+	// the first session, the lead-in of the second and its pregap
+	start := 300 + firstLeadOut + leadIn + pauseData
+
+	dir := t.TempDir()
+	audio := bytes.Repeat([]byte{0x11, 0x22}, 300*gdi.SectorSize/2)
+	writeDataTrack(t, filepath.Join(dir, "track02.bin"), start, 20, b)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "track02.bin"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tables := []int{
+		gdi.SectorSize,
+		// Short sectors are expanded back to raw sectors
+		sector.DataSize,
+	}
+
+	for _, table := range tables {
+		name := filepath.Join(t.TempDir(), "disc.cdi")
+		writeCDIGame(t, name, audio, data, start, table)
+
+		reader, err := NewCDIReader(name)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer reader.Close()
+
+		rc, cueFile, err := reader.FindCueFile()
+		if !assert.Nil(t, err) {
+			return
+		}
+		sheet, err := ioutil.ReadAll(rc)
+		rc.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, "disc.cue", cueFile)
+		assert.Equal(t, "REM SESSION 01\nFILE \"track01.raw\" BINARY\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\nREM SESSION 02\nFILE \"track02.bin\" BINARY\n  TRACK 02 MODE1/2352\n    PREGAP 00:02:00\n    INDEX 01 00:00:00\n", string(sheet))
+
+		g, err := NewGame(reader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, LayoutCD, g.Layout())
+		assert.Equal(t, []gdi.Track{
+			{Number: 1, Start: 0, Type: gdi.TypeAudio, SectorSize: gdi.SectorSize, Name: "track01.raw"},
+			{Number: 2, Start: start, Type: gdi.TypeData, SectorSize: gdi.SectorSize, Name: "track02.bin"},
+		}, g.Tracks())
+
+		for number, expected := range map[int][]byte{1: audio, 2: data} {
+			rc, err := g.OpenTrack(number)
+			if !assert.Nil(t, err) {
+				continue
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			assert.Nil(t, err)
+			assert.Equal(t, expected, b, table)
+		}
+	}
+}
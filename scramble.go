@@ -0,0 +1,264 @@
+package dreamcast
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+var errNotReaderAt = errors.New("boot file doesn't support random access")
+
+const (
+	// scrambleSlice is the size of the smallest unit moved around
+	scrambleSlice = 32
+	// scrambleChunk is the size of the largest chunk, each chunk has its
+	// slices shuffled. Chunks get smaller towards the end of the file
+	scrambleChunk = 2 << 20
+)
+
+// scrambler generates the order in which the slices of each chunk are
+// shuffled, as done by the boot ROM when it loads a binary from a CD
+type scrambler struct {
+	seed uint32
+	idx  []int
+}
+
+func newScrambler(size int64) *scrambler {
+	return &scrambler{seed: uint32(size) & 0xffff}
+}
+
+func (s *scrambler) rand() int {
+	s.seed = (s.seed*2109 + 9273) & 0x7fff
+	return int((s.seed + 0xc000) & 0xffff)
+}
+
+// shuffle copies each slice of the chunk from src to dst. When scrambling,
+// the slices are gathered into dst in the shuffled order, when
+// descrambling they are scattered back to their original positions
+func (s *scrambler) shuffle(dst, src []byte, descramble bool) {
+	n := len(src) / scrambleSlice
+
+	s.idx = s.idx[:0]
+	for i := 0; i < n; i++ {
+		s.idx = append(s.idx, i)
+	}
+
+	for i, j := n-1, 0; i >= 0; i, j = i-1, j+1 {
+		x := (s.rand() * i) >> 16
+		s.idx[i], s.idx[x] = s.idx[x], s.idx[i]
+
+		from, to := s.idx[i], j
+		if descramble {
+			from, to = to, from
+		}
+		copy(dst[to*scrambleSlice:(to+1)*scrambleSlice], src[from*scrambleSlice:(from+1)*scrambleSlice])
+	}
+}
+
+func scramble(w io.Writer, r io.Reader, size int64, descramble bool) error {
+	s := newScrambler(size)
+
+	buf := scrambleChunk
+	if size < scrambleChunk {
+		buf = int(size)
+	}
+	src, dst := make([]byte, buf), make([]byte, buf)
+
+	// Shuffle the largest chunks for as long as possible, then halve the
+	// chunk size down to a single slice
+	for chunk := int64(scrambleChunk); chunk >= scrambleSlice; chunk >>= 1 {
+		for ; size >= chunk; size -= chunk {
+			if _, err := io.ReadFull(r, src[:chunk]); err != nil {
+				return unexpected(err)
+			}
+
+			s.shuffle(dst[:chunk], src[:chunk], descramble)
+
+			if _, err := w.Write(dst[:chunk]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Anything less than a slice is left as is
+	if _, err := io.CopyN(w, r, size); err != nil {
+		return unexpected(err)
+	}
+
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Scramble reads size bytes of a binary from r and writes it to w in the
+// scrambled form expected by the boot ROM when booting from a CD, such as
+// a MIL-CD or selfboot disc
+func Scramble(w io.Writer, r io.Reader, size int64) error {
+	return scramble(w, r, size, false)
+}
+
+// Descramble reads size bytes of a scrambled binary from r and writes it
+// to w in its original form, as run from a GD-ROM
+func Descramble(w io.Writer, r io.Reader, size int64) error {
+	return scramble(w, r, size, true)
+}
+
+// boundaryScore is an io.Writer that counts how often both sides of the
+// boundary between two slices look alike, either both runs of zeroes or
+// both printable text. Scrambling separates neighbouring slices so an
+// unscrambled binary scores higher than the same binary scrambled
+type boundaryScore struct {
+	n     int64
+	last  byte // The class of the end of the previous slice
+	score int
+}
+
+const (
+	classOther = iota
+	classZero
+	classText
+	boundaryBytes = 4
+)
+
+func byteClass(b []byte) byte {
+	class := byte(classOther)
+	for i, c := range b {
+		var cc byte
+		switch {
+		case c == 0:
+			cc = classZero
+		case c >= 0x20 && c < 0x7f:
+			cc = classText
+		default:
+			return classOther
+		}
+		if i > 0 && cc != class {
+			return classOther
+		}
+		class = cc
+	}
+	return class
+}
+
+func (s *boundaryScore) Write(p []byte) (int, error) {
+	// Writes are always whole slices, apart from the final remainder
+	for i := 0; i+scrambleSlice <= len(p); i += scrambleSlice {
+		slice := p[i : i+scrambleSlice]
+		if first := byteClass(slice[:boundaryBytes]); s.n > 0 && first != classOther && first == s.last {
+			s.score++
+		}
+		s.last = byteClass(slice[scrambleSlice-boundaryBytes:])
+		s.n += scrambleSlice
+	}
+	return len(p), nil
+}
+
+// IsScrambled reads size bytes of a binary from the io.ReaderAt and
+// reports whether it appears to be scrambled. This works by comparing how
+// well neighbouring slices of the binary fit together both as is and once
+// descrambled, so it can be wrong for very small binaries
+func IsScrambled(r io.ReaderAt, size int64) (bool, error) {
+	plain, descrambled := new(boundaryScore), new(boundaryScore)
+
+	// Score the binary as is while it's being descrambled
+	if err := Descramble(descrambled, io.TeeReader(io.NewSectionReader(r, 0, size), plain), size); err != nil {
+		return false, err
+	}
+
+	return descrambled.score > plain.score, nil
+}
+
+// bootFile is the boot file named in the IP.BIN, kept open along with the
+// filesystem it is read from
+type bootFile struct {
+	fsys *FS
+	file fs.File
+	r    io.ReaderAt
+	size int64
+}
+
+// openBootFile opens the boot file named in the IP.BIN. Use Close once
+// finished
+func (g Game) openBootFile() (*bootFile, error) {
+	fsys, err := g.FS()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := fsys.Open(g.IPBin.BootFilename)
+	if err != nil {
+		fsys.Close()
+		return nil, err
+	}
+
+	f := &bootFile{fsys: fsys, file: file}
+
+	var ok bool
+	if f.r, ok = file.(io.ReaderAt); !ok {
+		f.Close()
+		return nil, errNotReaderAt
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.size = fi.Size()
+
+	return f, nil
+}
+
+// Close closes the boot file and the filesystem
+func (f *bootFile) Close() error {
+	err := f.file.Close()
+	if ferr := f.fsys.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// IsBootFileScrambled reports whether the boot file named in the IP.BIN
+// appears to be scrambled. This is expected for a game on a CD, but not on
+// a GD-ROM
+func (g Game) IsBootFileScrambled() (bool, error) {
+	f, err := g.openBootFile()
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	return IsScrambled(f.r, f.size)
+}
+
+// WriteBootFile writes the boot file named in the IP.BIN to w, either
+// scrambled or not as requested regardless of how it is stored on the disc
+func (g Game) WriteBootFile(w io.Writer, scrambled bool) error {
+	f, err := g.openBootFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	isScrambled, err := IsScrambled(f.r, f.size)
+	if err != nil {
+		return err
+	}
+
+	r := io.NewSectionReader(f.r, 0, f.size)
+	switch {
+	case scrambled && !isScrambled:
+		err = Scramble(w, r, f.size)
+	case !scrambled && isScrambled:
+		err = Descramble(w, r, f.size)
+	default:
+		_, err = io.Copy(w, r)
+	}
+
+	return err
+}
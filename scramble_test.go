@@ -0,0 +1,111 @@
+package dreamcast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/iso9660"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScramble(t *testing.T) {
+	tables := []struct {
+		size   int
+		digest string
+	}{
+		{1000, "a1f4384f74763950c472e790da31ef24e575c9853d4b3be1a9af897feebe9afd"},
+		{scrambleChunk + 64<<10 + 113, "14d5772f0b36a98c1eac232c305748c9128dd4f0a6c7668a60ae60586179b13d"},
+	}
+
+	for _, table := range tables {
+		b := make([]byte, table.size)
+		for i := range b {
+			b[i] = byte(i*7 + i>>8)
+		}
+
+		scrambled := new(bytes.Buffer)
+		if !assert.Nil(t, Scramble(scrambled, bytes.NewReader(b), int64(len(b)))) {
+			continue
+		}
+		digest := sha256.Sum256(scrambled.Bytes())
+		assert.Equal(t, table.digest, hex.EncodeToString(digest[:]))
+
+		descrambled := new(bytes.Buffer)
+		assert.Nil(t, Descramble(descrambled, bytes.NewReader(scrambled.Bytes()), int64(scrambled.Len())))
+		assert.Equal(t, b, descrambled.Bytes())
+	}
+
+	assert.NotNil(t, Scramble(new(bytes.Buffer), bytes.NewReader(make([]byte, 100)), 101))
+}
+
+func TestIsScrambled(t *testing.T) {
+	// Something resembling a binary with code, strings and padding
+	r := rand.New(rand.NewSource(1))
+	code := make([]byte, 100<<10)
+	r.Read(code)
+	b := append(code, strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000)...)
+	b = append(b, make([]byte, 20<<10)...)
+	b = append(b, code[:10<<10]...)
+
+	scrambled := new(bytes.Buffer)
+	if !assert.Nil(t, Scramble(scrambled, bytes.NewReader(b), int64(len(b)))) {
+		return
+	}
+
+	ok, err := IsScrambled(bytes.NewReader(b), int64(len(b)))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = IsScrambled(bytes.NewReader(scrambled.Bytes()), int64(scrambled.Len()))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestBootFile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	code := make([]byte, 100<<10)
+	r.Read(code)
+	b := append(code, strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000)...)
+	b = append(b, make([]byte, 20<<10)...)
+
+	scrambled := new(bytes.Buffer)
+	if !assert.Nil(t, Scramble(scrambled, bytes.NewReader(b), int64(len(b)))) {
+		return
+	}
+
+	for _, boot := range [][]byte{b, scrambled.Bytes()} {
+		v := iso9660.NewVolume(gdi.TrackThreeStart)
+		assert.Nil(t, v.AddFile(defaultBootFilename, int64(len(boot)), time.Time{}))
+
+		dir := t.TempDir()
+		writeFSGame(t, dir, nil, v, map[string][]byte{defaultBootFilename: boot})
+
+		reader, err := NewDirectoryReader(dir)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer reader.Close()
+
+		g, err := NewGame(reader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		ok, err := g.IsBootFileScrambled()
+		assert.Nil(t, err)
+		assert.Equal(t, bytes.Equal(boot, scrambled.Bytes()), ok)
+
+		// The boot file is read in full in either form
+		for _, expected := range [][]byte{b, scrambled.Bytes()} {
+			w := new(bytes.Buffer)
+			assert.Nil(t, g.WriteBootFile(w, bytes.Equal(expected, scrambled.Bytes())))
+			assert.Equal(t, expected, w.Bytes())
+		}
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Package sector implements generation of raw 2352 byte CD-ROM sectors,
+including the sync pattern, header and the EDC/ECC error correction data
+used by Mode 1 and Mode 2 sectors.
+*/
+package sector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// Size is the size of a raw sector
+	Size = 2352
+	// DataSize is the size of the user data in a Mode 1 or Mode 2 Form 1
+	// sector
+	DataSize = 2048
+	// FramesPerSecond is the number of sectors, or frames, per second
+	FramesPerSecond = 75
+	// Pregap is the number of sectors before LBA 0, so LBA 0 is MSF
+	// 00:02:00
+	Pregap = 150
+)
+
+const (
+	syncSize      = 12
+	headerSize    = 4
+	subheaderSize = 8
+
+	offsetHeader    = syncSize
+	offsetMode      = offsetHeader + 3
+	offsetData      = offsetHeader + headerSize
+	offsetSubheader = offsetData
+	offsetEDC       = offsetData + DataSize
+	offsetECCP      = 0x81c
+	offsetECCQ      = 0x8c8
+
+	formBit = 0x20
+)
+
+// Mode represents the mode of a sector
+type Mode int
+
+const (
+	// ModeUnknown is used when the sector is not a valid data sector
+	ModeUnknown Mode = iota
+	// Mode1 is used for Mode 1 sectors
+	Mode1
+	// Mode2Form1 is used for Mode 2 Form 1 sectors
+	Mode2Form1
+	// Mode2Form2 is used for Mode 2 Form 2 sectors
+	Mode2Form2
+)
+
+var (
+	errInvalidSize = errors.New("invalid sector size")
+	errInvalidMode = errors.New("invalid sector mode")
+)
+
+var syncPattern = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+var (
+	eccFLUT [256]byte
+	eccBLUT [256]byte
+	edcLUT  [256]uint32
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		j := i << 1
+		if i&0x80 != 0 {
+			j ^= 0x11d
+		}
+		eccFLUT[i] = byte(j)
+		eccBLUT[i^j] = byte(i)
+
+		edc := uint32(i)
+		for k := 0; k < 8; k++ {
+			if edc&1 != 0 {
+				edc = edc>>1 ^ 0xd8018001
+			} else {
+				edc = edc >> 1
+			}
+		}
+		edcLUT[i] = edc
+	}
+}
+
+// EDC computes the error detection code over the passed bytes
+func EDC(b []byte) uint32 {
+	return UpdateEDC(0, b)
+}
+
+// UpdateEDC returns the result of adding the passed bytes to the error
+// detection code
+func UpdateEDC(edc uint32, b []byte) uint32 {
+	for _, x := range b {
+		edc = edc>>8 ^ edcLUT[byte(edc)^x]
+	}
+	return edc
+}
+
+func eccBlock(src []byte, majorCount, minorCount, majorMult, minorInc int, dst []byte) {
+	size := majorCount * minorCount
+	for major := 0; major < majorCount; major++ {
+		index := (major>>1)*majorMult + major&1
+		a, b := byte(0), byte(0)
+		for minor := 0; minor < minorCount; minor++ {
+			x := src[index]
+			index += minorInc
+			if index >= size {
+				index -= size
+			}
+			a ^= x
+			b ^= x
+			a = eccFLUT[a]
+		}
+		a = eccBLUT[eccFLUT[a]^b]
+		dst[major] = a
+		dst[major+majorCount] = a ^ b
+	}
+}
+
+func ecc(b []byte) {
+	eccBlock(b[offsetHeader:], 86, 24, 2, 86, b[offsetECCP:])
+	eccBlock(b[offsetHeader:], 52, 43, 86, 88, b[offsetECCQ:])
+}
+
+// ECC recomputes the P and Q parity of the raw sector in place using the
+// header as found, which is only correct for Mode 1 sectors
+func ECC(b []byte) error {
+	if len(b) != Size {
+		return errInvalidSize
+	}
+	ecc(b)
+	return nil
+}
+
+func toBCD(n int) byte {
+	return byte(n/10<<4 | n%10)
+}
+
+func fromBCD(b byte) int {
+	return int(b>>4)*10 + int(b&0x0f)
+}
+
+// LBAToMSF converts a logical block address into the minutes, seconds and
+// frames address used in sector headers, accounting for the pregap
+func LBAToMSF(lba int) (int, int, int) {
+	lba += Pregap
+	return lba / FramesPerSecond / 60, lba / FramesPerSecond % 60, lba % FramesPerSecond
+}
+
+// MSFToLBA converts a minutes, seconds and frames address into a logical
+// block address, accounting for the pregap
+func MSFToLBA(m, s, f int) int {
+	return (m*60+s)*FramesPerSecond + f - Pregap
+}
+
+// Address returns the logical block address stored in the header of the
+// raw sector
+func Address(b []byte) int {
+	return MSFToLBA(fromBCD(b[offsetHeader]), fromBCD(b[offsetHeader+1]), fromBCD(b[offsetHeader+2]))
+}
+
+// ModeOf returns the mode of the raw sector based on the sync pattern,
+// mode byte and, for Mode 2, the form bit of the subheader
+func ModeOf(b []byte) Mode {
+	if len(b) != Size || !bytes.Equal(b[:syncSize], syncPattern) {
+		return ModeUnknown
+	}
+
+	switch b[offsetMode] {
+	case 1:
+		return Mode1
+	case 2:
+		if b[offsetSubheader+2]&formBit != 0 {
+			return Mode2Form2
+		}
+		return Mode2Form1
+	}
+
+	return ModeUnknown
+}
+
+// Regenerate recomputes the EDC and ECC of the raw sector in place, based
+// on the mode found in the header
+func Regenerate(b []byte) error {
+	return Generate(b, ModeOf(b))
+}
+
+// Generate computes the EDC and ECC of the raw sector in place as the
+// passed mode, regardless of the sync pattern, header or subheader
+func Generate(b []byte, mode Mode) error {
+	if len(b) != Size {
+		return errInvalidSize
+	}
+
+	switch mode {
+	case Mode1:
+		binary.LittleEndian.PutUint32(b[offsetEDC:], EDC(b[:offsetEDC]))
+		for i := offsetEDC + 4; i < offsetECCP; i++ {
+			b[i] = 0
+		}
+		ecc(b)
+	case Mode2Form1:
+		binary.LittleEndian.PutUint32(b[offsetSubheader+subheaderSize+DataSize:], EDC(b[offsetSubheader:offsetSubheader+subheaderSize+DataSize]))
+		// The header is treated as zero when calculating the ECC
+		var header [headerSize]byte
+		copy(header[:], b[offsetHeader:])
+		copy(b[offsetHeader:], make([]byte, headerSize))
+		ecc(b)
+		copy(b[offsetHeader:], header[:])
+	case Mode2Form2:
+		binary.LittleEndian.PutUint32(b[Size-4:], EDC(b[offsetSubheader:Size-4]))
+	default:
+		return errInvalidMode
+	}
+
+	return nil
+}
+
+func header(b []byte, mode Mode, lba int) error {
+	copy(b, syncPattern)
+
+	m, s, f := LBAToMSF(lba)
+	b[offsetHeader], b[offsetHeader+1], b[offsetHeader+2] = toBCD(m), toBCD(s), toBCD(f)
+
+	switch mode {
+	case Mode1:
+		b[offsetMode] = 1
+	case Mode2Form1, Mode2Form2:
+		b[offsetMode] = 2
+	default:
+		return errInvalidMode
+	}
+
+	return nil
+}
+
+// Header returns the sync pattern and header that start a raw sector of
+// the given mode at the passed logical block address
+func Header(mode Mode, lba int) ([]byte, error) {
+	b := make([]byte, offsetData)
+	if err := header(b, mode, lba); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Data returns the user data of the raw sector based on the mode found in
+// the header
+func Data(b []byte) ([]byte, error) {
+	switch ModeOf(b) {
+	case Mode1:
+		return b[offsetData:offsetEDC], nil
+	case Mode2Form1:
+		return b[offsetSubheader+subheaderSize : offsetSubheader+subheaderSize+DataSize], nil
+	case Mode2Form2:
+		return b[offsetSubheader+subheaderSize : Size-4], nil
+	}
+	return nil, errInvalidMode
+}
+
+// New returns a raw sector of the given mode at the passed logical block
+// address. The user data is copied from data which may be shorter than the
+// sector can hold, in which case it is padded with zeroes. For Mode 2
+// sectors the subheader is generated with the form bit set accordingly.
+func New(mode Mode, lba int, data []byte) ([]byte, error) {
+	b := make([]byte, Size)
+	if err := header(b, mode, lba); err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case Mode1:
+		copy(b[offsetData:offsetEDC], data)
+	case Mode2Form1:
+		copy(b[offsetSubheader+subheaderSize:offsetSubheader+subheaderSize+DataSize], data)
+	case Mode2Form2:
+		b[offsetSubheader+2], b[offsetSubheader+6] = formBit, formBit
+		copy(b[offsetSubheader+subheaderSize:Size-4], data)
+	}
+
+	if err := Regenerate(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
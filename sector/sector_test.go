@@ -0,0 +1,117 @@
+package sector
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEDC(t *testing.T) {
+	b := []byte("123456789")
+	edc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(edc, EDC(b))
+
+	// Appending the EDC to the data should leave no remainder
+	assert.Equal(t, uint32(0), EDC(append(b, edc...)))
+}
+
+func TestLBAToMSF(t *testing.T) {
+	tables := []struct {
+		lba     int
+		m, s, f int
+	}{
+		{0, 0, 2, 0},
+		{-150, 0, 0, 0},
+		{45000, 10, 2, 0},
+		{549300, 122, 6, 0},
+	}
+
+	for _, table := range tables {
+		m, s, f := LBAToMSF(table.lba)
+		assert.Equal(t, []int{table.m, table.s, table.f}, []int{m, s, f})
+		assert.Equal(t, table.lba, MSFToLBA(m, s, f))
+	}
+}
+
+func TestNew(t *testing.T) {
+	tables := []struct {
+		mode Mode
+		lba  int
+		err  error
+	}{
+		{Mode1, 45000, nil},
+		{Mode2Form1, 11702, nil},
+		{Mode2Form2, 11702, nil},
+		{ModeUnknown, 0, errInvalidMode},
+	}
+
+	for _, table := range tables {
+		b, err := New(table.mode, table.lba, []byte("SEGA SEGAKATANA "))
+		assert.Equal(t, table.err, err)
+		if err != nil {
+			continue
+		}
+
+		assert.Equal(t, Size, len(b))
+		assert.Equal(t, table.mode, ModeOf(b))
+		assert.Equal(t, table.lba, Address(b))
+
+		h, err := Header(table.mode, table.lba)
+		assert.Nil(t, err)
+		assert.Equal(t, b[:len(h)], h)
+
+		data, err := Data(b)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("SEGA SEGAKATANA "), data[:16])
+
+		// Regenerating a freshly generated sector is a no-op
+		c := make([]byte, Size)
+		copy(c, b)
+		assert.Nil(t, Regenerate(c))
+		assert.Equal(t, b, c)
+
+		// Corrupting the error correction data is fixed by regenerating
+		c[Size-1] ^= 0xff
+		assert.Nil(t, Regenerate(c))
+		assert.Equal(t, b, c)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	_, err := Header(ModeUnknown, 0)
+	assert.Equal(t, errInvalidMode, err)
+}
+
+func TestData(t *testing.T) {
+	_, err := Data(make([]byte, Size))
+	assert.Equal(t, errInvalidMode, err)
+}
+
+func TestRegenerate(t *testing.T) {
+	assert.Equal(t, errInvalidSize, Regenerate(make([]byte, DataSize)))
+	assert.Equal(t, errInvalidMode, Regenerate(make([]byte, Size)))
+}
+
+func TestUpdateEDC(t *testing.T) {
+	b := []byte("SEGA SEGAKATANA ")
+	assert.Equal(t, EDC(b), UpdateEDC(UpdateEDC(0, b[:5]), b[5:]))
+}
+
+func TestGenerate(t *testing.T) {
+	b, err := New(Mode2Form1, 11702, []byte("SEGA SEGAKATANA "))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Without the sync pattern the mode can't be found in the sector
+	c := append([]byte{}, b...)
+	copy(c, make([]byte, syncSize))
+	c[offsetECCP] ^= 0xff
+	assert.Equal(t, errInvalidMode, Regenerate(c))
+	assert.Nil(t, Generate(c, Mode2Form1))
+	assert.Equal(t, b[syncSize:], c[syncSize:])
+
+	assert.Equal(t, errInvalidSize, Generate(make([]byte, DataSize), Mode1))
+	assert.Equal(t, errInvalidMode, Generate(make([]byte, Size), ModeUnknown))
+}
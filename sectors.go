@@ -0,0 +1,271 @@
+package dreamcast
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/sector"
+)
+
+var (
+	errNoSuchTrack   = errors.New("no such track")
+	errInvalidLBA    = errors.New("invalid LBA")
+	errNotDataSector = errors.New("not a data sector")
+)
+
+// Tracks returns a copy of the tracks of the game. The start of each track
+// is the LBA of the first sector in its file, so for the Redump layout this
+// includes any pause or pregap
+func (g Game) Tracks() []gdi.Track {
+	return g.gdiFile.Copy().Tracks
+}
+
+// trackIndex returns the index of the track with the passed number
+func (g Game) trackIndex(number int) (int, error) {
+	for i, track := range g.gdiFile.Tracks {
+		if track.Number == number {
+			return i, nil
+		}
+	}
+	return 0, errNoSuchTrack
+}
+
+// OpenTrack opens the track with the passed number, returning the raw 2352
+// byte sectors of the track regardless of how it's stored
+func (g Game) OpenTrack(number int) (io.ReadCloser, error) {
+	i, err := g.trackIndex(number)
+	if err != nil {
+		return nil, err
+	}
+	return g.openTrack(i)
+}
+
+// TrackLength returns the number of sectors in the track with the passed
+// number
+func (g Game) TrackLength(number int) (int, error) {
+	i, err := g.trackIndex(number)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := g.trackSize(i)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(size / gdi.SectorSize), nil
+}
+
+// SectorReader reads the sectors of a game by LBA, routing each one to the
+// track it belongs to. Sectors are either raw, 2352 bytes, or cooked, the
+// 2048 bytes of user data of a data sector. Any sector in a gap between
+// tracks reads as zeroes. It implements io.ReaderAt, with each offset
+// being the LBA multiplied by the sector size. Use sector.LBAToMSF and
+// sector.MSFToLBA to convert between an LBA and an MSF address.
+//
+// The last track opened is kept open so sequential reads don't need to
+// skip over the track again, reading backwards means reopening the track.
+type SectorReader struct {
+	g       Game
+	raw     bool
+	lengths []int
+	end     int
+
+	mu    sync.Mutex
+	track int
+	rc    io.ReadCloser
+	next  int // The LBA of the next sector read from rc
+	b     []byte
+}
+
+// NewSectorReader returns a SectorReader over every track of the game,
+// reading either raw or cooked sectors. For a GD-ROM the LBAs run from 0
+// to the end of the high density area, otherwise they run to the end of
+// the last track. Use Close once finished.
+//
+// The end of the high density area is often given as 0x861b4, 549300,
+// however that is addressed like the IP.BIN TOC, 150 sectors ahead of the
+// LBA. The last LBA of a GD-ROM is therefore 549149 and Sectors returns
+// 549150
+func (g Game) NewSectorReader(raw bool) (*SectorReader, error) {
+	r := &SectorReader{
+		g:       g,
+		raw:     raw,
+		lengths: make([]int, len(g.gdiFile.Tracks)),
+		track:   -1,
+		b:       make([]byte, gdi.SectorSize),
+	}
+
+	if g.Layout() == LayoutGDROM {
+		r.end = highDensityEnd
+	}
+
+	for i, track := range g.gdiFile.Tracks {
+		size, err := g.trackSize(i)
+		if err != nil {
+			return nil, err
+		}
+		r.lengths[i] = int(size / gdi.SectorSize)
+
+		if end := track.Start + r.lengths[i]; end > r.end {
+			r.end = end
+		}
+	}
+
+	return r, nil
+}
+
+// SectorSize returns the size of each sector read, either raw or cooked
+func (r *SectorReader) SectorSize() int {
+	if r.raw {
+		return gdi.SectorSize
+	}
+	return sector.DataSize
+}
+
+// Sectors returns the number of sectors, the LBA following the last one
+func (r *SectorReader) Sectors() int {
+	return r.end
+}
+
+// Size returns the size in bytes of every sector
+func (r *SectorReader) Size() int64 {
+	return int64(r.end) * int64(r.SectorSize())
+}
+
+// findTrack returns the index of the track containing the LBA
+func (r *SectorReader) findTrack(lba int) int {
+	for i, track := range r.g.gdiFile.Tracks {
+		if lba >= track.Start && lba < track.Start+r.lengths[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// seek positions the reader at the sector with the passed LBA, reopening
+// the track if it's behind the current position
+func (r *SectorReader) seek(track, lba int) error {
+	if r.track != track || lba < r.next {
+		if err := r.close(); err != nil {
+			return err
+		}
+
+		rc, err := r.g.openTrack(track)
+		if err != nil {
+			return err
+		}
+		r.track, r.rc, r.next = track, rc, r.g.gdiFile.Tracks[track].Start
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r.rc, int64(lba-r.next)*gdi.SectorSize); err != nil {
+		return err
+	}
+	r.next = lba
+
+	return nil
+}
+
+// readSector returns the raw or cooked sector with the passed LBA, which
+// is only valid until the next read
+func (r *SectorReader) readSector(lba int) ([]byte, error) {
+	track := r.findTrack(lba)
+	if track < 0 {
+		for i := range r.b {
+			r.b[i] = 0
+		}
+		return r.b[:r.SectorSize()], nil
+	}
+
+	if err := r.seek(track, lba); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r.rc, r.b); err != nil {
+		return nil, err
+	}
+	r.next++
+
+	if r.raw {
+		return r.b, nil
+	}
+
+	// An audio track may still end with the data sectors of the pregap
+	// of the following track
+	if r.g.gdiFile.Tracks[track].IsAudioTrack() && sector.ModeOf(r.b) == sector.ModeUnknown {
+		return nil, errNotDataSector
+	}
+
+	offset := userData(r.b)
+	return r.b[offset : offset+sector.DataSize], nil
+}
+
+// ReadSector returns the raw or cooked sector with the passed LBA
+func (r *SectorReader) ReadSector(lba int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if lba < 0 || lba >= r.end {
+		return nil, errInvalidLBA
+	}
+
+	b, err := r.readSector(lba)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{}, b...), nil
+}
+
+// ReadAt reads len(p) bytes starting at the offset, which is the LBA of
+// the first sector multiplied by the sector size plus any offset within
+// that sector
+func (r *SectorReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if off < 0 {
+		return 0, errInvalidLBA
+	}
+
+	size := int64(r.SectorSize())
+
+	n := 0
+	for n < len(p) {
+		lba, offset := int(off/size), int(off%size)
+		if lba >= r.end {
+			return n, io.EOF
+		}
+
+		b, err := r.readSector(lba)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], b[offset:])
+		n += c
+		off += int64(c)
+	}
+
+	return n, nil
+}
+
+// Close closes any open track
+func (r *SectorReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.close()
+}
+
+func (r *SectorReader) close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.track, r.rc = -1, nil
+	return err
+}
@@ -0,0 +1,146 @@
+package dreamcast
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/sector"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeDataTrack writes a track of Mode 1 sectors, each filled with the
+// low byte of its LBA, apart from the first which holds b
+func writeDataTrack(t *testing.T, name string, start, length int, b []byte) {
+	buf := new(bytes.Buffer)
+	for i := 0; i < length; i++ {
+		data := bytes.Repeat([]byte{byte(start + i)}, sector.DataSize)
+		if i*sector.DataSize < len(b) {
+			data = b[i*sector.DataSize:]
+		}
+		s, err := sector.New(sector.Mode1, start+i, data)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		buf.Write(s)
+	}
+	assert.Nil(t, ioutil.WriteFile(name, buf.Bytes(), 0644))
+}
+
+func TestSectorReader(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	dir := t.TempDir()
+	writeDataTrack(t, filepath.Join(dir, "track01.bin"), 0, 300, nil)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "track02.raw"), bytes.Repeat([]byte{0xaa}, 300*gdi.SectorSize), 0644))
+	writeDataTrack(t, filepath.Join(dir, "track03.bin"), gdi.TrackThreeStart, 20, b)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.gdi"), []byte("3\n1 0 4 2352 track01.bin 0\n2 450 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n"), 0644))
+
+	reader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tracks := g.Tracks()
+	if assert.Len(t, tracks, 3) {
+		assert.Equal(t, 450, tracks[1].Start)
+		assert.True(t, tracks[1].IsAudioTrack())
+	}
+
+	length, err := g.TrackLength(3)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, length)
+
+	_, err = g.TrackLength(4)
+	assert.Equal(t, errNoSuchTrack, err)
+
+	track, err := g.OpenTrack(2)
+	if assert.Nil(t, err) {
+		n, err := io.Copy(ioutil.Discard, track)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(300*gdi.SectorSize), n)
+		track.Close()
+	}
+
+	raw, err := g.NewSectorReader(true)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer raw.Close()
+
+	assert.Equal(t, gdi.SectorSize, raw.SectorSize())
+	assert.Equal(t, highDensityEnd, raw.Sectors())
+
+	s, err := raw.ReadSector(gdi.TrackThreeStart + 19)
+	assert.Nil(t, err)
+	assert.Equal(t, gdi.TrackThreeStart+19, sector.Address(s))
+
+	s, err = raw.ReadSector(500)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xaa}, gdi.SectorSize), s)
+
+	// Between the low and high density areas
+	s, err = raw.ReadSector(1000)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, gdi.SectorSize), s)
+
+	// The high density area ends 150 sectors before the end given in
+	// the IP.BIN TOC, as the TOC is addressed 150 sectors ahead
+	assert.Equal(t, lastSector-pauseData, raw.Sectors())
+
+	s, err = raw.ReadSector(highDensityEnd - 1)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, gdi.SectorSize), s)
+
+	_, err = raw.ReadSector(highDensityEnd)
+	assert.Equal(t, errInvalidLBA, err)
+
+	n, err := raw.ReadAt(make([]byte, 1), raw.Size())
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	cooked, err := g.NewSectorReader(false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cooked.Close()
+
+	assert.Equal(t, int64(highDensityEnd)*sector.DataSize, cooked.Size())
+
+	// Read across the boundary between two sectors, going backwards to
+	// reopen the track
+	p := make([]byte, 4)
+	for _, lba := range []int{299, 10} {
+		n, err := cooked.ReadAt(p, int64(lba)*sector.DataSize-2)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, []byte{byte(lba - 1), byte(lba - 1), byte(lba), byte(lba)}, p)
+	}
+
+	s, err = cooked.ReadSector(gdi.TrackThreeStart)
+	assert.Nil(t, err)
+	assert.Equal(t, b[:sector.DataSize], s)
+
+	_, err = cooked.ReadSector(450)
+	assert.Equal(t, errNotDataSector, err)
+
+	n, err = cooked.ReadAt(p, cooked.Size()-2)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+}
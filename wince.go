@@ -0,0 +1,124 @@
+package dreamcast
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// WinCEBootFilename is the boot file used by Windows CE titles, it loads
+// the Windows CE kernel before the game itself
+const WinCEBootFilename = "0WINCEOS.BIN"
+
+// WinCESignature matches the version string of the Windows CE loader. The
+// first submatch is the version. The pattern is a heuristic rather than
+// one taken from a known loader; it looks for the "Windows CE" product
+// name followed closely by a "Version x.y" or "vx.y.z" string as found in
+// the version resources and banners of Windows CE binaries. It is matched
+// against the contents of the loader so can be replaced if a more specific
+// pattern is needed
+var WinCESignature = regexp.MustCompile(`Windows CE[ -~]{0,32}?[Vv](?:ersion)? ?([0-9]+\.[0-9]+(?:\.[0-9]+)?)`)
+
+// winCEWindow is the number of bytes kept from the previous write when
+// scanning so a signature can span the boundary between two writes
+const winCEWindow = 64
+
+// WinCE reports how a game uses Windows CE. Each field is a separate
+// signal, any one of which means the game uses Windows CE
+type WinCE struct {
+	// Peripheral is true if the Windows CE peripheral flag is set
+	Peripheral bool
+	// BootFile is true if the boot file is 0WINCEOS.BIN
+	BootFile bool
+	// Loader is the version of the Windows CE loader found in the data
+	// track, it is empty if no loader was found
+	Loader string
+}
+
+// IsWinCE returns true if the game uses Windows CE
+func (w WinCE) IsWinCE() bool {
+	return w.Peripheral || w.BootFile || w.Loader != ""
+}
+
+// WinCE returns how the game uses Windows CE. The loader is searched for
+// regardless of the IP.BIN peripheral flag and boot file, however rather
+// than scanning the whole data track only the boot file and 0WINCEOS.BIN,
+// if the disc has one, are scanned
+func (g Game) WinCE() (*WinCE, error) {
+	w := &WinCE{
+		Peripheral: PeripheralWindowsCE.IsSet(g.IPBin.Peripherals),
+		BootFile:   strings.EqualFold(g.IPBin.BootFilename, WinCEBootFilename),
+	}
+
+	var err error
+	if w.Loader, err = g.findWinCELoader(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// winCEScanner is an io.Writer that searches everything written to it for
+// the Windows CE loader signature
+type winCEScanner struct {
+	b       []byte
+	version string
+}
+
+func (s *winCEScanner) Write(p []byte) (int, error) {
+	if s.version != "" {
+		return len(p), nil
+	}
+
+	s.b = append(s.b, p...)
+	if m := WinCESignature.FindSubmatch(s.b); m != nil {
+		s.version = string(m[1])
+		s.b = nil
+		return len(p), nil
+	}
+
+	if len(s.b) > winCEWindow {
+		s.b = append(s.b[:0], s.b[len(s.b)-winCEWindow:]...)
+	}
+
+	return len(p), nil
+}
+
+// findWinCELoader scans the boot file for the Windows CE loader signature
+// and returns the version. The boot file is descrambled first if needed,
+// as it would be on a CD. If the boot file isn't 0WINCEOS.BIN then that
+// file is scanned too
+func (g Game) findWinCELoader() (string, error) {
+	s := new(winCEScanner)
+	if err := g.WriteBootFile(s, false); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if s.version != "" || strings.EqualFold(g.IPBin.BootFilename, WinCEBootFilename) {
+		return s.version, nil
+	}
+
+	fsys, err := g.FS()
+	if err != nil {
+		return "", err
+	}
+	defer fsys.Close()
+
+	f, err := fsys.Open(WinCEBootFilename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	s = new(winCEScanner)
+	if _, err := io.Copy(s, f); err != nil {
+		return "", err
+	}
+
+	return s.version, nil
+}
@@ -0,0 +1,111 @@
+package dreamcast
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/iso9660"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWinCESignature(t *testing.T) {
+	tables := []struct {
+		s, version string
+	}{
+		{"\x00Windows CE Kernel Version 2.12\x00", "2.12"},
+		{"Windows CE for Dreamcast v1.0.1", "1.0.1"},
+		{"Windows CE", ""},
+		{"Windows NT Version 4.0", ""},
+	}
+
+	for _, table := range tables {
+		version := ""
+		if m := WinCESignature.FindStringSubmatch(table.s); m != nil {
+			version = m[1]
+		}
+		assert.Equal(t, table.version, version, table.s)
+	}
+
+	assert.False(t, WinCE{}.IsWinCE())
+	assert.True(t, WinCE{Peripheral: true}.IsWinCE())
+	assert.True(t, WinCE{Loader: "2.12"}.IsWinCE())
+}
+
+func TestWinCE(t *testing.T) {
+	// The signature spans the boundary between two reads of the boot
+	// file
+	boot := make([]byte, 64<<10)
+	copy(boot[32<<10-10:], "Windows CE Kernel Version 2.12")
+
+	// Another file with a signature that should never be found
+	other := make([]byte, 1<<10)
+	copy(other, "Windows CE Kernel Version 9.99")
+
+	scrambled := new(bytes.Buffer)
+	if !assert.Nil(t, Scramble(scrambled, bytes.NewReader(boot), int64(len(boot)))) {
+		return
+	}
+
+	tables := []struct {
+		peripherals  uint32
+		bootFilename string
+		boot         []byte
+		files        map[string][]byte
+		wince        WinCE
+	}{
+		// The loader is found even if neither flag says Windows CE
+		{defaultPeripherals, defaultBootFilename, boot, nil, WinCE{Loader: "2.12"}},
+		{defaultPeripherals | uint32(PeripheralWindowsCE), defaultBootFilename, boot, nil, WinCE{Peripheral: true, Loader: "2.12"}},
+		{defaultPeripherals, WinCEBootFilename, boot, nil, WinCE{BootFile: true, Loader: "2.12"}},
+		{defaultPeripherals | uint32(PeripheralWindowsCE), WinCEBootFilename, scrambled.Bytes(), nil, WinCE{Peripheral: true, BootFile: true, Loader: "2.12"}},
+		// The loader is found in 0WINCEOS.BIN if it isn't the boot file
+		{defaultPeripherals, defaultBootFilename, make([]byte, 1<<10), map[string][]byte{WinCEBootFilename: boot}, WinCE{Loader: "2.12"}},
+		// Only the boot file and 0WINCEOS.BIN are scanned
+		{defaultPeripherals, defaultBootFilename, make([]byte, 1<<10), nil, WinCE{}},
+		{defaultPeripherals | uint32(PeripheralWindowsCE), WinCEBootFilename, make([]byte, 1<<10), nil, WinCE{Peripheral: true, BootFile: true}},
+	}
+
+	for _, table := range tables {
+		ip, err := NewIPBin(make([]byte, ipBinLength))
+		if !assert.Nil(t, err) {
+			return
+		}
+		ip.Peripherals, ip.BootFilename = table.peripherals, table.bootFilename
+
+		files := map[string][]byte{
+			table.bootFilename: table.boot,
+			"OTHER.BIN":        other,
+		}
+		for name, b := range table.files {
+			files[name] = b
+		}
+
+		v := iso9660.NewVolume(gdi.TrackThreeStart)
+		for name, b := range files {
+			assert.Nil(t, v.AddFile(name, int64(len(b)), time.Time{}))
+		}
+
+		dir := t.TempDir()
+		writeFSGame(t, dir, ip, v, files)
+
+		reader, err := NewDirectoryReader(dir)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer reader.Close()
+
+		g, err := NewGame(reader)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		w, err := g.WinCE()
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Equal(t, table.wince, *w)
+		assert.Equal(t, table.wince.Peripheral || table.wince.BootFile || table.wince.Loader != "", w.IsWinCE())
+	}
+}
@@ -2,15 +2,26 @@ package dreamcast
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"time"
 
+	"github.com/bodgit/dreamcast/cdi"
+	"github.com/bodgit/dreamcast/chd"
+	"github.com/bodgit/dreamcast/ecm"
 	"github.com/bodgit/dreamcast/gdi"
+	"github.com/bodgit/dreamcast/sector"
 	"github.com/bodgit/plumbing"
 )
 
+var errAudioAfterData = errors.New("audio track after data track")
+
 // Writer is the interface implemented by an object that can be used as a
 // destination for writing a Dreamcast game image to disk
 type Writer interface {
@@ -24,6 +35,27 @@ type Writer interface {
 	Tx() uint64
 }
 
+// ModTimeWriter is implemented by a Writer that can also set the
+// modification time of each file it creates
+type ModTimeWriter interface {
+	Writer
+	// CreateFileModTime returns an io.WriteCloser opened on the named
+	// file, which is given the passed modification time
+	CreateFileModTime(string, time.Time) (io.WriteCloser, error)
+}
+
+// DirWriter is implemented by a Writer that can also create directories,
+// so even empty ones are kept, and set their modification time
+type DirWriter interface {
+	Writer
+	// CreateDir creates the named directory, along with any missing
+	// parent directories
+	CreateDir(string) error
+	// SetDirModTime sets the modification time of the named directory,
+	// this should be done after anything is written to it
+	SetDirModTime(string, time.Time) error
+}
+
 // WriterConfig contains the configuration of the Writer
 type WriterConfig struct {
 	// CueFile is the target filename for a cue file
@@ -34,9 +66,20 @@ type WriterConfig struct {
 	// a gdi.Track object and returns a string representing the desired
 	// filename
 	TrackRename func(gdi.Track) string
+	// Redump controls whether the tracks are written in the Redump
+	// layout, with the pause and pregap sectors stored at the start of
+	// each track file, rather than the TOSEC layout
+	Redump bool
 	// TrimWhitespace controls whether extra passing whitespace is removed
 	// from either the GDI or cue file where applicable
 	TrimWhitespace bool
+	// ECM controls whether data tracks are encoded as ECM files, the ECM
+	// extension is appended to the filename of each track but the GDI or
+	// cue file still refers to the original filename
+	ECM bool
+	// ManifestFile is the target filename for the manifest listing the
+	// LBA and size of each file extracted by Game.Extract
+	ManifestFile string
 }
 
 // GDemuTrackName is a track renaming function that names each track how a
@@ -79,16 +122,61 @@ func (w DirectoryWriter) Close() error {
 	return nil
 }
 
-// CreateFile creates the named file in the directory and returns an
-// io.WriteCloser for it
+// CreateFile creates the named file in the directory, along with any
+// missing parent directories, and returns an io.WriteCloser for it
 func (w *DirectoryWriter) CreateFile(filename string) (io.WriteCloser, error) {
-	file, err := os.Create(filepath.Join(w.directory, filename))
+	name := filepath.Join(w.directory, filepath.FromSlash(filename))
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(name)
 	if err != nil {
 		return nil, err
 	}
 	return plumbing.MultiWriteCloser(file, plumbing.NopWriteCloser(&w.tx)), nil
 }
 
+// modTimeWriteCloser sets the modification time of the file once closed
+type modTimeWriteCloser struct {
+	io.WriteCloser
+	name    string
+	modTime time.Time
+}
+
+func (w *modTimeWriteCloser) Close() error {
+	if w.WriteCloser == nil {
+		return nil
+	}
+	err := w.WriteCloser.Close()
+	w.WriteCloser = nil
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(w.name, w.modTime, w.modTime)
+}
+
+// CreateFileModTime is like CreateFile however the file is given the
+// passed modification time once closed
+func (w *DirectoryWriter) CreateFileModTime(filename string, modTime time.Time) (io.WriteCloser, error) {
+	file, err := w.CreateFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &modTimeWriteCloser{file, filepath.Join(w.directory, filepath.FromSlash(filename)), modTime}, nil
+}
+
+// CreateDir creates the named directory in the directory, along with any
+// missing parent directories
+func (w *DirectoryWriter) CreateDir(name string) error {
+	return os.MkdirAll(filepath.Join(w.directory, filepath.FromSlash(name)), os.ModePerm)
+}
+
+// SetDirModTime sets the modification time of the named directory
+func (w *DirectoryWriter) SetDirModTime(name string, modTime time.Time) error {
+	return os.Chtimes(filepath.Join(w.directory, filepath.FromSlash(name)), modTime, modTime)
+}
+
 // Config returns the WriterConfig associated with this writer
 func (w DirectoryWriter) Config() WriterConfig {
 	return w.config
@@ -99,12 +187,15 @@ func (w DirectoryWriter) Tx() uint64 {
 	return w.tx.Count()
 }
 
-// ZipFileWriter writes a Dreamcast game to a zip archive
+// ZipFileWriter writes a Dreamcast game to a zip archive. Directories are
+// written as "name/" entries when the writer is closed so they can be given
+// their modification time after anything is written to them
 type ZipFileWriter struct {
 	file   *os.File
 	writer *zip.Writer
 	config WriterConfig
 	tx     plumbing.WriteCounter
+	dirs   map[string]time.Time
 }
 
 // NewZipFileWriter returns a ZipFileWriter using the passed zip file path
@@ -118,14 +209,31 @@ func NewZipFileWriter(filename string, config WriterConfig) (*ZipFileWriter, err
 	w := &ZipFileWriter{
 		file:   file,
 		config: config,
+		dirs:   make(map[string]time.Time),
 	}
 	w.writer = zip.NewWriter(io.MultiWriter(file, &w.tx))
 
 	return w, nil
 }
 
-// Close closes the zip file
+// Close writes any directories and closes the zip file
 func (w ZipFileWriter) Close() error {
+	names := make([]string, 0, len(w.dirs))
+	for name := range w.dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := w.writer.CreateHeader(&zip.FileHeader{
+			Name:     name + "/",
+			Method:   zip.Store,
+			Modified: w.dirs[name],
+		}); err != nil {
+			return err
+		}
+	}
+
 	if err := w.writer.Close(); err != nil {
 		return err
 	}
@@ -143,6 +251,40 @@ func (w ZipFileWriter) CreateFile(filename string) (io.WriteCloser, error) {
 	return plumbing.NopWriteCloser(writer), nil
 }
 
+// CreateFileModTime is like CreateFile however the file is given the
+// passed modification time
+func (w ZipFileWriter) CreateFileModTime(filename string, modTime time.Time) (io.WriteCloser, error) {
+	writer, err := w.writer.CreateHeader(&zip.FileHeader{
+		Name:     filename,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plumbing.NopWriteCloser(writer), nil
+}
+
+// CreateDir adds the named directory to the zip file, along with any
+// missing parent directories
+func (w ZipFileWriter) CreateDir(name string) error {
+	for ; name != "." && name != "/"; name = path.Dir(name) {
+		if _, ok := w.dirs[name]; !ok {
+			w.dirs[name] = time.Time{}
+		}
+	}
+	return nil
+}
+
+// SetDirModTime sets the modification time of the named directory
+func (w ZipFileWriter) SetDirModTime(name string, modTime time.Time) error {
+	if _, ok := w.dirs[name]; !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	w.dirs[name] = modTime
+	return nil
+}
+
 // Config returns the WriterConfig associated with this writer
 func (w ZipFileWriter) Config() WriterConfig {
 	return w.config
@@ -152,3 +294,318 @@ func (w ZipFileWriter) Config() WriterConfig {
 func (w ZipFileWriter) Tx() uint64 {
 	return w.tx.Count()
 }
+
+// CDIWriter writes a Dreamcast game to a DiscJuggler CDI image. Any audio
+// tracks are placed in the first session and the data track in the second
+// session, as is usual for a selfboot disc. The tracks are stored as raw
+// 2352 byte sectors with a pregap generated before each one.
+type CDIWriter struct {
+	file    *os.File
+	writer  *cdi.Writer
+	config  WriterConfig
+	tx      plumbing.WriteCounter
+	session int
+	lba     int
+	data    bool
+}
+
+// NewCDIWriter returns a CDIWriter using the passed CDI image path and
+// config. The GDI and cue files and any track renaming in the config are
+// ignored as the image describes the tracks itself, as is ECM encoding.
+func NewCDIWriter(filename string, config WriterConfig) (*CDIWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config.GDIFile, config.CueFile = "", ""
+	config.TrackRename = GDemuTrackName
+	config.ECM = false
+
+	w := &CDIWriter{
+		file:   file,
+		config: config,
+	}
+	w.writer = cdi.NewWriter(io.MultiWriter(file, &w.tx))
+
+	return w, nil
+}
+
+// Close writes the descriptors and closes the CDI image
+func (w CDIWriter) Close() error {
+	if err := w.writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// CreateFile adds a new track to the CDI image and returns an
+// io.WriteCloser for it. The track type is inferred from the filename
+// extension as returned by GDemuTrackName
+func (w *CDIWriter) CreateFile(filename string) (io.WriteCloser, error) {
+	var audio bool
+	switch filepath.Ext(filename) {
+	case ".raw":
+		audio = true
+	case ".bin":
+	default:
+		return nil, errInvalidType
+	}
+
+	start := 0
+	switch {
+	case w.session == 0:
+		w.session = 1
+	case audio && w.data:
+		return nil, errAudioAfterData
+	case !audio && !w.data:
+		// The data track starts the second session
+		if err := w.writer.NewSession(); err != nil {
+			return nil, err
+		}
+		w.session++
+		start = w.lba + sessionGap(w.session-1) + pauseData
+	default:
+		start = w.lba + pauseData
+	}
+	w.data = w.data || !audio
+
+	return &cdiTrackWriter{
+		w:     w,
+		audio: audio,
+		start: start,
+		buf:   make([]byte, 0, gdi.SectorSize),
+	}, nil
+}
+
+// Config returns the WriterConfig associated with this writer
+func (w CDIWriter) Config() WriterConfig {
+	return w.config
+}
+
+// Tx returns the number of bytes written
+func (w CDIWriter) Tx() uint64 {
+	return w.tx.Count()
+}
+
+// cdiTrackWriter holds back the first sector of a track until the mode is
+// known so the track and its pregap can be created
+type cdiTrackWriter struct {
+	w     *CDIWriter
+	dst   io.Writer
+	audio bool
+	start int
+	buf   []byte
+	n     int
+}
+
+func (t *cdiTrackWriter) create() error {
+	track := cdi.Track{
+		Mode:       cdi.ModeAudio,
+		SectorSize: gdi.SectorSize,
+		Pregap:     pauseData,
+		Start:      t.start,
+	}
+
+	mode := sector.ModeUnknown
+	if !t.audio {
+		switch mode = sector.ModeOf(t.buf); mode {
+		case sector.Mode1:
+			track.Mode = cdi.Mode1
+		case sector.Mode2Form1, sector.Mode2Form2:
+			track.Mode, mode = cdi.Mode2, sector.Mode2Form1
+		default:
+			return errInvalidType
+		}
+	}
+
+	var err error
+	if t.dst, err = t.w.writer.CreateTrack(track); err != nil {
+		return err
+	}
+
+	return writeSectors(t.dst, mode, t.start-pauseData, pauseData)
+}
+
+func (t *cdiTrackWriter) Write(p []byte) (int, error) {
+	n := 0
+	if t.dst == nil {
+		n = copy(t.buf[len(t.buf):cap(t.buf)], p)
+		t.buf = t.buf[:len(t.buf)+n]
+		if len(t.buf) < cap(t.buf) {
+			return n, nil
+		}
+
+		if err := t.create(); err != nil {
+			return 0, err
+		}
+
+		if _, err := t.dst.Write(t.buf); err != nil {
+			return 0, err
+		}
+		t.n += len(t.buf)
+	}
+
+	m, err := t.dst.Write(p[n:])
+	t.n += m
+	return n + m, err
+}
+
+func (t *cdiTrackWriter) Close() error {
+	if t.dst == nil || t.n%gdi.SectorSize != 0 {
+		return errInvalidSize
+	}
+
+	t.w.lba = t.start + t.n/gdi.SectorSize
+
+	return nil
+}
+
+// CHDWriter writes a Dreamcast game to a CHD image using the same GD-ROM
+// track layout as chdman, so the data and metadata SHA-1 hashes match
+// those of chdman although the image itself differs. The tracks are stored
+// back to back, only padded to a multiple of four frames, and the gaps
+// between them are implied by the GD-ROM layout
+type CHDWriter struct {
+	file   *os.File
+	writer *chd.Writer
+	config WriterConfig
+	tx     plumbing.WriteCounter
+}
+
+// NewCHDWriter returns a CHDWriter using the passed CHD image path and
+// config. The GDI and cue files and any track renaming in the config are
+// ignored as the image describes the tracks itself, as is ECM encoding.
+// The tracks are always written using the TOSEC layout.
+func NewCHDWriter(filename string, config WriterConfig) (*CHDWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config.GDIFile, config.CueFile = "", ""
+	config.TrackRename = GDemuTrackName
+	config.Redump, config.ECM = false, false
+
+	w := &CHDWriter{
+		file:   file,
+		config: config,
+	}
+
+	if w.writer, err = chd.NewWriter(&countingWriteSeeker{file, &w.tx}, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Close writes the hunk map and track metadata and closes the CHD image
+func (w CHDWriter) Close() error {
+	if err := w.writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// CreateFile adds a new track to the CHD image and returns an
+// io.WriteCloser for it. The track type is inferred from the filename
+// extension as returned by GDemuTrackName
+func (w *CHDWriter) CreateFile(filename string) (io.WriteCloser, error) {
+	track := chd.Track{
+		SubType:       "NONE",
+		PregapType:    "MODE1",
+		PregapSubType: "RW",
+	}
+
+	switch filepath.Ext(filename) {
+	case ".raw":
+		track.Type = "AUDIO"
+	case ".bin":
+		track.Type = "MODE1_RAW"
+	default:
+		return nil, errInvalidType
+	}
+
+	dst, err := w.writer.CreateTrack(track)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chdTrackWriter{
+		dst: dst,
+	}, nil
+}
+
+// Config returns the WriterConfig associated with this writer
+func (w CHDWriter) Config() WriterConfig {
+	return w.config
+}
+
+// Tx returns the number of bytes written
+func (w CHDWriter) Tx() uint64 {
+	return w.tx.Count()
+}
+
+type chdTrackWriter struct {
+	dst io.Writer
+	n   int
+}
+
+func (t *chdTrackWriter) Write(p []byte) (int, error) {
+	n, err := t.dst.Write(p)
+	t.n += n
+	return n, err
+}
+
+func (t *chdTrackWriter) Close() error {
+	if t.n%gdi.SectorSize != 0 {
+		return errInvalidSize
+	}
+
+	return nil
+}
+
+// ecmWriteCloser encodes a track as an ECM file before closing the file
+type ecmWriteCloser struct {
+	*ecm.Writer
+	file io.Closer
+}
+
+func (w ecmWriteCloser) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+func createTrackFile(writer Writer, track gdi.Track) (io.WriteCloser, error) {
+	if !writer.Config().ECM || !track.IsDataTrack() {
+		return writer.CreateFile(track.Name)
+	}
+
+	file, err := writer.CreateFile(track.Name + ecm.Extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return ecmWriteCloser{ecm.NewWriter(file), file}, nil
+}
+
+// countingWriteSeeker counts the bytes written to an io.WriteSeeker
+type countingWriteSeeker struct {
+	io.WriteSeeker
+	tx io.Writer
+}
+
+func (w *countingWriteSeeker) Write(p []byte) (int, error) {
+	n, err := w.WriteSeeker.Write(p)
+	w.tx.Write(p[:n])
+	return n, err
+}
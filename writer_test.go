@@ -0,0 +1,242 @@
+package dreamcast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/dreamcast/chd"
+	"github.com/bodgit/dreamcast/gdi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCHDWriter(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// None of the tracks are a multiple of four frames
+	dir := t.TempDir()
+	writeDataTrack(t, filepath.Join(dir, "track01.bin"), 0, 302, nil)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "track02.raw"), bytes.Repeat([]byte{0xaa}, 303*gdi.SectorSize), 0644))
+	writeDataTrack(t, filepath.Join(dir, "track03.bin"), gdi.TrackThreeStart, 21, b)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.gdi"), []byte("3\n1 0 4 2352 track01.bin 0\n2 452 0 2352 track02.raw 0\n3 45000 4 2352 track03.bin 0\n"), 0644))
+
+	reader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	name := filepath.Join(t.TempDir(), "disc.chd")
+	writer, err := NewCHDWriter(name, WriterConfig{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Write(writer))
+	assert.Nil(t, writer.Close())
+
+	// chdman stores the tracks back to back with no gaps, each padded to
+	// a multiple of four frames with the padding recorded as PAD
+	f, err := os.Open(name)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+
+	image, err := chd.NewImage(f)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, true, image.GDROM)
+	assert.Equal(t, []chd.Track{
+		{Number: 1, Type: "MODE1_RAW", SubType: "NONE", Frames: 302, Pad: 2, PregapType: "MODE1", PregapSubType: "RW", Offset: 0},
+		{Number: 2, Type: "AUDIO", SubType: "NONE", Frames: 303, Pad: 1, PregapType: "MODE1", PregapSubType: "RW", Offset: 304},
+		{Number: 3, Type: "MODE1_RAW", SubType: "NONE", Frames: 21, Pad: 3, PregapType: "MODE1", PregapSubType: "RW", Offset: 608},
+	}, image.Tracks)
+	assert.Equal(t, uint64(632*(gdi.SectorSize+96)), image.LogicalBytes)
+
+	// Reading the image back recreates the same layout and tracks
+	r, err := NewCHDReader(name)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer r.Close()
+
+	chdGame, err := NewGame(r)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, g.Tracks(), chdGame.Tracks())
+
+	for _, track := range g.Tracks() {
+		expected, err := ioutil.ReadFile(filepath.Join(dir, track.Name))
+		assert.Nil(t, err)
+
+		rc, err := chdGame.OpenTrack(track.Number)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+		rc.Close()
+	}
+}
+
+func TestCDIWriter(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	start := 300 + firstLeadOut + leadIn + pauseData
+
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "track01.raw"), bytes.Repeat([]byte{0x11, 0x22}, 300*gdi.SectorSize/2), 0644))
+	writeDataTrack(t, filepath.Join(dir, "track02.bin"), start, 20, b)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "disc.cue"), []byte("REM SESSION 01\nFILE \"track01.raw\" BINARY\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\nREM SESSION 02\nFILE \"track02.bin\" BINARY\n  TRACK 02 MODE1/2352\n    PREGAP 00:02:00\n    INDEX 01 00:00:00\n"), 0644))
+
+	reader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	name := filepath.Join(t.TempDir(), "disc.cdi")
+	writer, err := NewCDIWriter(name, WriterConfig{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Write(writer))
+	assert.Nil(t, writer.Close())
+
+	// Reading the image back recreates the same layout and tracks
+	r, err := NewCDIReader(name)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer r.Close()
+
+	// The audio track is in the first session and the data track in the
+	// second
+	if assert.Len(t, r.Sessions(), 2) {
+		assert.Len(t, r.Sessions()[0].Tracks, 1)
+		assert.Len(t, r.Sessions()[1].Tracks, 1)
+	}
+
+	cdiGame, err := NewGame(r)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, LayoutCD, cdiGame.Layout())
+	assert.Equal(t, g.Tracks(), cdiGame.Tracks())
+
+	for _, track := range g.Tracks() {
+		expected, err := ioutil.ReadFile(filepath.Join(dir, track.Name))
+		assert.Nil(t, err)
+
+		rc, err := cdiGame.OpenTrack(track.Number)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, b)
+		rc.Close()
+	}
+}
+
+func TestECMWriter(t *testing.T) {
+	ip, err := NewIPBin(make([]byte, ipBinLength))
+	if !assert.Nil(t, err) {
+		return
+	}
+	b, err := ip.MarshalBinary()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	dir := t.TempDir()
+	writeGame(t, dir, b)
+	original := readDir(t, dir)
+
+	reader, err := NewDirectoryReader(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	g, err := NewGame(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	out := t.TempDir()
+	writer, err := NewDirectoryWriter(out, WriterConfig{GDIFile: "disc.gdi", ECM: true})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, g.Write(writer))
+
+	// Only the data tracks are encoded
+	files := readDir(t, out)
+	for _, name := range []string{"track01.bin.ecm", "track02.raw", "track03.bin.ecm"} {
+		_, ok := files[name]
+		assert.True(t, ok, name)
+	}
+	for _, name := range []string{"track01.bin", "track03.bin"} {
+		_, ok := files[name]
+		assert.False(t, ok, name)
+	}
+	assert.Equal(t, original["track02.raw"], files["track02.raw"])
+
+	// Reading the tracks back decodes them to the originals
+	ecmReader, err := NewDirectoryReader(out)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer ecmReader.Close()
+
+	ecmGame, err := NewGame(NewECMReader(ecmReader))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, g.Tracks(), ecmGame.Tracks())
+
+	for _, track := range ecmGame.Tracks() {
+		rc, err := ecmGame.OpenTrack(track.Number)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, original[track.Name], b, track.Name)
+		rc.Close()
+	}
+}